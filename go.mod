@@ -17,4 +17,6 @@ require (
 	gopkg.in/yaml.v2 v2.3.0
 )
 
+replace github.com/alphagov/paas-service-broker-base => ./third_party/paas-service-broker-base
+
 go 1.14
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"log"
 	"os"
+	"time"
 
 	"fmt"
 	"net"
@@ -12,19 +14,100 @@ import (
 
 	"code.cloudfoundry.org/lager"
 	"github.com/alphagov/paas-service-broker-base/broker"
+	"github.com/alphagov/paas-sqs-broker/metrics"
 	"github.com/alphagov/paas-sqs-broker/sqs"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/pivotal-cf/brokerapi/auth"
 )
 
 var configFilePath string
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "upgrade" {
+		upgrade(os.Args[2:])
+		return
+	}
+
 	flag.StringVar(&configFilePath, "config", "", "Location of the config file")
 	flag.Parse()
 
+	config, sqsClientConfig, sqsProvider := newProvider(configFilePath)
+
+	serviceBroker, err := broker.New(config, &sqs.InstrumentedProvider{Provider: sqsProvider}, sqsProvider.Logger)
+	if err != nil {
+		log.Fatalf("Error creating service broker: %s", err)
+	}
+
+	if sqsClientConfig.AccessKeyMaxAge > 0 {
+		rotator := &sqs.KeyRotator{
+			Provider:    sqsProvider,
+			MaxAge:      time.Duration(sqsClientConfig.AccessKeyMaxAge),
+			GracePeriod: time.Duration(sqsClientConfig.AccessKeyGracePeriod),
+			Interval:    time.Duration(sqsClientConfig.AccessKeyRotationInterval),
+			Logger:      sqsProvider.Logger.Session("key-rotator"),
+		}
+		go rotator.Run(context.Background())
+	}
+
+	if sqsClientConfig.ReconcileInterval > 0 {
+		reconciler := &sqs.Reconciler{
+			Provider:     sqsProvider,
+			Interval:     time.Duration(sqsClientConfig.ReconcileInterval),
+			Cleanup:      sqsClientConfig.ReconcileCleanup,
+			MaxDeletions: sqsClientConfig.ReconcileMaxDeletions,
+			MinAge:       time.Duration(sqsClientConfig.ReconcileMinAge),
+			Logger:       sqsProvider.Logger.Session("reconciler"),
+		}
+		go reconciler.Run(context.Background())
+	}
+
+	brokerAPI := http.NewServeMux()
+	brokerAPI.Handle("/", broker.NewAPI(serviceBroker, sqsProvider.Logger, config))
+
+	metricsHandler := metrics.Default.Handler()
+	if sqsClientConfig.MetricsUsername != "" {
+		metricsHandler = auth.NewWrapper(
+			sqsClientConfig.MetricsUsername,
+			sqsClientConfig.MetricsPassword,
+		).Wrap(metricsHandler)
+	} else if sqsClientConfig.MetricsPort == "" {
+		metricsHandler = auth.NewWrapper(
+			config.API.BasicAuthUsername,
+			config.API.BasicAuthPassword,
+		).Wrap(metricsHandler)
+	}
+	if sqsClientConfig.MetricsPort != "" {
+		metricsAPI := http.NewServeMux()
+		metricsAPI.Handle("/metrics", metricsHandler)
+		metricsListener, err := net.Listen("tcp", ":"+sqsClientConfig.MetricsPort)
+		if err != nil {
+			log.Fatalf("Error listening to port %s: %s", sqsClientConfig.MetricsPort, err)
+		}
+		go func() {
+			if err := http.Serve(metricsListener, metricsAPI); err != nil {
+				log.Fatalf("Error serving metrics: %s", err)
+			}
+		}()
+	} else {
+		brokerAPI.Handle("/metrics", metricsHandler)
+	}
+
+	listener, err := net.Listen("tcp", ":"+config.API.Port)
+	if err != nil {
+		log.Fatalf("Error listening to port %s: %s", config.API.Port, err)
+	}
+	fmt.Println("SQS Service Broker started on port " + config.API.Port + "...")
+	if err := http.Serve(listener, brokerAPI); err != nil {
+		log.Fatalf("Error opening config file %s: %s\n", configFilePath, err)
+	}
+}
+
+// newProvider reads the config file and returns the broker config, the
+// sqs config and an sqs.Provider configured from them.
+func newProvider(configFilePath string) (broker.Config, *sqs.Config, *sqs.Provider) {
 	file, err := os.Open(configFilePath)
 	if err != nil {
 		log.Fatalf("Error opening config file %s: %s\n", configFilePath, err)
@@ -46,6 +129,31 @@ func main() {
 		log.Fatalf("Error parsing configuration: %v\n", err)
 	}
 
+	planConfigs := map[string]*sqs.PlanConfig{}
+	for _, service := range config.Catalog.Catalog.Services {
+		for i, plan := range service.Plans {
+			planConfig, err := sqs.NewPlanConfig(plan)
+			if err != nil {
+				log.Fatalf("Error validating catalog: %v\n", err)
+			}
+			if planConfig.BindingMode == sqs.BindingModeRole && sqsClientConfig.RoleTrustedPrincipal == "" {
+				log.Fatalf("Error validating catalog: plan %s has role bindings but role_trusted_principal is not set\n", plan.Name)
+			}
+			if err := planConfig.CheckAccessPolicies(sqsClientConfig.AccessPolicies); err != nil {
+				log.Fatalf("Error validating catalog: plan %s: %v\n", plan.Name, err)
+			}
+			planConfigs[plan.ID] = planConfig
+			// publish the parameter schemas unless the operator has
+			// written their own
+			if plan.Schemas == nil {
+				service.Plans[i].Schemas, err = sqs.PlanSchemas(plan, sqsClientConfig.AccessPolicies)
+				if err != nil {
+					log.Fatalf("Error generating plan schemas: %v\n", err)
+				}
+			}
+		}
+	}
+
 	logger := lager.NewLogger("sqs-service-broker")
 	logger.RegisterSink(lager.NewWriterSink(os.Stdout, config.API.LagerLogLevel))
 
@@ -54,8 +162,10 @@ func main() {
 	}))
 	cfg := aws.NewConfig()
 	cfg = cfg.WithRegion(sqsClientConfig.AWSRegion)
+	// RetryingClient does the retrying
+	cfg = cfg.WithMaxRetries(0)
 
-	sqsProvider := &sqs.Provider{
+	awsClient := &sqs.RetryingClient{
 		Client: struct {
 			*secretsmanager.SecretsManager
 			*cloudformation.CloudFormation
@@ -63,27 +173,46 @@ func main() {
 			SecretsManager: secretsmanager.New(sess, cfg),
 			CloudFormation: cloudformation.New(sess, cfg),
 		},
-		Environment:          sqsClientConfig.DeployEnvironment,
-		ResourcePrefix:       sqsClientConfig.ResourcePrefix,
-		AdditionalUserPolicy: sqsClientConfig.AdditionalUserPolicy,
-		PermissionsBoundary:  sqsClientConfig.PermissionsBoundary,
-		Timeout:              sqsClientConfig.Timeout,
-		Logger:               logger,
+		MaxAttempts: sqsClientConfig.AWSMaxAttempts,
+		BaseDelay:   time.Duration(sqsClientConfig.AWSRetryBaseDelay),
+		MaxDelay:    time.Duration(sqsClientConfig.AWSRetryMaxDelay),
+		Logger:      logger.Session("aws-client"),
 	}
-
-	serviceBroker, err := broker.New(config, sqsProvider, logger)
-	if err != nil {
-		log.Fatalf("Error creating service broker: %s", err)
+	if sqsClientConfig.AWSRateLimit > 0 {
+		awsClient.Limiter = sqs.NewTokenBucket(sqsClientConfig.AWSRateLimit, sqsClientConfig.AWSRateBurst)
 	}
-
-	brokerAPI := broker.NewAPI(serviceBroker, logger, config)
-
-	listener, err := net.Listen("tcp", ":"+config.API.Port)
-	if err != nil {
-		log.Fatalf("Error listening to port %s: %s", config.API.Port, err)
+	if sqsClientConfig.CircuitBreakerThreshold > 0 {
+		awsClient.Breaker = &sqs.CircuitBreaker{
+			Threshold: sqsClientConfig.CircuitBreakerThreshold,
+			Cooldown:  time.Duration(sqsClientConfig.CircuitBreakerCooldown),
+		}
 	}
-	fmt.Println("SQS Service Broker started on port " + config.API.Port + "...")
-	if err := http.Serve(listener, brokerAPI); err != nil {
-		log.Fatalf("Error opening config file %s: %s\n", configFilePath, err)
+
+	sqsProvider := &sqs.Provider{
+		Client: sqs.NewStackCache(
+			awsClient,
+			time.Duration(sqsClientConfig.StackCacheInProgressTTL),
+		),
+		Environment:            sqsClientConfig.DeployEnvironment,
+		ResourcePrefix:         sqsClientConfig.ResourcePrefix,
+		AdditionalUserPolicy:   sqsClientConfig.AdditionalUserPolicy,
+		PermissionsBoundary:    sqsClientConfig.PermissionsBoundary,
+		AllowedKMSKeys:         sqsClientConfig.AllowedKMSKeys,
+		AllowedSNSAccountIDs:   sqsClientConfig.AllowedSNSAccountIDs,
+		AllowedSNSTopicARNs:    sqsClientConfig.AllowedSNSTopicARNs,
+		RequireSecureTransport: sqsClientConfig.RequireSecureTransport,
+		RoleTrustedPrincipal:   sqsClientConfig.RoleTrustedPrincipal,
+		SQSEndpoint:            sqsClientConfig.SQSEndpoint,
+		PlanConfigs:            planConfigs,
+		AccessPolicies:         sqsClientConfig.AccessPolicies,
+		AllowedNetworks:        sqsClientConfig.NetworkRestrictions,
+		SecretKMSKey:           sqsClientConfig.SecretKMSKey,
+		SecretRecoveryWindow:   sqsClientConfig.SecretRecoveryWindowDays,
+		SecretForceDelete:      sqsClientConfig.SecretForceDelete,
+		ContextTagKeys:         sqsClientConfig.ContextTagKeys,
+		Timeout:                sqsClientConfig.Timeout,
+		Logger:                 logger,
 	}
+
+	return config, sqsClientConfig, sqsProvider
 }
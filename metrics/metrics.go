@@ -0,0 +1,363 @@
+// Package metrics keeps the broker's metrics and serves them in the
+// Prometheus text exposition format.
+//
+// It only covers what the broker needs: counters, gauges and histograms
+// partitioned by labels.  It is meant to be replaced by
+// github.com/prometheus/client_golang, which the broker does not depend
+// on yet.  Metric names, labels and buckets follow client_golang's
+// conventions so that they carry over unchanged when it is.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Default is the registry that metrics created with NewCounter,
+// NewGauge and NewHistogram are registered with.
+var Default = NewRegistry()
+
+// A Metric can write itself in the Prometheus text format.
+type Metric interface {
+	Name() string
+	Write(w io.Writer) error
+}
+
+// Registry is a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]Metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]Metric{}}
+}
+
+// Register adds a metric to the registry.  It panics if a metric with
+// the same name is already registered, as that is a programming error.
+func (r *Registry) Register(m Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[m.Name()]; ok {
+		panic(fmt.Sprintf("metric %s is already registered", m.Name()))
+	}
+	r.metrics[m.Name()] = m
+}
+
+// Write writes every metric, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	names := []string{}
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := r.metrics
+	r.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		if err := metrics[name].Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Write(w)
+	})
+}
+
+// Counter is a count, such as of operations, partitioned by labels.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounter creates a counter and registers it with Default.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]*sample{},
+	}
+	Default.Register(c)
+	return c
+}
+
+func (c *Counter) Name() string {
+	return c.name
+}
+
+// Inc adds one to the count for the label values, which must be given
+// in the order the labels were declared.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the count for the label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", c.name, len(c.labels), len(labelValues)))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	s, ok := c.values[key]
+	if !ok {
+		s = &sample{labelValues: labelValues}
+		c.values[key] = s
+	}
+	s.value += v
+}
+
+// Value returns the count for the label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.values[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) Write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	keys := []string{}
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := c.values[key]
+		if _, err := fmt.Fprintf(w, "%s%s %v\n", c.name, formatLabels(c.labels, s.labelValues), s.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gauge is a value that can go up and down, such as a number of
+// stacks, partitioned by labels.
+type Gauge struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*sample
+}
+
+// NewGauge creates a gauge and registers it with Default.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]*sample{},
+	}
+	Default.Register(g)
+	return g
+}
+
+func (g *Gauge) Name() string {
+	return g.name
+}
+
+// Set sets the value for the label values, which must be given in the
+// order the labels were declared.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	if len(labelValues) != len(g.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", g.name, len(g.labels), len(labelValues)))
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[strings.Join(labelValues, "\xff")] = &sample{labelValues: labelValues, value: v}
+}
+
+// Reset removes the values for all label values, so that ones no
+// longer set are not written.
+func (g *Gauge) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values = map[string]*sample{}
+}
+
+// Value returns the value for the label values.
+func (g *Gauge) Value(labelValues ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if s, ok := g.values[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (g *Gauge) Write(w io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name); err != nil {
+		return err
+	}
+	keys := []string{}
+	for key := range g.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := g.values[key]
+		if _, err := fmt.Fprintf(w, "%s%s %v\n", g.name, formatLabels(g.labels, s.labelValues), s.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultBuckets are the upper bounds, in seconds, of histogram
+// buckets suited to the duration of requests.  They are the same as
+// client_golang's DefBuckets.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations, such as durations, in buckets,
+// partitioned by labels.
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	labels  []string
+	mu      sync.Mutex
+	values  map[string]*distribution
+}
+
+type distribution struct {
+	labelValues []string
+	// counts has the number of observations in each bucket, and then
+	// those bigger than the largest bucket
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram with buckets of the given upper
+// bounds, which must be sorted, and registers it with Default.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metric %s buckets are not sorted", name))
+	}
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		labels:  labels,
+		values:  map[string]*distribution{},
+	}
+	Default.Register(h)
+	return h
+}
+
+func (h *Histogram) Name() string {
+	return h.name
+}
+
+// Observe records v for the label values, which must be given in the
+// order the labels were declared.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", h.name, len(h.labels), len(labelValues)))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	d, ok := h.values[key]
+	if !ok {
+		d = &distribution{labelValues: labelValues, counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = d
+	}
+	d.counts[sort.SearchFloat64s(h.buckets, v)]++
+	d.count++
+	d.sum += v
+}
+
+// Count returns the number of observations for the label values.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if d, ok := h.values[strings.Join(labelValues, "\xff")]; ok {
+		return d.count
+	}
+	return 0
+}
+
+// Sum returns the sum of the observations for the label values.
+func (h *Histogram) Sum(labelValues ...string) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if d, ok := h.values[strings.Join(labelValues, "\xff")]; ok {
+		return d.sum
+	}
+	return 0
+}
+
+func (h *Histogram) Write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return err
+	}
+	keys := []string{}
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		d := h.values[key]
+		var cumulative uint64
+		for i, count := range d.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = fmt.Sprintf("%v", h.buckets[i])
+			}
+			bucketValues := append(append([]string{}, d.labelValues...), le)
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, bucketValues), cumulative); err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(h.labels, d.labelValues)
+		if _, err := fmt.Fprintf(w, "%s_sum%s %v\n%s_count%s %d\n", h.name, labels, d.sum, h.name, labels, d.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(values[i])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,132 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+
+	"github.com/alphagov/paas-sqs-broker/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Counter", func() {
+	var counter *metrics.Counter
+
+	BeforeEach(func() {
+		counter = metrics.NewCounter("test_total", "A test counter.", "result")
+	})
+
+	AfterEach(func() {
+		metrics.Default = metrics.NewRegistry()
+	})
+
+	It("counts per label value", func() {
+		counter.Inc("ok")
+		counter.Inc("ok")
+		counter.Add(3, "failed")
+		Expect(counter.Value("ok")).To(Equal(2.0))
+		Expect(counter.Value("failed")).To(Equal(3.0))
+		Expect(counter.Value("other")).To(BeZero())
+	})
+
+	It("writes the prometheus text format", func() {
+		counter.Inc("ok")
+		counter.Inc(`a "quoted" value`)
+		buf := &bytes.Buffer{}
+		Expect(metrics.Default.Write(buf)).To(Succeed())
+		Expect(buf.String()).To(Equal(`# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{result="a \"quoted\" value"} 1
+test_total{result="ok"} 1
+`))
+	})
+
+	It("serves the metrics over HTTP", func() {
+		counter.Inc("ok")
+		recorder := httptest.NewRecorder()
+		metrics.Default.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(recorder.Code).To(Equal(200))
+		Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+		Expect(recorder.Body.String()).To(ContainSubstring(`test_total{result="ok"} 1`))
+	})
+
+	It("refuses to register a metric twice", func() {
+		Expect(func() {
+			metrics.NewCounter("test_total", "Another test counter.")
+		}).To(Panic())
+	})
+})
+
+var _ = Describe("Gauge", func() {
+	var gauge *metrics.Gauge
+
+	BeforeEach(func() {
+		gauge = metrics.NewGauge("test_things", "A test gauge.", "state")
+	})
+
+	AfterEach(func() {
+		metrics.Default = metrics.NewRegistry()
+	})
+
+	It("sets values per label value", func() {
+		gauge.Set(3, "ok")
+		gauge.Set(2, "ok")
+		Expect(gauge.Value("ok")).To(Equal(2.0))
+		Expect(gauge.Value("other")).To(BeZero())
+	})
+
+	It("forgets values when reset", func() {
+		gauge.Set(3, "ok")
+		gauge.Reset()
+		gauge.Set(1, "failed")
+		buf := &bytes.Buffer{}
+		Expect(metrics.Default.Write(buf)).To(Succeed())
+		Expect(buf.String()).To(Equal(`# HELP test_things A test gauge.
+# TYPE test_things gauge
+test_things{state="failed"} 1
+`))
+	})
+})
+
+var _ = Describe("Histogram", func() {
+	var histogram *metrics.Histogram
+
+	BeforeEach(func() {
+		histogram = metrics.NewHistogram("test_seconds", "A test histogram.", []float64{1, 5}, "result")
+	})
+
+	AfterEach(func() {
+		metrics.Default = metrics.NewRegistry()
+	})
+
+	It("counts and sums observations per label value", func() {
+		histogram.Observe(0.5, "ok")
+		histogram.Observe(2, "ok")
+		Expect(histogram.Count("ok")).To(BeEquivalentTo(2))
+		Expect(histogram.Sum("ok")).To(Equal(2.5))
+		Expect(histogram.Count("other")).To(BeZero())
+	})
+
+	It("writes cumulative buckets in the prometheus text format", func() {
+		histogram.Observe(0.5, "ok")
+		histogram.Observe(1, "ok")
+		histogram.Observe(2, "ok")
+		histogram.Observe(10, "ok")
+		buf := &bytes.Buffer{}
+		Expect(metrics.Default.Write(buf)).To(Succeed())
+		Expect(buf.String()).To(Equal(`# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{result="ok",le="1"} 2
+test_seconds_bucket{result="ok",le="5"} 3
+test_seconds_bucket{result="ok",le="+Inf"} 4
+test_seconds_sum{result="ok"} 13.5
+test_seconds_count{result="ok"} 4
+`))
+	})
+
+	It("refuses buckets that are not sorted", func() {
+		Expect(func() {
+			metrics.NewHistogram("test_unsorted_seconds", "An unsorted histogram.", []float64{5, 1})
+		}).To(Panic())
+	})
+})
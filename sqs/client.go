@@ -2,6 +2,9 @@ package sqs
 
 import (
 	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -16,7 +19,11 @@ type Client interface {
 	CreateStackWithContext(aws.Context, *cloudformation.CreateStackInput, ...request.Option) (*cloudformation.CreateStackOutput, error)
 	UpdateStackWithContext(aws.Context, *cloudformation.UpdateStackInput, ...request.Option) (*cloudformation.UpdateStackOutput, error)
 	DeleteStackWithContext(aws.Context, *cloudformation.DeleteStackInput, ...request.Option) (*cloudformation.DeleteStackOutput, error)
+	GetTemplateWithContext(aws.Context, *cloudformation.GetTemplateInput, ...request.Option) (*cloudformation.GetTemplateOutput, error)
+	DescribeStackEventsWithContext(aws.Context, *cloudformation.DescribeStackEventsInput, ...request.Option) (*cloudformation.DescribeStackEventsOutput, error)
 	GetSecretValueWithContext(aws.Context, *secretsmanager.GetSecretValueInput, ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+	DeleteSecretWithContext(aws.Context, *secretsmanager.DeleteSecretInput, ...request.Option) (*secretsmanager.DeleteSecretOutput, error)
+	ListSecretsWithContext(aws.Context, *secretsmanager.ListSecretsInput, ...request.Option) (*secretsmanager.ListSecretsOutput, error)
 }
 
 type Config struct {
@@ -31,14 +38,225 @@ type Config struct {
 	// Endpoint.
 	AdditionalUserPolicy string `json:"additional_user_policy"`
 	PermissionsBoundary  string `json:"permissions_boundary"`
+	// AllowedKMSKeys is the list of customer managed KMS key ARNs that
+	// users may choose to encrypt their queues with.  Bindings to an
+	// encrypted queue are granted use of its key.
+	AllowedKMSKeys []string `json:"allowed_kms_keys"`
+	// AllowedSNSAccountIDs and AllowedSNSTopicARNs restrict which SNS
+	// topics users may subscribe their queues to.  A topic is allowed
+	// if it belongs to one of the accounts, or its ARN starts with one
+	// of the prefixes.
+	AllowedSNSAccountIDs []string `json:"allowed_sns_account_ids"`
+	AllowedSNSTopicARNs  []string `json:"allowed_sns_topic_arns"`
+	// RequireSecureTransport adds a statement to every queue policy
+	// denying access that does not use TLS.  Defaults to true.
+	RequireSecureTransport bool `json:"require_secure_transport"`
+	// RoleTrustedPrincipal is the ARN of the IAM principal that may
+	// assume the roles of role-based bindings.  It is required if any
+	// plan has a binding_mode of "role".
+	RoleTrustedPrincipal string `json:"role_trusted_principal"`
+	// SQSEndpoint is the SQS endpoint given in binding credentials,
+	// such as the URL of a VPC endpoint.  Defaults to the regional
+	// endpoint.
+	SQSEndpoint string `json:"sqs_endpoint"`
+	// AccessPolicies defines access policies for bindings in addition
+	// to the built-in ones, mapping each name to the SQS actions it
+	// allows, which must be in AllowedAccessPolicyActions.
+	AccessPolicies map[string][]string `json:"access_policies"`
+	// NetworkRestrictions are the source IP ranges, VPCs and VPC
+	// endpoints that bindings may be restricted to.  Bindings cannot
+	// be restricted to a kind of source the operator has not listed.
+	NetworkRestrictions
+	// SecretKMSKey is the ARN of a KMS key to encrypt binding secrets
+	// with, in place of the AWS managed key for Secrets Manager.
+	SecretKMSKey string `json:"binding_secret_kms_key"`
+	// SecretRecoveryWindowDays is how many days a deleted binding's
+	// secret can be recovered for, from 7 to 30.  Defaults to 30.
+	SecretRecoveryWindowDays int64 `json:"binding_secret_recovery_window_days"`
+	// SecretForceDelete deletes binding secrets without a recovery
+	// window, so their credentials and names are freed straight away.
+	SecretForceDelete bool `json:"binding_secret_force_delete"`
+	// StackCacheInProgressTTL is how long the state of a stack is
+	// cached for while an operation on it is in progress.  Defaults to
+	// 2 seconds.  "0s" turns off caching.
+	StackCacheInProgressTTL Duration `json:"stack_cache_in_progress_ttl"`
+	// AWSMaxAttempts is the most times an AWS call is tried when it is
+	// throttled or fails with a transient error, waiting a random time
+	// of up to AWSRetryBaseDelay before the first retry, doubling for
+	// each retry up to AWSRetryMaxDelay.  Default to 5, 200ms and 5s.
+	AWSMaxAttempts    int      `json:"aws_max_attempts"`
+	AWSRetryBaseDelay Duration `json:"aws_retry_base_delay"`
+	AWSRetryMaxDelay  Duration `json:"aws_retry_max_delay"`
+	// AWSRateLimit is the most AWS calls made a second, with bursts of
+	// up to AWSRateBurst calls.  Default to 10 and 20.  Calls are not
+	// limited if AWSRateLimit is 0.
+	AWSRateLimit float64 `json:"aws_rate_limit"`
+	AWSRateBurst int     `json:"aws_rate_burst"`
+	// CircuitBreakerThreshold is the number of AWS calls in a row that
+	// may fail because AWS is throttling or unavailable before the
+	// broker stops calling AWS for CircuitBreakerCooldown, failing
+	// requests with a 503 instead.  Default to 10 and 30 seconds.  The
+	// circuit breaker is turned off if CircuitBreakerThreshold is 0.
+	CircuitBreakerThreshold int      `json:"aws_circuit_breaker_threshold"`
+	CircuitBreakerCooldown  Duration `json:"aws_circuit_breaker_cooldown"`
+	// ReconcileInterval is how often the broker looks for orphaned and
+	// failed stacks and secrets.  Defaults to an hour, and "0s" turns
+	// it off.
+	ReconcileInterval Duration `json:"reconcile_interval"`
+	// ReconcileCleanup deletes what the reconciler finds, up to
+	// ReconcileMaxDeletions resources each run.  By default they are
+	// only reported.
+	ReconcileCleanup      bool `json:"reconcile_cleanup"`
+	ReconcileMaxDeletions int  `json:"reconcile_max_deletions"`
+	// ReconcileMinAge is how long a stack or secret must have been
+	// left alone before the reconciler considers it.  Defaults to an
+	// hour.
+	ReconcileMinAge Duration `json:"reconcile_min_age"`
+	// ContextTagKeys are the keys of the tags recording the Cloud
+	// Foundry organization, space and app that resources belong to.
+	// Each defaults to the name in DefaultContextTagKeys, and an empty
+	// key leaves its tag out.
+	ContextTagKeys ContextTagKeys `json:"context_tag_keys"`
+	// MetricsUsername and MetricsPassword are the basic auth
+	// credentials for /metrics.  They default to the broker's own.
+	MetricsUsername string `json:"metrics_username"`
+	MetricsPassword string `json:"metrics_password"`
+	// MetricsPort is a port to serve /metrics on instead of the
+	// broker's.  It is only protected by basic auth there if
+	// MetricsUsername and MetricsPassword are set.
+	MetricsPort string `json:"metrics_port"`
+	// AccessKeyMaxAge is the age at which binding access keys are
+	// rotated, such as "2160h".  Keys are not rotated if it is unset.
+	AccessKeyMaxAge Duration `json:"access_key_max_age"`
+	// AccessKeyGracePeriod is how long a rotated key stays active
+	// alongside its replacement.  Defaults to 24 hours.
+	AccessKeyGracePeriod Duration `json:"access_key_grace_period"`
+	// AccessKeyRotationInterval is how often bindings are checked for
+	// keys to rotate.  Defaults to an hour.
+	AccessKeyRotationInterval Duration `json:"access_key_rotation_interval"`
+}
+
+// Duration is a time.Duration read from a string such as "1h30m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1h30m\": %s", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
 }
 
 func NewConfig(configJSON []byte) (*Config, error) {
-	config := &Config{}
+	config := &Config{
+		RequireSecureTransport:    true,
+		AccessKeyGracePeriod:      Duration(24 * time.Hour),
+		AccessKeyRotationInterval: Duration(time.Hour),
+		StackCacheInProgressTTL:   Duration(2 * time.Second),
+		AWSMaxAttempts:            5,
+		AWSRetryBaseDelay:         Duration(200 * time.Millisecond),
+		AWSRetryMaxDelay:          Duration(5 * time.Second),
+		AWSRateLimit:              10,
+		AWSRateBurst:              20,
+		CircuitBreakerThreshold:   10,
+		CircuitBreakerCooldown:    Duration(30 * time.Second),
+		ReconcileInterval:         Duration(time.Hour),
+		ReconcileMaxDeletions:     10,
+		ReconcileMinAge:           Duration(time.Hour),
+		ContextTagKeys:            DefaultContextTagKeys,
+	}
 	err := json.Unmarshal(configJSON, &config)
 	if err != nil {
 		return nil, err
 	}
+	if err := config.validateAccessPolicies(); err != nil {
+		return nil, err
+	}
+	if err := config.NetworkRestrictions.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.validateSecretDeletion(); err != nil {
+		return nil, err
+	}
+	if err := config.validateAWSCalls(); err != nil {
+		return nil, err
+	}
+	if err := config.validateReconciler(); err != nil {
+		return nil, err
+	}
+	if err := config.validateMetrics(); err != nil {
+		return nil, err
+	}
+	if err := config.ContextTagKeys.Validate(); err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
+
+func (c *Config) validateSecretDeletion() error {
+	if c.SecretForceDelete && c.SecretRecoveryWindowDays != 0 {
+		return fmt.Errorf("binding_secret_force_delete and binding_secret_recovery_window_days cannot both be set")
+	}
+	if c.SecretRecoveryWindowDays != 0 && (c.SecretRecoveryWindowDays < 7 || c.SecretRecoveryWindowDays > 30) {
+		return fmt.Errorf("binding_secret_recovery_window_days must be from 7 to 30, got %d", c.SecretRecoveryWindowDays)
+	}
+	return nil
+}
+
+func (c *Config) validateAWSCalls() error {
+	if c.AWSMaxAttempts < 1 {
+		return fmt.Errorf("aws_max_attempts must be at least 1")
+	}
+	if c.AWSRateLimit < 0 {
+		return fmt.Errorf("aws_rate_limit must not be negative")
+	}
+	if c.AWSRateLimit > 0 && c.AWSRateBurst < 1 {
+		return fmt.Errorf("aws_rate_burst must be at least 1")
+	}
+	if c.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("aws_circuit_breaker_threshold must not be negative")
+	}
+	return nil
+}
+
+func (c *Config) validateReconciler() error {
+	if c.ReconcileMaxDeletions < 0 {
+		return fmt.Errorf("reconcile_max_deletions must not be negative")
+	}
+	return nil
+}
+
+func (c *Config) validateMetrics() error {
+	if (c.MetricsUsername == "") != (c.MetricsPassword == "") {
+		return fmt.Errorf("metrics_username and metrics_password must be set together")
+	}
+	return nil
+}
+
+var accessPolicyNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+func (c *Config) validateAccessPolicies() error {
+	for name, actions := range c.AccessPolicies {
+		if !accessPolicyNamePattern.MatchString(name) {
+			return fmt.Errorf("access policy name %#v must be lower case letters, digits, - and _", name)
+		}
+		if isBuiltinAccessPolicy(name) && !contains(RedefinableAccessPolicies, name) {
+			return fmt.Errorf("access policy %#v is built in and cannot be redefined", name)
+		}
+		if len(actions) == 0 {
+			return fmt.Errorf("access policy %#v allows no actions", name)
+		}
+		for _, action := range actions {
+			if !contains(AllowedAccessPolicyActions, action) {
+				return fmt.Errorf("access policy %#v has action %#v, which is not one of %s", name, action, strings.Join(AllowedAccessPolicyActions, ", "))
+			}
+		}
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package sqs_test
 
 import (
+	"time"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -16,3 +19,136 @@ var _ = Describe("Client", func() {
 		Expect(true).To(Equal(true))
 	})
 })
+
+var _ = Describe("Config", func() {
+	It("should set defaults", func() {
+		config, err := sqs.NewConfig([]byte(`{"aws_region": "eu-west-2"}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.RequireSecureTransport).To(BeTrue())
+		Expect(time.Duration(config.AccessKeyGracePeriod)).To(Equal(24 * time.Hour))
+	})
+
+	It("should read durations", func() {
+		config, err := sqs.NewConfig([]byte(`{"access_key_max_age": "2160h"}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Duration(config.AccessKeyMaxAge)).To(Equal(2160 * time.Hour))
+	})
+
+	It("should read access policies", func() {
+		config, err := sqs.NewConfig([]byte(`{"access_policies": {"redrive": ["sqs:StartMessageMoveTask", "sqs:ListMessageMoveTasks"]}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.AccessPolicies).To(HaveKeyWithValue("redrive", ConsistOf("sqs:StartMessageMoveTask", "sqs:ListMessageMoveTasks")))
+	})
+
+	It("should reject access policies with actions that are not allowed", func() {
+		_, err := sqs.NewConfig([]byte(`{"access_policies": {"admin": ["sqs:DeleteQueue"]}}`))
+		Expect(err).To(MatchError(ContainSubstring(`access policy "admin" has action "sqs:DeleteQueue", which is not one of`)))
+	})
+
+	It("should reject access policies that redefine built-in ones", func() {
+		_, err := sqs.NewConfig([]byte(`{"access_policies": {"full": ["sqs:SendMessage"]}}`))
+		Expect(err).To(MatchError(`access policy "full" is built in and cannot be redefined`))
+	})
+
+	It("should allow the monitor access policy to be redefined", func() {
+		config, err := sqs.NewConfig([]byte(`{"access_policies": {"monitor": ["sqs:GetQueueAttributes", "sqs:ListQueueTags"]}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.AccessPolicies).To(HaveKeyWithValue("monitor", ConsistOf("sqs:GetQueueAttributes", "sqs:ListQueueTags")))
+	})
+
+	It("should read the network restrictions bindings may use", func() {
+		config, err := sqs.NewConfig([]byte(`{"allowed_source_ips": ["10.0.0.0/16"], "allowed_source_vpc_endpoints": ["vpce-1234"]}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.NetworkRestrictions).To(Equal(sqs.NetworkRestrictions{
+			SourceIPs:          []string{"10.0.0.0/16"},
+			SourceVPCEndpoints: []string{"vpce-1234"},
+		}))
+	})
+
+	It("should reject allowed source IPs that are not CIDR ranges", func() {
+		_, err := sqs.NewConfig([]byte(`{"allowed_source_ips": ["everywhere"]}`))
+		Expect(err).To(MatchError(`allowed_source_ips "everywhere" is not a CIDR range`))
+	})
+
+	It("should read how binding secrets are encrypted and deleted", func() {
+		config, err := sqs.NewConfig([]byte(`{"binding_secret_kms_key": "arn:aws:kms:eu-west-2:123456789012:key/secrets", "binding_secret_recovery_window_days": 7}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.SecretKMSKey).To(Equal("arn:aws:kms:eu-west-2:123456789012:key/secrets"))
+		Expect(config.SecretRecoveryWindowDays).To(BeEquivalentTo(7))
+		Expect(config.SecretForceDelete).To(BeFalse())
+	})
+
+	It("should reject a recovery window Secrets Manager does not allow", func() {
+		_, err := sqs.NewConfig([]byte(`{"binding_secret_recovery_window_days": 3}`))
+		Expect(err).To(MatchError("binding_secret_recovery_window_days must be from 7 to 30, got 3"))
+	})
+
+	It("should reject a recovery window for secrets that are deleted without recovery", func() {
+		_, err := sqs.NewConfig([]byte(`{"binding_secret_recovery_window_days": 7, "binding_secret_force_delete": true}`))
+		Expect(err).To(MatchError("binding_secret_force_delete and binding_secret_recovery_window_days cannot both be set"))
+	})
+
+	It("should cache stack states for a short time by default", func() {
+		config, err := sqs.NewConfig([]byte(`{}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Duration(config.StackCacheInProgressTTL)).To(Equal(2 * time.Second))
+	})
+
+	It("should retry, rate limit and break the circuit on AWS calls by default", func() {
+		config, err := sqs.NewConfig([]byte(`{}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.AWSMaxAttempts).To(Equal(5))
+		Expect(config.AWSRateLimit).To(BeEquivalentTo(10))
+		Expect(config.AWSRateBurst).To(Equal(20))
+		Expect(config.CircuitBreakerThreshold).To(Equal(10))
+		Expect(time.Duration(config.CircuitBreakerCooldown)).To(Equal(30 * time.Second))
+	})
+
+	It("should report orphaned and failed resources hourly by default", func() {
+		config, err := sqs.NewConfig([]byte(`{}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Duration(config.ReconcileInterval)).To(Equal(time.Hour))
+		Expect(config.ReconcileCleanup).To(BeFalse())
+		Expect(config.ReconcileMaxDeletions).To(Equal(10))
+		Expect(time.Duration(config.ReconcileMinAge)).To(Equal(time.Hour))
+	})
+
+	It("should tag resources with the Cloud Foundry context by default", func() {
+		config, err := sqs.NewConfig([]byte(`{}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.ContextTagKeys).To(Equal(sqs.DefaultContextTagKeys))
+	})
+
+	It("should let context tag keys be renamed or left out", func() {
+		config, err := sqs.NewConfig([]byte(`{"context_tag_keys": {"space_name": "cf:space", "app_guid": ""}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.ContextTagKeys.SpaceName).To(Equal("cf:space"))
+		Expect(config.ContextTagKeys.AppGUID).To(BeEmpty())
+		Expect(config.ContextTagKeys.OrganizationName).To(Equal("OrganizationName"))
+	})
+
+	It("should reject context tag keys AWS does not allow", func() {
+		_, err := sqs.NewConfig([]byte(`{"context_tag_keys": {"space_name": "aws:space"}}`))
+		Expect(err).To(MatchError("context tag key \"aws:space\" must not start with aws:"))
+	})
+
+	It("should reject metrics credentials without a password", func() {
+		_, err := sqs.NewConfig([]byte(`{"metrics_username": "prometheus"}`))
+		Expect(err).To(MatchError("metrics_username and metrics_password must be set together"))
+	})
+
+	It("should reject a negative number of reconciler deletions", func() {
+		_, err := sqs.NewConfig([]byte(`{"reconcile_max_deletions": -1}`))
+		Expect(err).To(MatchError("reconcile_max_deletions must not be negative"))
+	})
+
+	It("should reject fewer than one attempt at AWS calls", func() {
+		_, err := sqs.NewConfig([]byte(`{"aws_max_attempts": 0}`))
+		Expect(err).To(MatchError("aws_max_attempts must be at least 1"))
+	})
+
+	It("should reject access policies with no actions", func() {
+		_, err := sqs.NewConfig([]byte(`{"access_policies": {"empty": []}}`))
+		Expect(err).To(MatchError(`access policy "empty" allows no actions`))
+	})
+})
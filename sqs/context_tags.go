@@ -0,0 +1,177 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+const (
+	// maxTagKeyLength and maxTagValueLength are the longest tag keys
+	// and values AWS allows
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+var (
+	tagKeyPattern          = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]+$`)
+	tagValueInvalidPattern = regexp.MustCompile(`[^\p{L}\p{Z}\p{N}_.:/=+\-@]`)
+)
+
+// ContextTagKeys are the keys of the tags recording the Cloud Foundry
+// organization, space and app that resources belong to.  A tag with an
+// empty key is not added.
+type ContextTagKeys struct {
+	OrganizationGUID string `json:"organization_guid"`
+	OrganizationName string `json:"organization_name"`
+	SpaceGUID        string `json:"space_guid"`
+	SpaceName        string `json:"space_name"`
+	AppGUID          string `json:"app_guid"`
+}
+
+// DefaultContextTagKeys are the context tag keys used unless the
+// operator configures others.
+var DefaultContextTagKeys = ContextTagKeys{
+	OrganizationGUID: "OrganizationGUID",
+	OrganizationName: "OrganizationName",
+	SpaceGUID:        "SpaceGUID",
+	SpaceName:        "SpaceName",
+	AppGUID:          "AppGUID",
+}
+
+// PlatformContext is the part of the OSBAPI context that Cloud Foundry
+// sends with provisions, updates and binds that resources are tagged
+// with.
+type PlatformContext struct {
+	OrganizationGUID string `json:"organization_guid"`
+	OrganizationName string `json:"organization_name"`
+	SpaceGUID        string `json:"space_guid"`
+	SpaceName        string `json:"space_name"`
+	// AppGUID is taken from the bind resource rather than the context.
+	AppGUID string `json:"-"`
+}
+
+// ParsePlatformContext reads an OSBAPI context.  An empty context is
+// not an error, as not every platform sends one.
+func ParsePlatformContext(rawContext json.RawMessage) (PlatformContext, error) {
+	var platformContext PlatformContext
+	if len(rawContext) == 0 {
+		return platformContext, nil
+	}
+	if err := json.Unmarshal(rawContext, &platformContext); err != nil {
+		return platformContext, apiresponses.NewFailureResponse(
+			fmt.Errorf("invalid context: %s", err),
+			http.StatusBadRequest,
+			"bad-json-format",
+		)
+	}
+	return platformContext, nil
+}
+
+func (k ContextTagKeys) keys() []string {
+	return []string{k.OrganizationGUID, k.OrganizationName, k.SpaceGUID, k.SpaceName, k.AppGUID}
+}
+
+// Validate checks that the keys are allowed by AWS, and are neither
+// repeated nor used by the broker's own tags.
+func (k ContextTagKeys) Validate() error {
+	seen := map[string]bool{}
+	for _, key := range k.keys() {
+		if key == "" {
+			continue
+		}
+		if len([]rune(key)) > maxTagKeyLength || !tagKeyPattern.MatchString(key) {
+			return fmt.Errorf("context tag key %#v must be at most %d letters, digits, spaces and _.:/=+-@", key, maxTagKeyLength)
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return fmt.Errorf("context tag key %#v must not start with aws:", key)
+		}
+		if seen[key] || isBrokerTagKey(key) {
+			return fmt.Errorf("context tag key %#v is used by another tag", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Tags returns the tags recording the context, leaving out those with
+// no key or no value.  Values are sanitised to what AWS allows.
+func (k ContextTagKeys) Tags(platformContext PlatformContext) map[string]string {
+	tags := map[string]string{}
+	add := func(key, value string) {
+		value = SanitiseTagValue(value)
+		if key != "" && value != "" {
+			tags[key] = value
+		}
+	}
+	add(k.OrganizationGUID, platformContext.OrganizationGUID)
+	add(k.OrganizationName, platformContext.OrganizationName)
+	add(k.SpaceGUID, platformContext.SpaceGUID)
+	add(k.SpaceName, platformContext.SpaceName)
+	add(k.AppGUID, platformContext.AppGUID)
+	return tags
+}
+
+// stackTags returns the context tags already on a stack, so that they
+// are kept when its template is rebuilt.
+func (k ContextTagKeys) stackTags(stack *cloudformation.Stack) map[string]string {
+	tags := map[string]string{}
+	for _, key := range k.keys() {
+		if value := getStackTag(stack, key); key != "" && value != "" {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// SanitiseTagValue replaces the characters AWS does not allow in tag
+// values with underscores, and shortens values that are too long.
+func SanitiseTagValue(value string) string {
+	value = strings.TrimSpace(tagValueInvalidPattern.ReplaceAllString(value, "_"))
+	if runes := []rune(value); len(runes) > maxTagValueLength {
+		value = strings.TrimSpace(string(runes[:maxTagValueLength]))
+	}
+	return value
+}
+
+// withContextTags adds the context tags to a stack's tags, replacing
+// any with the same keys.
+func withContextTags(tags []*cloudformation.Tag, contextTags map[string]string) []*cloudformation.Tag {
+	keys := []string{}
+	for key := range contextTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tags = withStackTag(tags, key, contextTags[key])
+	}
+	return tags
+}
+
+// withTemplateTags returns the resource tags with the context tags
+// added.
+func withTemplateTags(tags, contextTags map[string]string) map[string]string {
+	merged := map[string]string{}
+	for key, value := range tags {
+		merged[key] = value
+	}
+	for key, value := range contextTags {
+		merged[key] = value
+	}
+	return merged
+}
+
+func isBrokerTagKey(key string) bool {
+	switch key {
+	case TagCostAllocation, TagEnvironment, TagName, TagService, TagServiceId,
+		TagTemplateVersion, TagPlanId, TagInstanceId, "QueueType":
+		return true
+	}
+	return false
+}
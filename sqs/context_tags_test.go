@@ -0,0 +1,98 @@
+package sqs_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("ContextTagKeys", func() {
+	It("should tag every part of the context that has a key and a value", func() {
+		keys := sqs.DefaultContextTagKeys
+		keys.SpaceGUID = ""
+		Expect(keys.Tags(sqs.PlatformContext{
+			OrganizationGUID: "org-guid",
+			SpaceGUID:        "space-guid",
+			SpaceName:        "dev",
+			AppGUID:          "app-guid",
+		})).To(Equal(map[string]string{
+			"OrganizationGUID": "org-guid",
+			"SpaceName":        "dev",
+			"AppGUID":          "app-guid",
+		}))
+	})
+
+	It("should accept the default keys", func() {
+		Expect(sqs.DefaultContextTagKeys.Validate()).To(Succeed())
+	})
+
+	It("should reject keys AWS does not allow", func() {
+		Expect(sqs.ContextTagKeys{SpaceName: "space#name"}.Validate()).To(MatchError(
+			`context tag key "space#name" must be at most 128 letters, digits, spaces and _.:/=+-@`,
+		))
+		Expect(sqs.ContextTagKeys{SpaceName: "aws:space"}.Validate()).To(MatchError(
+			`context tag key "aws:space" must not start with aws:`,
+		))
+	})
+
+	It("should reject keys used by other tags", func() {
+		Expect(sqs.ContextTagKeys{SpaceName: "Name"}.Validate()).To(MatchError(
+			`context tag key "Name" is used by another tag`,
+		))
+		Expect(sqs.ContextTagKeys{SpaceName: "cf", OrganizationName: "cf"}.Validate()).To(MatchError(
+			`context tag key "cf" is used by another tag`,
+		))
+	})
+})
+
+var _ = Describe("SanitiseTagValue", func() {
+	It("should keep the characters AWS allows", func() {
+		Expect(sqs.SanitiseTagValue("Finance: payments/eu-west_2 +=.@ ünïcode")).To(Equal("Finance: payments/eu-west_2 +=.@ ünïcode"))
+	})
+
+	It("should replace the characters AWS does not allow", func() {
+		Expect(sqs.SanitiseTagValue(`dev #1 (eu) "it's"`)).To(Equal("dev _1 _eu_ _it_s_"))
+	})
+
+	It("should shorten long values", func() {
+		Expect(sqs.SanitiseTagValue(strings.Repeat("a", 300))).To(HaveLen(256))
+	})
+})
+
+var _ = Describe("ParsePlatformContext", func() {
+	It("should read the organization and space", func() {
+		platformContext, err := sqs.ParsePlatformContext(json.RawMessage(`{
+			"platform": "cloudfoundry",
+			"organization_guid": "org-guid",
+			"organization_name": "finance",
+			"space_guid": "space-guid",
+			"space_name": "dev",
+			"instance_name": "my-queue"
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(platformContext).To(Equal(sqs.PlatformContext{
+			OrganizationGUID: "org-guid",
+			OrganizationName: "finance",
+			SpaceGUID:        "space-guid",
+			SpaceName:        "dev",
+		}))
+	})
+
+	It("should accept no context", func() {
+		platformContext, err := sqs.ParsePlatformContext(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(platformContext).To(Equal(sqs.PlatformContext{}))
+	})
+
+	It("should reject a context that is not an object with a 400", func() {
+		_, err := sqs.ParsePlatformContext(json.RawMessage(`"cloudfoundry"`))
+		Expect(err).To(HaveOccurred())
+		castErrResponse, ok := err.(*brokerapi.FailureResponse)
+		Expect(ok).To(BeTrue())
+		Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+	})
+})
@@ -27,6 +27,21 @@ type FakeClient struct {
 		result1 *cloudformation.CreateStackOutput
 		result2 error
 	}
+	DeleteSecretWithContextStub        func(context.Context, *secretsmanager.DeleteSecretInput, ...request.Option) (*secretsmanager.DeleteSecretOutput, error)
+	deleteSecretWithContextMutex       sync.RWMutex
+	deleteSecretWithContextArgsForCall []struct {
+		arg1 context.Context
+		arg2 *secretsmanager.DeleteSecretInput
+		arg3 []request.Option
+	}
+	deleteSecretWithContextReturns struct {
+		result1 *secretsmanager.DeleteSecretOutput
+		result2 error
+	}
+	deleteSecretWithContextReturnsOnCall map[int]struct {
+		result1 *secretsmanager.DeleteSecretOutput
+		result2 error
+	}
 	DeleteStackWithContextStub        func(context.Context, *cloudformation.DeleteStackInput, ...request.Option) (*cloudformation.DeleteStackOutput, error)
 	deleteStackWithContextMutex       sync.RWMutex
 	deleteStackWithContextArgsForCall []struct {
@@ -42,6 +57,21 @@ type FakeClient struct {
 		result1 *cloudformation.DeleteStackOutput
 		result2 error
 	}
+	DescribeStackEventsWithContextStub        func(context.Context, *cloudformation.DescribeStackEventsInput, ...request.Option) (*cloudformation.DescribeStackEventsOutput, error)
+	describeStackEventsWithContextMutex       sync.RWMutex
+	describeStackEventsWithContextArgsForCall []struct {
+		arg1 context.Context
+		arg2 *cloudformation.DescribeStackEventsInput
+		arg3 []request.Option
+	}
+	describeStackEventsWithContextReturns struct {
+		result1 *cloudformation.DescribeStackEventsOutput
+		result2 error
+	}
+	describeStackEventsWithContextReturnsOnCall map[int]struct {
+		result1 *cloudformation.DescribeStackEventsOutput
+		result2 error
+	}
 	DescribeStacksWithContextStub        func(context.Context, *cloudformation.DescribeStacksInput, ...request.Option) (*cloudformation.DescribeStacksOutput, error)
 	describeStacksWithContextMutex       sync.RWMutex
 	describeStacksWithContextArgsForCall []struct {
@@ -72,6 +102,36 @@ type FakeClient struct {
 		result1 *secretsmanager.GetSecretValueOutput
 		result2 error
 	}
+	GetTemplateWithContextStub        func(context.Context, *cloudformation.GetTemplateInput, ...request.Option) (*cloudformation.GetTemplateOutput, error)
+	getTemplateWithContextMutex       sync.RWMutex
+	getTemplateWithContextArgsForCall []struct {
+		arg1 context.Context
+		arg2 *cloudformation.GetTemplateInput
+		arg3 []request.Option
+	}
+	getTemplateWithContextReturns struct {
+		result1 *cloudformation.GetTemplateOutput
+		result2 error
+	}
+	getTemplateWithContextReturnsOnCall map[int]struct {
+		result1 *cloudformation.GetTemplateOutput
+		result2 error
+	}
+	ListSecretsWithContextStub        func(context.Context, *secretsmanager.ListSecretsInput, ...request.Option) (*secretsmanager.ListSecretsOutput, error)
+	listSecretsWithContextMutex       sync.RWMutex
+	listSecretsWithContextArgsForCall []struct {
+		arg1 context.Context
+		arg2 *secretsmanager.ListSecretsInput
+		arg3 []request.Option
+	}
+	listSecretsWithContextReturns struct {
+		result1 *secretsmanager.ListSecretsOutput
+		result2 error
+	}
+	listSecretsWithContextReturnsOnCall map[int]struct {
+		result1 *secretsmanager.ListSecretsOutput
+		result2 error
+	}
 	UpdateStackWithContextStub        func(context.Context, *cloudformation.UpdateStackInput, ...request.Option) (*cloudformation.UpdateStackOutput, error)
 	updateStackWithContextMutex       sync.RWMutex
 	updateStackWithContextArgsForCall []struct {
@@ -156,6 +216,71 @@ func (fake *FakeClient) CreateStackWithContextReturnsOnCall(i int, result1 *clou
 	}{result1, result2}
 }
 
+func (fake *FakeClient) DeleteSecretWithContext(arg1 context.Context, arg2 *secretsmanager.DeleteSecretInput, arg3 ...request.Option) (*secretsmanager.DeleteSecretOutput, error) {
+	fake.deleteSecretWithContextMutex.Lock()
+	ret, specificReturn := fake.deleteSecretWithContextReturnsOnCall[len(fake.deleteSecretWithContextArgsForCall)]
+	fake.deleteSecretWithContextArgsForCall = append(fake.deleteSecretWithContextArgsForCall, struct {
+		arg1 context.Context
+		arg2 *secretsmanager.DeleteSecretInput
+		arg3 []request.Option
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("DeleteSecretWithContext", []interface{}{arg1, arg2, arg3})
+	fake.deleteSecretWithContextMutex.Unlock()
+	if fake.DeleteSecretWithContextStub != nil {
+		return fake.DeleteSecretWithContextStub(arg1, arg2, arg3...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.deleteSecretWithContextReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) DeleteSecretWithContextCallCount() int {
+	fake.deleteSecretWithContextMutex.RLock()
+	defer fake.deleteSecretWithContextMutex.RUnlock()
+	return len(fake.deleteSecretWithContextArgsForCall)
+}
+
+func (fake *FakeClient) DeleteSecretWithContextCalls(stub func(context.Context, *secretsmanager.DeleteSecretInput, ...request.Option) (*secretsmanager.DeleteSecretOutput, error)) {
+	fake.deleteSecretWithContextMutex.Lock()
+	defer fake.deleteSecretWithContextMutex.Unlock()
+	fake.DeleteSecretWithContextStub = stub
+}
+
+func (fake *FakeClient) DeleteSecretWithContextArgsForCall(i int) (context.Context, *secretsmanager.DeleteSecretInput, []request.Option) {
+	fake.deleteSecretWithContextMutex.RLock()
+	defer fake.deleteSecretWithContextMutex.RUnlock()
+	argsForCall := fake.deleteSecretWithContextArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) DeleteSecretWithContextReturns(result1 *secretsmanager.DeleteSecretOutput, result2 error) {
+	fake.deleteSecretWithContextMutex.Lock()
+	defer fake.deleteSecretWithContextMutex.Unlock()
+	fake.DeleteSecretWithContextStub = nil
+	fake.deleteSecretWithContextReturns = struct {
+		result1 *secretsmanager.DeleteSecretOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) DeleteSecretWithContextReturnsOnCall(i int, result1 *secretsmanager.DeleteSecretOutput, result2 error) {
+	fake.deleteSecretWithContextMutex.Lock()
+	defer fake.deleteSecretWithContextMutex.Unlock()
+	fake.DeleteSecretWithContextStub = nil
+	if fake.deleteSecretWithContextReturnsOnCall == nil {
+		fake.deleteSecretWithContextReturnsOnCall = make(map[int]struct {
+			result1 *secretsmanager.DeleteSecretOutput
+			result2 error
+		})
+	}
+	fake.deleteSecretWithContextReturnsOnCall[i] = struct {
+		result1 *secretsmanager.DeleteSecretOutput
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) DeleteStackWithContext(arg1 context.Context, arg2 *cloudformation.DeleteStackInput, arg3 ...request.Option) (*cloudformation.DeleteStackOutput, error) {
 	fake.deleteStackWithContextMutex.Lock()
 	ret, specificReturn := fake.deleteStackWithContextReturnsOnCall[len(fake.deleteStackWithContextArgsForCall)]
@@ -221,6 +346,71 @@ func (fake *FakeClient) DeleteStackWithContextReturnsOnCall(i int, result1 *clou
 	}{result1, result2}
 }
 
+func (fake *FakeClient) DescribeStackEventsWithContext(arg1 context.Context, arg2 *cloudformation.DescribeStackEventsInput, arg3 ...request.Option) (*cloudformation.DescribeStackEventsOutput, error) {
+	fake.describeStackEventsWithContextMutex.Lock()
+	ret, specificReturn := fake.describeStackEventsWithContextReturnsOnCall[len(fake.describeStackEventsWithContextArgsForCall)]
+	fake.describeStackEventsWithContextArgsForCall = append(fake.describeStackEventsWithContextArgsForCall, struct {
+		arg1 context.Context
+		arg2 *cloudformation.DescribeStackEventsInput
+		arg3 []request.Option
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("DescribeStackEventsWithContext", []interface{}{arg1, arg2, arg3})
+	fake.describeStackEventsWithContextMutex.Unlock()
+	if fake.DescribeStackEventsWithContextStub != nil {
+		return fake.DescribeStackEventsWithContextStub(arg1, arg2, arg3...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.describeStackEventsWithContextReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextCallCount() int {
+	fake.describeStackEventsWithContextMutex.RLock()
+	defer fake.describeStackEventsWithContextMutex.RUnlock()
+	return len(fake.describeStackEventsWithContextArgsForCall)
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextCalls(stub func(context.Context, *cloudformation.DescribeStackEventsInput, ...request.Option) (*cloudformation.DescribeStackEventsOutput, error)) {
+	fake.describeStackEventsWithContextMutex.Lock()
+	defer fake.describeStackEventsWithContextMutex.Unlock()
+	fake.DescribeStackEventsWithContextStub = stub
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextArgsForCall(i int) (context.Context, *cloudformation.DescribeStackEventsInput, []request.Option) {
+	fake.describeStackEventsWithContextMutex.RLock()
+	defer fake.describeStackEventsWithContextMutex.RUnlock()
+	argsForCall := fake.describeStackEventsWithContextArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextReturns(result1 *cloudformation.DescribeStackEventsOutput, result2 error) {
+	fake.describeStackEventsWithContextMutex.Lock()
+	defer fake.describeStackEventsWithContextMutex.Unlock()
+	fake.DescribeStackEventsWithContextStub = nil
+	fake.describeStackEventsWithContextReturns = struct {
+		result1 *cloudformation.DescribeStackEventsOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextReturnsOnCall(i int, result1 *cloudformation.DescribeStackEventsOutput, result2 error) {
+	fake.describeStackEventsWithContextMutex.Lock()
+	defer fake.describeStackEventsWithContextMutex.Unlock()
+	fake.DescribeStackEventsWithContextStub = nil
+	if fake.describeStackEventsWithContextReturnsOnCall == nil {
+		fake.describeStackEventsWithContextReturnsOnCall = make(map[int]struct {
+			result1 *cloudformation.DescribeStackEventsOutput
+			result2 error
+		})
+	}
+	fake.describeStackEventsWithContextReturnsOnCall[i] = struct {
+		result1 *cloudformation.DescribeStackEventsOutput
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) DescribeStacksWithContext(arg1 context.Context, arg2 *cloudformation.DescribeStacksInput, arg3 ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
 	fake.describeStacksWithContextMutex.Lock()
 	ret, specificReturn := fake.describeStacksWithContextReturnsOnCall[len(fake.describeStacksWithContextArgsForCall)]
@@ -351,6 +541,136 @@ func (fake *FakeClient) GetSecretValueWithContextReturnsOnCall(i int, result1 *s
 	}{result1, result2}
 }
 
+func (fake *FakeClient) GetTemplateWithContext(arg1 context.Context, arg2 *cloudformation.GetTemplateInput, arg3 ...request.Option) (*cloudformation.GetTemplateOutput, error) {
+	fake.getTemplateWithContextMutex.Lock()
+	ret, specificReturn := fake.getTemplateWithContextReturnsOnCall[len(fake.getTemplateWithContextArgsForCall)]
+	fake.getTemplateWithContextArgsForCall = append(fake.getTemplateWithContextArgsForCall, struct {
+		arg1 context.Context
+		arg2 *cloudformation.GetTemplateInput
+		arg3 []request.Option
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("GetTemplateWithContext", []interface{}{arg1, arg2, arg3})
+	fake.getTemplateWithContextMutex.Unlock()
+	if fake.GetTemplateWithContextStub != nil {
+		return fake.GetTemplateWithContextStub(arg1, arg2, arg3...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.getTemplateWithContextReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) GetTemplateWithContextCallCount() int {
+	fake.getTemplateWithContextMutex.RLock()
+	defer fake.getTemplateWithContextMutex.RUnlock()
+	return len(fake.getTemplateWithContextArgsForCall)
+}
+
+func (fake *FakeClient) GetTemplateWithContextCalls(stub func(context.Context, *cloudformation.GetTemplateInput, ...request.Option) (*cloudformation.GetTemplateOutput, error)) {
+	fake.getTemplateWithContextMutex.Lock()
+	defer fake.getTemplateWithContextMutex.Unlock()
+	fake.GetTemplateWithContextStub = stub
+}
+
+func (fake *FakeClient) GetTemplateWithContextArgsForCall(i int) (context.Context, *cloudformation.GetTemplateInput, []request.Option) {
+	fake.getTemplateWithContextMutex.RLock()
+	defer fake.getTemplateWithContextMutex.RUnlock()
+	argsForCall := fake.getTemplateWithContextArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) GetTemplateWithContextReturns(result1 *cloudformation.GetTemplateOutput, result2 error) {
+	fake.getTemplateWithContextMutex.Lock()
+	defer fake.getTemplateWithContextMutex.Unlock()
+	fake.GetTemplateWithContextStub = nil
+	fake.getTemplateWithContextReturns = struct {
+		result1 *cloudformation.GetTemplateOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) GetTemplateWithContextReturnsOnCall(i int, result1 *cloudformation.GetTemplateOutput, result2 error) {
+	fake.getTemplateWithContextMutex.Lock()
+	defer fake.getTemplateWithContextMutex.Unlock()
+	fake.GetTemplateWithContextStub = nil
+	if fake.getTemplateWithContextReturnsOnCall == nil {
+		fake.getTemplateWithContextReturnsOnCall = make(map[int]struct {
+			result1 *cloudformation.GetTemplateOutput
+			result2 error
+		})
+	}
+	fake.getTemplateWithContextReturnsOnCall[i] = struct {
+		result1 *cloudformation.GetTemplateOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) ListSecretsWithContext(arg1 context.Context, arg2 *secretsmanager.ListSecretsInput, arg3 ...request.Option) (*secretsmanager.ListSecretsOutput, error) {
+	fake.listSecretsWithContextMutex.Lock()
+	ret, specificReturn := fake.listSecretsWithContextReturnsOnCall[len(fake.listSecretsWithContextArgsForCall)]
+	fake.listSecretsWithContextArgsForCall = append(fake.listSecretsWithContextArgsForCall, struct {
+		arg1 context.Context
+		arg2 *secretsmanager.ListSecretsInput
+		arg3 []request.Option
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("ListSecretsWithContext", []interface{}{arg1, arg2, arg3})
+	fake.listSecretsWithContextMutex.Unlock()
+	if fake.ListSecretsWithContextStub != nil {
+		return fake.ListSecretsWithContextStub(arg1, arg2, arg3...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.listSecretsWithContextReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) ListSecretsWithContextCallCount() int {
+	fake.listSecretsWithContextMutex.RLock()
+	defer fake.listSecretsWithContextMutex.RUnlock()
+	return len(fake.listSecretsWithContextArgsForCall)
+}
+
+func (fake *FakeClient) ListSecretsWithContextCalls(stub func(context.Context, *secretsmanager.ListSecretsInput, ...request.Option) (*secretsmanager.ListSecretsOutput, error)) {
+	fake.listSecretsWithContextMutex.Lock()
+	defer fake.listSecretsWithContextMutex.Unlock()
+	fake.ListSecretsWithContextStub = stub
+}
+
+func (fake *FakeClient) ListSecretsWithContextArgsForCall(i int) (context.Context, *secretsmanager.ListSecretsInput, []request.Option) {
+	fake.listSecretsWithContextMutex.RLock()
+	defer fake.listSecretsWithContextMutex.RUnlock()
+	argsForCall := fake.listSecretsWithContextArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) ListSecretsWithContextReturns(result1 *secretsmanager.ListSecretsOutput, result2 error) {
+	fake.listSecretsWithContextMutex.Lock()
+	defer fake.listSecretsWithContextMutex.Unlock()
+	fake.ListSecretsWithContextStub = nil
+	fake.listSecretsWithContextReturns = struct {
+		result1 *secretsmanager.ListSecretsOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) ListSecretsWithContextReturnsOnCall(i int, result1 *secretsmanager.ListSecretsOutput, result2 error) {
+	fake.listSecretsWithContextMutex.Lock()
+	defer fake.listSecretsWithContextMutex.Unlock()
+	fake.ListSecretsWithContextStub = nil
+	if fake.listSecretsWithContextReturnsOnCall == nil {
+		fake.listSecretsWithContextReturnsOnCall = make(map[int]struct {
+			result1 *secretsmanager.ListSecretsOutput
+			result2 error
+		})
+	}
+	fake.listSecretsWithContextReturnsOnCall[i] = struct {
+		result1 *secretsmanager.ListSecretsOutput
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) UpdateStackWithContext(arg1 context.Context, arg2 *cloudformation.UpdateStackInput, arg3 ...request.Option) (*cloudformation.UpdateStackOutput, error) {
 	fake.updateStackWithContextMutex.Lock()
 	ret, specificReturn := fake.updateStackWithContextReturnsOnCall[len(fake.updateStackWithContextArgsForCall)]
@@ -421,12 +741,20 @@ func (fake *FakeClient) Invocations() map[string][][]interface{} {
 	defer fake.invocationsMutex.RUnlock()
 	fake.createStackWithContextMutex.RLock()
 	defer fake.createStackWithContextMutex.RUnlock()
+	fake.deleteSecretWithContextMutex.RLock()
+	defer fake.deleteSecretWithContextMutex.RUnlock()
 	fake.deleteStackWithContextMutex.RLock()
 	defer fake.deleteStackWithContextMutex.RUnlock()
+	fake.describeStackEventsWithContextMutex.RLock()
+	defer fake.describeStackEventsWithContextMutex.RUnlock()
 	fake.describeStacksWithContextMutex.RLock()
 	defer fake.describeStacksWithContextMutex.RUnlock()
 	fake.getSecretValueWithContextMutex.RLock()
 	defer fake.getSecretValueWithContextMutex.RUnlock()
+	fake.getTemplateWithContextMutex.RLock()
+	defer fake.getTemplateWithContextMutex.RUnlock()
+	fake.listSecretsWithContextMutex.RLock()
+	defer fake.listSecretsWithContextMutex.RUnlock()
 	fake.updateStackWithContextMutex.RLock()
 	defer fake.updateStackWithContextMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
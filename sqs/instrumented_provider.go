@@ -0,0 +1,181 @@
+package sqs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	provideriface "github.com/alphagov/paas-service-broker-base/provider"
+	"github.com/alphagov/paas-sqs-broker/metrics"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/pivotal-cf/brokerapi/domain"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+const (
+	OperationProvision            = "provision"
+	OperationDeprovision          = "deprovision"
+	OperationUpdate               = "update"
+	OperationBind                 = "bind"
+	OperationUnbind               = "unbind"
+	OperationLastOperation        = "last_operation"
+	OperationLastBindingOperation = "last_binding_operation"
+	OperationGetBinding           = "get_binding"
+	OperationGetInstance          = "get_instance"
+)
+
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeRejected  = "rejected"
+	OutcomeFailed    = "failed"
+	OutcomeTimeout   = "timeout"
+)
+
+// stackBuckets are the upper bounds, in seconds, of histogram buckets
+// suited to how long stack operations take.
+var stackBuckets = []float64{5, 10, 20, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+var (
+	// Operations counts the requests made of the broker, by operation
+	// and outcome, and OperationDuration times them.
+	Operations = metrics.NewCounter(
+		"sqs_broker_operations_total",
+		"Broker requests by operation and outcome (succeeded, rejected as the client's fault, or failed).",
+		"operation", "outcome",
+	)
+	OperationDuration = metrics.NewHistogram(
+		"sqs_broker_operation_duration_seconds",
+		"Time taken to answer broker requests, by operation and outcome.",
+		metrics.DefaultBuckets,
+		"operation", "outcome",
+	)
+	// StackSettleDuration times stack operations, from when the stack
+	// was last created or updated until the broker saw it finish.
+	StackSettleDuration = metrics.NewHistogram(
+		"sqs_broker_stack_settle_duration_seconds",
+		"Time taken by stack operations to succeed or fail, by kind of stack.",
+		stackBuckets,
+		"kind", "state",
+	)
+	// SyncBindWait times how long synchronous binds waited for their
+	// stacks.
+	SyncBindWait = metrics.NewHistogram(
+		"sqs_broker_sync_bind_wait_seconds",
+		"Time synchronous binds waited for their stack, by outcome.",
+		stackBuckets,
+		"outcome",
+	)
+)
+
+// InstrumentedProvider is a Provider that counts and times every
+// request made of it.
+type InstrumentedProvider struct {
+	*Provider
+}
+
+func (p *InstrumentedProvider) Provision(ctx context.Context, provisionData provideriface.ProvisionData) (spec *domain.ProvisionedServiceSpec, err error) {
+	defer observeOperation(OperationProvision, time.Now(), &err)
+	return p.Provider.Provision(ctx, provisionData)
+}
+
+func (p *InstrumentedProvider) Deprovision(ctx context.Context, deprovisionData provideriface.DeprovisionData) (spec *domain.DeprovisionServiceSpec, err error) {
+	defer observeOperation(OperationDeprovision, time.Now(), &err)
+	return p.Provider.Deprovision(ctx, deprovisionData)
+}
+
+func (p *InstrumentedProvider) Update(ctx context.Context, updateData provideriface.UpdateData) (spec *domain.UpdateServiceSpec, err error) {
+	defer observeOperation(OperationUpdate, time.Now(), &err)
+	return p.Provider.Update(ctx, updateData)
+}
+
+func (p *InstrumentedProvider) Bind(ctx context.Context, bindData provideriface.BindData) (binding *domain.Binding, err error) {
+	defer observeOperation(OperationBind, time.Now(), &err)
+	return p.Provider.Bind(ctx, bindData)
+}
+
+func (p *InstrumentedProvider) Unbind(ctx context.Context, unbindData provideriface.UnbindData) (spec *domain.UnbindSpec, err error) {
+	defer observeOperation(OperationUnbind, time.Now(), &err)
+	return p.Provider.Unbind(ctx, unbindData)
+}
+
+func (p *InstrumentedProvider) LastOperation(ctx context.Context, lastOperationData provideriface.LastOperationData) (op *domain.LastOperation, err error) {
+	defer observeOperation(OperationLastOperation, time.Now(), &err)
+	return p.Provider.LastOperation(ctx, lastOperationData)
+}
+
+func (p *InstrumentedProvider) LastBindingOperation(ctx context.Context, lastBindingOperationData provideriface.LastBindingOperationData) (op *domain.LastOperation, err error) {
+	defer observeOperation(OperationLastBindingOperation, time.Now(), &err)
+	return p.Provider.LastBindingOperation(ctx, lastBindingOperationData)
+}
+
+func (p *InstrumentedProvider) GetBinding(ctx context.Context, getBindingData provideriface.GetBindData) (spec *domain.GetBindingSpec, err error) {
+	defer observeOperation(OperationGetBinding, time.Now(), &err)
+	return p.Provider.GetBinding(ctx, getBindingData)
+}
+
+func (p *InstrumentedProvider) GetInstance(ctx context.Context, instanceID string) (spec *domain.GetInstanceDetailsSpec, err error) {
+	defer observeOperation(OperationGetInstance, time.Now(), &err)
+	return p.Provider.GetInstance(ctx, instanceID)
+}
+
+func observeOperation(operation string, start time.Time, err *error) {
+	outcome := operationOutcome(*err)
+	Operations.Inc(operation, outcome)
+	OperationDuration.Observe(time.Since(start).Seconds(), operation, outcome)
+}
+
+// operationOutcome tells requests refused because of what the client
+// asked for apart from those that failed.
+func operationOutcome(err error) string {
+	if err == nil {
+		return OutcomeSucceeded
+	}
+	if failure, ok := err.(*apiresponses.FailureResponse); ok && failure.ValidatedStatusCode(nil) < 500 {
+		return OutcomeRejected
+	}
+	return OutcomeFailed
+}
+
+// settledStackRetention is how long after a stack last changed its
+// operation is remembered as observed.  Stack operations finish well
+// within it, so an operation on a stack that last changed longer ago
+// than this was either observed already or finished while the broker
+// was not running, and is neither timed nor remembered.
+const settledStackRetention = 24 * time.Hour
+
+// settledStacks records when each stack last changed as of the last
+// time its operation was seen to finish, so that the operation is only
+// observed once however often it is polled.  Entries are dropped once
+// they are older than settledStackRetention.
+var settledStacks = struct {
+	sync.Mutex
+	changed map[string]time.Time
+}{changed: map[string]time.Time{}}
+
+// observeStackSettled records how long the operation on a stack that
+// has just been seen to succeed or fail took, unless it has already
+// been recorded.
+func observeStackSettled(stack *cloudformation.Stack, kind string, state domain.LastOperationState) {
+	changed := lastChanged(stack)
+	if changed == nil || time.Since(*changed) > settledStackRetention {
+		return
+	}
+	key := aws.StringValue(stack.StackId)
+	if key == "" {
+		key = aws.StringValue(stack.StackName)
+	}
+	settledStacks.Lock()
+	if previous, ok := settledStacks.changed[key]; ok && previous.Equal(*changed) {
+		settledStacks.Unlock()
+		return
+	}
+	for k, c := range settledStacks.changed {
+		if time.Since(c) > settledStackRetention {
+			delete(settledStacks.changed, k)
+		}
+	}
+	settledStacks.changed[key] = *changed
+	settledStacks.Unlock()
+	StackSettleDuration.Observe(time.Since(*changed).Seconds(), kind, string(state))
+}
@@ -0,0 +1,184 @@
+package sqs_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/domain"
+
+	provideriface "github.com/alphagov/paas-service-broker-base/provider"
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	fakeClient "github.com/alphagov/paas-sqs-broker/sqs/fakes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+var _ = Describe("InstrumentedProvider", func() {
+	var (
+		fakeCfnClient     *fakeClient.FakeClient
+		provider          *sqs.InstrumentedProvider
+		lastOperationData provideriface.LastOperationData
+	)
+
+	BeforeEach(func() {
+		fakeCfnClient = &fakeClient.FakeClient{}
+		provider = &sqs.InstrumentedProvider{
+			Provider: &sqs.Provider{
+				Client:         fakeCfnClient,
+				Environment:    "test",
+				ResourcePrefix: "testprefix",
+				Logger:         lager.NewLogger("sqs-provider"),
+			},
+		}
+		lastOperationData = provideriface.LastOperationData{
+			InstanceID: "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+		}
+	})
+
+	It("counts and times requests that succeed", func() {
+		before := sqs.Operations.Value(sqs.OperationLastOperation, sqs.OutcomeSucceeded)
+		beforeDuration := sqs.OperationDuration.Count(sqs.OperationLastOperation, sqs.OutcomeSucceeded)
+		fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{{
+				StackName:   aws.String("testprefix-09E1993E-62E2-4040-ADF2-4D3EC741EFE6"),
+				StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			}},
+		}, nil)
+
+		_, err := provider.LastOperation(context.Background(), lastOperationData)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sqs.Operations.Value(sqs.OperationLastOperation, sqs.OutcomeSucceeded)).To(Equal(before + 1))
+		Expect(sqs.OperationDuration.Count(sqs.OperationLastOperation, sqs.OutcomeSucceeded)).To(Equal(beforeDuration + 1))
+	})
+
+	It("counts requests that fail", func() {
+		before := sqs.Operations.Value(sqs.OperationLastOperation, sqs.OutcomeFailed)
+		fakeCfnClient.DescribeStacksWithContextReturns(nil, errors.New("boom"))
+
+		_, err := provider.LastOperation(context.Background(), lastOperationData)
+		Expect(err).To(HaveOccurred())
+		Expect(sqs.Operations.Value(sqs.OperationLastOperation, sqs.OutcomeFailed)).To(Equal(before + 1))
+	})
+
+	It("counts requests rejected because of what was asked for", func() {
+		before := sqs.Operations.Value(sqs.OperationBind, sqs.OutcomeRejected)
+
+		_, err := provider.Bind(context.Background(), provideriface.BindData{
+			InstanceID: "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+			BindingID:  "c6ea1339-7ade-4952-9247-e419b59e7b67",
+			Details: domain.BindDetails{
+				RawParameters: json.RawMessage(`{"unknown": true}`),
+			},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(sqs.Operations.Value(sqs.OperationBind, sqs.OutcomeRejected)).To(Equal(before + 1))
+	})
+
+	It("times how long stack operations took to finish", func() {
+		before := sqs.StackSettleDuration.Count(sqs.StackKindQueue, string(domain.Succeeded))
+		beforeSum := sqs.StackSettleDuration.Sum(sqs.StackKindQueue, string(domain.Succeeded))
+		fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{{
+				StackName:       aws.String("testprefix-09E1993E-62E2-4040-ADF2-4D3EC741EFE6"),
+				StackStatus:     aws.String(cloudformation.StackStatusUpdateComplete),
+				CreationTime:    aws.Time(time.Now().Add(-time.Hour)),
+				LastUpdatedTime: aws.Time(time.Now().Add(-90 * time.Second)),
+			}},
+		}, nil)
+
+		_, err := provider.LastOperation(context.Background(), lastOperationData)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sqs.StackSettleDuration.Count(sqs.StackKindQueue, string(domain.Succeeded))).To(Equal(before + 1))
+		Expect(sqs.StackSettleDuration.Sum(sqs.StackKindQueue, string(domain.Succeeded)) - beforeSum).To(BeNumerically("~", 90, 5))
+	})
+
+	It("times each stack operation once however often it is polled", func() {
+		before := sqs.StackSettleDuration.Count(sqs.StackKindQueue, string(domain.Succeeded))
+		stack := &cloudformation.Stack{
+			StackId:         aws.String("arn:aws:cloudformation:eu-west-2:123456789012:stack/polled/1"),
+			StackName:       aws.String("testprefix-09E1993E-62E2-4040-ADF2-4D3EC741EFE6"),
+			StackStatus:     aws.String(cloudformation.StackStatusUpdateComplete),
+			LastUpdatedTime: aws.Time(time.Now().Add(-time.Minute)),
+		}
+		fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{stack},
+		}, nil)
+
+		for i := 0; i < 3; i++ {
+			_, err := provider.LastOperation(context.Background(), lastOperationData)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(sqs.StackSettleDuration.Count(sqs.StackKindQueue, string(domain.Succeeded))).To(Equal(before + 1))
+
+		stack.LastUpdatedTime = aws.Time(time.Now())
+		_, err := provider.LastOperation(context.Background(), lastOperationData)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sqs.StackSettleDuration.Count(sqs.StackKindQueue, string(domain.Succeeded))).To(Equal(before + 2))
+	})
+
+	It("does not time operations on stacks that last changed long ago", func() {
+		before := sqs.StackSettleDuration.Count(sqs.StackKindQueue, string(domain.Succeeded))
+		fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{{
+				StackId:         aws.String("arn:aws:cloudformation:eu-west-2:123456789012:stack/old/1"),
+				StackName:       aws.String("testprefix-09E1993E-62E2-4040-ADF2-4D3EC741EFE6"),
+				StackStatus:     aws.String(cloudformation.StackStatusUpdateComplete),
+				LastUpdatedTime: aws.Time(time.Now().Add(-48 * time.Hour)),
+			}},
+		}, nil)
+
+		_, err := provider.LastOperation(context.Background(), lastOperationData)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sqs.StackSettleDuration.Count(sqs.StackKindQueue, string(domain.Succeeded))).To(Equal(before))
+	})
+
+	Context("when binding synchronously", func() {
+		var oldPollingInterval time.Duration
+
+		BeforeEach(func() {
+			oldPollingInterval = sqs.PollingInterval
+			sqs.PollingInterval = time.Millisecond
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{{
+					StackName:   aws.String("testprefix-09E1993E-62E2-4040-ADF2-4D3EC741EFE6"),
+					StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+					Outputs: []*cloudformation.Output{{
+						OutputKey:   aws.String(sqs.OutputPrimaryQueueARN),
+						OutputValue: aws.String("arn-1"),
+					}},
+				}},
+			}, nil)
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{{
+					StackName:   aws.String("testprefix-c6ea1339-7ade-4952-9247-e419b59e7b67"),
+					StackStatus: aws.String(cloudformation.StackStatusRollbackComplete),
+				}},
+			}, nil)
+			queueTemplateBody, err := (&sqs.QueueTemplateBuilder{}).Build()
+			Expect(err).NotTo(HaveOccurred())
+			fakeCfnClient.GetTemplateWithContextReturns(&cloudformation.GetTemplateOutput{
+				TemplateBody: aws.String(queueTemplateBody),
+			}, nil)
+		})
+
+		AfterEach(func() {
+			sqs.PollingInterval = oldPollingInterval
+		})
+
+		It("times how long it waited for the binding's stack", func() {
+			before := sqs.SyncBindWait.Count(sqs.OutcomeFailed)
+
+			_, err := provider.Bind(context.Background(), provideriface.BindData{
+				InstanceID: "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+				BindingID:  "c6ea1339-7ade-4952-9247-e419b59e7b67",
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(sqs.SyncBindWait.Count(sqs.OutcomeFailed)).To(Equal(before + 1))
+		})
+	})
+})
@@ -0,0 +1,86 @@
+package sqs
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// NetworkRestrictions limit where a binding's credentials can be used
+// from.  A request is allowed if it comes from any of the source IP
+// ranges, VPCs or VPC endpoints.  The same struct holds the values the
+// operator allows bindings to be restricted to.
+type NetworkRestrictions struct {
+	SourceIPs          []string `json:"allowed_source_ips,omitempty"`
+	SourceVPCs         []string `json:"allowed_source_vpcs,omitempty"`
+	SourceVPCEndpoints []string `json:"allowed_source_vpc_endpoints,omitempty"`
+}
+
+// IsEmpty reports whether there are no restrictions.
+func (n NetworkRestrictions) IsEmpty() bool {
+	return len(n.SourceIPs) == 0 && len(n.SourceVPCs) == 0 && len(n.SourceVPCEndpoints) == 0
+}
+
+// Validate checks that the source IPs are CIDR ranges.
+func (n NetworkRestrictions) Validate() error {
+	for _, cidr := range n.SourceIPs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("allowed_source_ips %#v is not a CIDR range", cidr)
+		}
+	}
+	return nil
+}
+
+// Check returns a 400 failure response unless every restriction is
+// one the operator allows: source IP ranges must fall within an
+// allowed range, and VPCs and VPC endpoints must be allowed by ID.
+func (n NetworkRestrictions) Check(allowed NetworkRestrictions) error {
+	problems := []string{}
+	for _, cidr := range n.SourceIPs {
+		if !cidrAllowed(cidr, allowed.SourceIPs) {
+			problems = append(problems, fmt.Sprintf("allowed_source_ips %#v is not within a range allowed by the operator", cidr))
+		}
+	}
+	for _, vpc := range n.SourceVPCs {
+		if !contains(allowed.SourceVPCs, vpc) {
+			problems = append(problems, fmt.Sprintf("allowed_source_vpcs %#v is not allowed by the operator", vpc))
+		}
+	}
+	for _, vpce := range n.SourceVPCEndpoints {
+		if !contains(allowed.SourceVPCEndpoints, vpce) {
+			problems = append(problems, fmt.Sprintf("allowed_source_vpc_endpoints %#v is not allowed by the operator", vpce))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("%s", strings.Join(problems, "; ")),
+		http.StatusBadRequest,
+		"network-restriction-not-allowed",
+	)
+}
+
+// cidrAllowed reports whether the range cidr lies within one of the
+// allowed ranges.
+func cidrAllowed(cidr string, allowed []string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	size, _ := network.Mask.Size()
+	for _, a := range allowed {
+		_, allowedNetwork, err := net.ParseCIDR(a)
+		if err != nil {
+			continue
+		}
+		allowedSize, _ := allowedNetwork.Mask.Size()
+		if allowedNetwork.Contains(network.IP) && size >= allowedSize {
+			return true
+		}
+	}
+	return false
+}
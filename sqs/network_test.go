@@ -0,0 +1,51 @@
+package sqs_test
+
+import (
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("NetworkRestrictions", func() {
+	allowed := sqs.NetworkRestrictions{
+		SourceIPs:          []string{"10.0.0.0/16", "203.0.113.0/24"},
+		SourceVPCs:         []string{"vpc-1234"},
+		SourceVPCEndpoints: []string{"vpce-5678"},
+	}
+
+	It("should allow ranges within the allowed ranges", func() {
+		Expect(sqs.NetworkRestrictions{
+			SourceIPs:          []string{"10.0.1.0/24", "203.0.113.7/32", "203.0.113.0/24"},
+			SourceVPCs:         []string{"vpc-1234"},
+			SourceVPCEndpoints: []string{"vpce-5678"},
+		}.Check(allowed)).To(Succeed())
+	})
+
+	It("should allow no restrictions", func() {
+		Expect(sqs.NetworkRestrictions{}.Check(sqs.NetworkRestrictions{})).To(Succeed())
+	})
+
+	It("should reject anything the operator has not allowed with a 400", func() {
+		err := sqs.NetworkRestrictions{
+			SourceIPs:          []string{"10.0.0.0/8", "192.0.2.0/24"},
+			SourceVPCs:         []string{"vpc-9999"},
+			SourceVPCEndpoints: []string{"vpce-9999"},
+		}.Check(allowed)
+		Expect(err).To(MatchError(
+			`allowed_source_ips "10.0.0.0/8" is not within a range allowed by the operator; ` +
+				`allowed_source_ips "192.0.2.0/24" is not within a range allowed by the operator; ` +
+				`allowed_source_vpcs "vpc-9999" is not allowed by the operator; ` +
+				`allowed_source_vpc_endpoints "vpce-9999" is not allowed by the operator`,
+		))
+		castErrResponse, ok := err.(*brokerapi.FailureResponse)
+		Expect(ok).To(BeTrue())
+		Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+	})
+
+	It("should reject source IPs that are not CIDR ranges", func() {
+		Expect(sqs.NetworkRestrictions{
+			SourceIPs: []string{"10.0.0.1"},
+		}.Validate()).To(MatchError(`allowed_source_ips "10.0.0.1" is not a CIDR range`))
+	})
+})
@@ -0,0 +1,279 @@
+package sqs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/domain"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+const (
+	QueueTypeStandard = "standard"
+	QueueTypeFIFO     = "fifo"
+)
+
+// PlanConfig describes the queues created for a service plan.  It is
+// read from the plan's metadata in the catalog, for example:
+//
+//	"metadata": {
+//	  "queue_type": "standard",
+//	  "queue_defaults": {"message_retention_period": 3600},
+//	  "queue_limits": {"message_retention_period": {"max": 86400}},
+//	  "binding_mode": "user",
+//	  "access_policies": ["producer", "consumer"]
+//	}
+//
+// queue_defaults are applied on provision for any parameter the user
+// does not set, and queue_limits restrict the values users may choose.
+// binding_mode is "user" for bindings with an IAM user and access key,
+// or "role" for bindings with an IAM role.  access_policies restricts
+// the access policies bindings may use, which are otherwise all of the
+// built-in and operator-defined policies.
+type PlanConfig struct {
+	// PlanID is the ID of the plan in the catalog
+	PlanID         string                `json:"-"`
+	QueueType      string                `json:"queue_type"`
+	Defaults       QueueParams           `json:"queue_defaults"`
+	Limits         map[string]ParamLimit `json:"queue_limits"`
+	BindingMode    string                `json:"binding_mode"`
+	AccessPolicies []string              `json:"access_policies"`
+}
+
+// ParamLimit is an inclusive range of values allowed for a numeric
+// queue parameter.  A nil bound is not enforced.
+type ParamLimit struct {
+	Min *int `json:"min,omitempty"`
+	Max *int `json:"max,omitempty"`
+}
+
+// NewPlanConfig reads the queue configuration from the plan's
+// metadata.  Plans without a queue_type are standard queues, unless
+// the plan is named "fifo" which is how FIFO plans were identified
+// before queue_type existed.
+func NewPlanConfig(plan domain.ServicePlan) (*PlanConfig, error) {
+	config := &PlanConfig{PlanID: plan.ID}
+	if plan.Metadata != nil && plan.Metadata.AdditionalMetadata != nil {
+		data, err := json.Marshal(plan.Metadata.AdditionalMetadata)
+		if err != nil {
+			return nil, err
+		}
+		var metadata struct {
+			QueueType      string                `json:"queue_type"`
+			Defaults       json.RawMessage       `json:"queue_defaults"`
+			Limits         map[string]ParamLimit `json:"queue_limits"`
+			BindingMode    string                `json:"binding_mode"`
+			AccessPolicies []string              `json:"access_policies"`
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("invalid queue configuration for plan %s: %s", plan.Name, err)
+		}
+		config.QueueType = metadata.QueueType
+		config.Limits = metadata.Limits
+		config.BindingMode = metadata.BindingMode
+		config.AccessPolicies = metadata.AccessPolicies
+		if metadata.Defaults != nil {
+			decoder := json.NewDecoder(bytes.NewReader(metadata.Defaults))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&config.Defaults); err != nil {
+				return nil, fmt.Errorf("invalid queue_defaults for plan %s: %s", plan.Name, err)
+			}
+		}
+	}
+	if config.QueueType == "" {
+		config.QueueType = QueueTypeStandard
+		if plan.Name == QueueTypeFIFO {
+			config.QueueType = QueueTypeFIFO
+		}
+	}
+	if config.BindingMode == "" {
+		config.BindingMode = BindingModeUser
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid queue configuration for plan %s: %s", plan.Name, err)
+	}
+	return config, nil
+}
+
+func (c *PlanConfig) validate() error {
+	if c.QueueType != QueueTypeStandard && c.QueueType != QueueTypeFIFO {
+		return fmt.Errorf("unknown queue_type %#v", c.QueueType)
+	}
+	if c.BindingMode != BindingModeUser && c.BindingMode != BindingModeRole {
+		return fmt.Errorf("unknown binding_mode %#v", c.BindingMode)
+	}
+	if !c.IsFIFO() && c.Defaults.HasFIFOParams() {
+		return fmt.Errorf("queue_defaults contains FIFO only parameters")
+	}
+	numeric := numericParamNames()
+	for name, limit := range c.Limits {
+		if !contains(numeric, name) {
+			return fmt.Errorf("queue_limits has unknown numeric parameter %#v", name)
+		}
+		if limit.Min != nil && limit.Max != nil && *limit.Min > *limit.Max {
+			return fmt.Errorf("queue_limits for %s has min greater than max", name)
+		}
+	}
+	if err := c.CheckLimits(c.Defaults); err != nil {
+		return fmt.Errorf("queue_defaults outside of queue_limits: %s", err)
+	}
+	// queues get the template's default for anything the user and the
+	// plan leave unset, so that has to be within the limits too
+	defaults, err := templateDefaults()
+	if err != nil {
+		return err
+	}
+	if err := c.CheckLimits(withDefaults(c.Defaults, defaults)); err != nil {
+		return fmt.Errorf("queue_limits exclude the default value, so queue_defaults must set one within them: %s", err)
+	}
+	return nil
+}
+
+// CheckAccessPolicies checks that the plan only restricts bindings to
+// access policies that exist, given the operator-defined policies.
+func (c *PlanConfig) CheckAccessPolicies(custom map[string][]string) error {
+	for _, name := range c.AccessPolicies {
+		if !isBuiltinAccessPolicy(name) && custom[name] == nil {
+			return fmt.Errorf("access_policies has unknown access policy %#v", name)
+		}
+	}
+	return nil
+}
+
+// AccessPolicyNames returns the names of the access policies that
+// bindings of the plan may use, built-in policies first.
+func (c *PlanConfig) AccessPolicyNames(custom map[string][]string) []string {
+	names := []string{}
+	for _, name := range BuiltinAccessPolicies {
+		names = append(names, name)
+	}
+	customNames := []string{}
+	for name := range custom {
+		if !isBuiltinAccessPolicy(name) {
+			customNames = append(customNames, name)
+		}
+	}
+	sort.Strings(customNames)
+	names = append(names, customNames...)
+	if len(c.AccessPolicies) == 0 {
+		return names
+	}
+	allowed := []string{}
+	for _, name := range names {
+		if contains(c.AccessPolicies, name) {
+			allowed = append(allowed, name)
+		}
+	}
+	return allowed
+}
+
+// IsFIFO reports whether the plan creates FIFO queues.
+func (c *PlanConfig) IsFIFO() bool {
+	return c.QueueType == QueueTypeFIFO
+}
+
+// DefaultParams returns a copy of the plan's default queue parameters
+// that is safe to decode user parameters on top of.
+func (c *PlanConfig) DefaultParams() (QueueParams, error) {
+	params := QueueParams{}
+	data, err := json.Marshal(c.Defaults)
+	if err != nil {
+		return params, err
+	}
+	err = json.Unmarshal(data, &params)
+	return params, err
+}
+
+// NamedQueueParams returns params with the plan's defaults filled in
+// for any parameter that is not set, other than the shared parameters
+// which named queues take from the default queue.
+func (c *PlanConfig) NamedQueueParams(params QueueParams) (QueueParams, error) {
+	defaults, err := c.DefaultParams()
+	if err != nil {
+		return params, err
+	}
+	defaults.KmsDataKeyReusePeriodSeconds = nil
+	defaults.KmsMasterKeyId = nil
+	defaults.SqsManagedSseEnabled = nil
+	return withDefaults(params, defaults), nil
+}
+
+// withDefaults returns params with the value from defaults for any
+// parameter that is not set.
+func withDefaults(params, defaults QueueParams) QueueParams {
+	v := reflect.ValueOf(&params).Elem()
+	d := reflect.ValueOf(defaults)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsNil() {
+			v.Field(i).Set(d.Field(i))
+		}
+	}
+	return params
+}
+
+// CheckLimits returns a 400 failure response if any of the parameters
+// fall outside the plan's limits.
+func (c *PlanConfig) CheckLimits(params QueueParams) error {
+	values := map[string]*int{}
+	v := reflect.ValueOf(params)
+	for i := 0; i < v.NumField(); i++ {
+		if ptr, ok := v.Field(i).Interface().(*int); ok {
+			values[jsonName(v.Type().Field(i))] = ptr
+		}
+	}
+	names := []string{}
+	for name := range c.Limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		limit := c.Limits[name]
+		value := values[name]
+		if value == nil {
+			continue
+		}
+		if (limit.Min != nil && *value < *limit.Min) || (limit.Max != nil && *value > *limit.Max) {
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("%s must be %s for this plan", name, limit),
+				http.StatusBadRequest,
+				"parameter-out-of-range",
+			)
+		}
+	}
+	return nil
+}
+
+func (l ParamLimit) String() string {
+	switch {
+	case l.Min != nil && l.Max != nil:
+		return fmt.Sprintf("between %d and %d", *l.Min, *l.Max)
+	case l.Min != nil:
+		return fmt.Sprintf("at least %d", *l.Min)
+	case l.Max != nil:
+		return fmt.Sprintf("at most %d", *l.Max)
+	default:
+		return "unrestricted"
+	}
+}
+
+// numericParamNames returns the json names of the integer fields of
+// QueueParams, which are the only ones that can be limited.
+func numericParamNames() []string {
+	names := []string{}
+	t := reflect.TypeOf(QueueParams{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type == reflect.TypeOf((*int)(nil)) {
+			names = append(names, jsonName(t.Field(i)))
+		}
+	}
+	return names
+}
+
+func jsonName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
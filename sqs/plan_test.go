@@ -0,0 +1,250 @@
+package sqs_test
+
+import (
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain"
+)
+
+var _ = Describe("PlanConfig", func() {
+	var plan domain.ServicePlan
+
+	BeforeEach(func() {
+		plan = domain.ServicePlan{
+			ID:   "uuid-2",
+			Name: "standard",
+		}
+	})
+
+	It("should default to standard queues", func() {
+		config, err := sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.IsFIFO()).To(BeFalse())
+	})
+
+	It("should treat a plan named fifo without a queue_type as FIFO", func() {
+		plan.Name = "fifo"
+		config, err := sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.IsFIFO()).To(BeTrue())
+	})
+
+	It("should read the queue type from the metadata", func() {
+		plan.Name = "ordered"
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_type": "fifo",
+			},
+		}
+		config, err := sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.IsFIFO()).To(BeTrue())
+	})
+
+	It("should reject unknown queue types", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_type": "lifo",
+			},
+		}
+		_, err := sqs.NewPlanConfig(plan)
+		Expect(err).To(MatchError(ContainSubstring("unknown queue_type \"lifo\"")))
+	})
+
+	It("should default to user bindings", func() {
+		config, err := sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.BindingMode).To(Equal(sqs.BindingModeUser))
+	})
+
+	It("should reject unknown binding modes", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"binding_mode": "group",
+			},
+		}
+		_, err := sqs.NewPlanConfig(plan)
+		Expect(err).To(MatchError(ContainSubstring("unknown binding_mode \"group\"")))
+	})
+
+	Describe("access policies", func() {
+		custom := map[string][]string{"redrive": {"sqs:StartMessageMoveTask"}}
+
+		It("should allow every access policy by default", func() {
+			config, err := sqs.NewPlanConfig(plan)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.AccessPolicyNames(custom)).To(Equal(append(append([]string{}, sqs.BuiltinAccessPolicies...), "redrive")))
+		})
+
+		It("should restrict the access policies to those listed", func() {
+			plan.Metadata = &domain.ServicePlanMetadata{
+				AdditionalMetadata: map[string]interface{}{
+					"access_policies": []interface{}{"redrive", "consumer"},
+				},
+			}
+			config, err := sqs.NewPlanConfig(plan)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.CheckAccessPolicies(custom)).To(Succeed())
+			Expect(config.AccessPolicyNames(custom)).To(Equal([]string{"consumer", "redrive"}))
+		})
+
+		It("should list a redefined built-in access policy once", func() {
+			config, err := sqs.NewPlanConfig(plan)
+			Expect(err).ToNot(HaveOccurred())
+			redefined := map[string][]string{"monitor": {"sqs:GetQueueAttributes"}, "redrive": {"sqs:StartMessageMoveTask"}}
+			Expect(config.AccessPolicyNames(redefined)).To(Equal(append(append([]string{}, sqs.BuiltinAccessPolicies...), "redrive")))
+		})
+
+		It("should reject access policies that do not exist", func() {
+			plan.Metadata = &domain.ServicePlanMetadata{
+				AdditionalMetadata: map[string]interface{}{
+					"access_policies": []interface{}{"redrive"},
+				},
+			}
+			config, err := sqs.NewPlanConfig(plan)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.CheckAccessPolicies(nil)).To(MatchError("access_policies has unknown access policy \"redrive\""))
+		})
+	})
+
+	It("should reject unknown default parameters", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_defaults": map[string]interface{}{
+					"mango": 1,
+				},
+			},
+		}
+		_, err := sqs.NewPlanConfig(plan)
+		Expect(err).To(MatchError(ContainSubstring("unknown field \"mango\"")))
+	})
+
+	It("should reject FIFO only defaults for standard plans", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_defaults": map[string]interface{}{
+					"content_based_deduplication": true,
+				},
+			},
+		}
+		_, err := sqs.NewPlanConfig(plan)
+		Expect(err).To(MatchError(ContainSubstring("FIFO only")))
+	})
+
+	It("should reject limits for non-numeric parameters", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_limits": map[string]interface{}{
+					"kms_master_key_id": map[string]interface{}{"max": 1},
+				},
+			},
+		}
+		_, err := sqs.NewPlanConfig(plan)
+		Expect(err).To(MatchError(ContainSubstring("unknown numeric parameter \"kms_master_key_id\"")))
+	})
+
+	It("should reject defaults outside of the limits", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_defaults": map[string]interface{}{
+					"message_retention_period": 90000,
+				},
+				"queue_limits": map[string]interface{}{
+					"message_retention_period": map[string]interface{}{"max": 86400},
+				},
+			},
+		}
+		_, err := sqs.NewPlanConfig(plan)
+		Expect(err).To(MatchError(ContainSubstring("message_retention_period must be at most 86400")))
+	})
+
+	It("should reject limits that exclude the default value when the plan sets none", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_limits": map[string]interface{}{
+					"message_retention_period": map[string]interface{}{"max": 86400},
+				},
+			},
+		}
+		_, err := sqs.NewPlanConfig(plan)
+		Expect(err).To(MatchError(ContainSubstring("queue_defaults must set one within them: message_retention_period must be at most 86400")))
+	})
+
+	It("should allow limits that include the default value", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_limits": map[string]interface{}{
+					"message_retention_period": map[string]interface{}{"min": 3600, "max": 604800},
+				},
+			},
+		}
+		_, err := sqs.NewPlanConfig(plan)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("with limits", func() {
+		var config *sqs.PlanConfig
+
+		BeforeEach(func() {
+			plan.Metadata = &domain.ServicePlanMetadata{
+				AdditionalMetadata: map[string]interface{}{
+					"queue_defaults": map[string]interface{}{
+						"delay_seconds":            10,
+						"message_retention_period": 3600,
+					},
+					"queue_limits": map[string]interface{}{
+						"delay_seconds":            map[string]interface{}{"min": 10},
+						"message_retention_period": map[string]interface{}{"min": 60, "max": 86400},
+					},
+				},
+			}
+			var err error
+			config, err = sqs.NewPlanConfig(plan)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should allow values within the limits", func() {
+			Expect(config.CheckLimits(sqs.QueueParams{
+				DelaySeconds:           intPtr(10),
+				MessageRetentionPeriod: intPtr(86400),
+			})).To(Succeed())
+		})
+
+		It("should allow unset values", func() {
+			Expect(config.CheckLimits(sqs.QueueParams{})).To(Succeed())
+		})
+
+		It("should reject values outside the limits with a 400", func() {
+			err := config.CheckLimits(sqs.QueueParams{
+				MessageRetentionPeriod: intPtr(86401),
+			})
+			Expect(err).To(MatchError("message_retention_period must be between 60 and 86400 for this plan"))
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+		})
+	})
+
+	It("should return defaults that are independent of the plan", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_defaults": map[string]interface{}{
+					"delay_seconds": 5,
+				},
+			},
+		}
+		config, err := sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		params, err := config.DefaultParams()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*params.DelaySeconds).To(Equal(5))
+		*params.DelaySeconds = 6
+		Expect(*config.Defaults.DelaySeconds).To(Equal(5))
+	})
+})
+
+func intPtr(i int) *int {
+	return &i
+}
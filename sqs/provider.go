@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,6 +20,7 @@ import (
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/pivotal-cf/brokerapi/domain"
 	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"gopkg.in/yaml.v2"
 )
 
 var (
@@ -46,39 +49,55 @@ const (
 	TagName           = "Name"
 	TagService        = "Service"
 	TagServiceId      = "ServiceID"
+	// TagTemplateVersion is the stack tag recording the version of
+	// the template the stack was built from
+	TagTemplateVersion = "TemplateVersion"
+	// TagPlanId is the stack tag recording the plan of an instance
+	TagPlanId = "PlanID"
+	// TagInstanceId is the stack tag recording the instance a binding
+	// belongs to
+	TagInstanceId = "InstanceID"
 )
 
 type Provider struct {
-	Environment          string // Name of environment to tag resources with
-	Client               Client // AWS SDK compatible client
-	ResourcePrefix       string // AWS resources with be named with this prefix
-	AdditionalUserPolicy string // IAM users created on bind will have this policy attached
-	PermissionsBoundary  string // IAM users created on bind will have this boundary
-	Timeout              time.Duration
-	Logger               lager.Logger
+	Environment            string                 // Name of environment to tag resources with
+	Client                 Client                 // AWS SDK compatible client
+	ResourcePrefix         string                 // AWS resources with be named with this prefix
+	AdditionalUserPolicy   string                 // IAM users created on bind will have this policy attached
+	PermissionsBoundary    string                 // IAM users created on bind will have this boundary
+	AllowedKMSKeys         []string               // KMS key ARNs that users may encrypt queues with
+	AllowedSNSAccountIDs   []string               // AWS accounts whose SNS topics queues may subscribe to
+	AllowedSNSTopicARNs    []string               // SNS topic ARN prefixes that queues may subscribe to
+	RequireSecureTransport bool                   // Queue policies will deny access without TLS
+	RoleTrustedPrincipal   string                 // IAM principal that may assume the roles of role-based bindings
+	SQSEndpoint            string                 // SQS endpoint given in binding credentials, if not the default
+	PlanConfigs            map[string]*PlanConfig // Configuration of each plan, keyed by plan ID
+	AccessPolicies         map[string][]string    // Operator-defined access policies, keyed by name
+	AllowedNetworks        NetworkRestrictions    // Network restrictions bindings may use
+	SecretKMSKey           string                 // KMS key ARN binding secrets are encrypted with, if not the default
+	SecretRecoveryWindow   int64                  // Days a deleted binding secret can be recovered for, if not the default
+	SecretForceDelete      bool                   // Binding secrets will be deleted without recovery
+	ContextTagKeys         ContextTagKeys         // Keys of the tags recording the org, space and app resources belong to
+	Timeout                time.Duration
+	Logger                 lager.Logger
 }
 
 func (s *Provider) Provision(ctx context.Context, provisionData provideriface.ProvisionData) (*domain.ProvisionedServiceSpec, error) {
-	queueTemplate := QueueTemplateBuilder{}
-	queueTemplate.QueueName = s.getStackName(provisionData.InstanceID)
-
-	queueTemplate.Tags = map[string]string{
-		TagName:           provisionData.InstanceID,
-		TagService:        "sqs",
-		TagServiceId:      provisionData.Details.ServiceID,
-		TagEnvironment:    s.Environment,
-		TagCostAllocation: provisionData.InstanceID,
+	planConfig, err := NewPlanConfig(provisionData.Plan)
+	if err != nil {
+		return nil, err
 	}
-	if provisionData.Plan.Name == "fifo" {
-		queueTemplate.FIFOQueue = true
+	// the schema is checked first so that problems are reported by
+	// parameter, rather than by the decoder
+	if err := validateInstanceParameters(planConfig, provisionData.Details.RawParameters, false); err != nil {
+		return nil, err
 	}
 
-	tmpl, err := queueTemplate.Build()
+	defaults, err := planConfig.DefaultParams()
 	if err != nil {
 		return nil, err
 	}
-
-	params := QueueParams{}
+	params := InstanceParams{QueueParams: defaults}
 	if provisionData.Details.RawParameters != nil {
 		decoder := json.NewDecoder(bytes.NewReader(provisionData.Details.RawParameters))
 		decoder.DisallowUnknownFields()
@@ -90,12 +109,50 @@ func (s *Provider) Provision(ctx context.Context, provisionData provideriface.Pr
 			)
 		}
 	}
+	if err := s.validateQueueParams(params.QueueParams, planConfig); err != nil {
+		return nil, err
+	}
+	templateParams := params.TemplateParams(nil)
+	if err := s.validateTemplateParams(templateParams); err != nil {
+		return nil, err
+	}
+	queues, err := s.namedQueueParams(params.Queues, nil, planConfig, s.maxQueueNameLength(provisionData.InstanceID, planConfig.IsFIFO()))
+	if err != nil {
+		return nil, err
+	}
+	platformContext, err := ParsePlatformContext(provisionData.Details.RawContext)
+	if err != nil {
+		return nil, err
+	}
+	if platformContext.OrganizationGUID == "" {
+		platformContext.OrganizationGUID = provisionData.Details.OrganizationGUID
+	}
+	if platformContext.SpaceGUID == "" {
+		platformContext.SpaceGUID = provisionData.Details.SpaceGUID
+	}
+	contextTags := s.ContextTagKeys.Tags(platformContext)
+
+	queueTemplate := s.queueTemplateBuilder(provisionData.InstanceID, provisionData.Details.ServiceID, planConfig)
+	queueTemplate.QueueTemplateParams = templateParams
+	queueTemplate.Tags = withTemplateTags(queueTemplate.Tags, contextTags)
+
+	tmpl, err := queueTemplate.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	stackParams := params.CreateParams()
+	for _, name := range templateParams.QueueNames {
+		queue := queues[name]
+		stackParams = append(stackParams, queue.NamedQueueCreateParams(name)...)
+	}
 
 	_, err = s.Client.CreateStackWithContext(ctx, &cloudformation.CreateStackInput{
 		Capabilities: capabilities,
 		TemplateBody: aws.String(tmpl),
 		StackName:    aws.String(s.getStackName(provisionData.InstanceID)),
-		Parameters:   params.CreateParams(),
+		Parameters:   stackParams,
+		Tags:         withContextTags(queueStackTags(nil, planConfig), contextTags),
 	})
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "AlreadyExistsException" {
@@ -145,6 +202,55 @@ func (s *Provider) Deprovision(ctx context.Context, deprovisionData providerifac
 }
 
 func (s *Provider) Bind(ctx context.Context, bindData provideriface.BindData) (*domain.Binding, error) {
+	planConfig := s.planConfig(bindData.Details.PlanID)
+	accessPolicyNames := planConfig.AccessPolicyNames(s.AccessPolicies)
+	if err := ValidateParameters(BindingSchema(accessPolicyNames), bindData.Details.RawParameters); err != nil {
+		return nil, err
+	}
+	bindParams := UserTemplateBuilder{AccessPolicies: s.AccessPolicies}
+	if bindData.Details.RawParameters != nil {
+		decoder := json.NewDecoder(bytes.NewReader(bindData.Details.RawParameters))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&bindParams); err != nil {
+			return nil, apiresponses.NewFailureResponse(
+				err,
+				http.StatusBadRequest,
+				"bad-json-format",
+			)
+		}
+	}
+	accessPolicy := bindParams.AccessPolicy
+	if accessPolicy == "" {
+		accessPolicy = AccessPolicyFull
+	}
+	for _, policy := range []AccessPolicy{accessPolicy, bindParams.DeadLetterAccessPolicy} {
+		if policy == "" {
+			continue
+		}
+		if _, err := bindParams.accessPolicyActions(policy); err != nil {
+			return nil, err
+		}
+		if !contains(accessPolicyNames, policy) {
+			return nil, apiresponses.NewFailureResponse(
+				fmt.Errorf("access policy %#v is not available on this plan", policy),
+				http.StatusBadRequest,
+				"unknown-access-policy",
+			)
+		}
+	}
+	if err := bindParams.NetworkRestrictions.Check(s.AllowedNetworks); err != nil {
+		return nil, err
+	}
+	platformContext, err := ParsePlatformContext(bindData.Details.RawContext)
+	if err != nil {
+		return nil, err
+	}
+	platformContext.AppGUID = bindData.Details.AppGUID
+	if bindData.Details.BindResource != nil && bindData.Details.BindResource.AppGuid != "" {
+		platformContext.AppGUID = bindData.Details.BindResource.AppGuid
+	}
+	contextTags := s.ContextTagKeys.Tags(platformContext)
+
 	queueStackName := s.getStackName(bindData.InstanceID)
 	queueStack, err := s.getStack(ctx, queueStackName)
 	if err == ErrStackNotFound {
@@ -156,33 +262,19 @@ func (s *Provider) Bind(ctx context.Context, bindData provideriface.BindData) (*
 		return nil, err // should this be async and checked later
 	}
 
-	userTemplate := UserTemplateBuilder{
-		BindingID:            bindData.BindingID,
-		ResourcePrefix:       s.ResourcePrefix,
-		AdditionalUserPolicy: s.AdditionalUserPolicy,
-		PermissionsBoundary:  s.PermissionsBoundary,
-		Tags: map[string]string{
-			TagName:           bindData.BindingID,
-			TagService:        "sqs",
-			TagServiceId:      bindData.Details.ServiceID,
-			TagEnvironment:    s.Environment,
-			TagCostAllocation: bindData.InstanceID,
-		},
-		PrimaryQueueARN:   getStackOutput(queueStack, OutputPrimaryQueueARN),
-		PrimaryQueueURL:   getStackOutput(queueStack, OutputPrimaryQueueURL),
-		SecondaryQueueARN: getStackOutput(queueStack, OutputSecondaryQueueARN),
-		SecondaryQueueURL: getStackOutput(queueStack, OutputSecondaryQueueURL),
+	userTemplate, err := s.userTemplateBuilder(ctx, queueStack, bindData.InstanceID, bindData.Details.ServiceID, bindData.BindingID)
+	if err != nil {
+		return nil, err
 	}
 
-	if bindData.Details.RawParameters != nil {
-		decoder := json.NewDecoder(bytes.NewReader(bindData.Details.RawParameters))
-		decoder.DisallowUnknownFields()
-		if err := decoder.Decode(&userTemplate); err != nil {
-			return nil, apiresponses.NewFailureResponse(
-				err,
-				http.StatusBadRequest,
-				"bad-json-format",
-			)
+	userTemplate.Tags = withTemplateTags(userTemplate.Tags, contextTags)
+	userTemplate.AccessPolicy = bindParams.AccessPolicy
+	userTemplate.DeadLetterAccessPolicy = bindParams.DeadLetterAccessPolicy
+	userTemplate.NetworkRestrictions = bindParams.NetworkRestrictions
+	if planConfig.BindingMode == BindingModeRole {
+		userTemplate.Role, err = NewBindingRole()
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -196,6 +288,7 @@ func (s *Provider) Bind(ctx context.Context, bindData provideriface.BindData) (*
 		Capabilities: capabilities,
 		TemplateBody: aws.String(tmpl),
 		StackName:    aws.String(bindingStackName),
+		Tags:         withContextTags(bindingStackTags(nil, bindData.InstanceID), contextTags),
 	})
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "AlreadyExistsException" {
@@ -250,20 +343,31 @@ func (s *Provider) getBindingSync(ctx context.Context, bindingStackName string)
 // stack referenced by name is in a success or failed state, the context is
 // canceled or the is an error returned from cloudformation
 func (s *Provider) waitForBindingOperationComplete(ctx context.Context, stackName string) error {
+	start := time.Now()
+	outcome, err := s.waitForBindingStack(ctx, stackName, BindOperation)
+	SyncBindWait.Observe(time.Since(start).Seconds(), outcome)
+	return err
+}
+
+// waitForBindingStack polls the binding stack until the operation in
+// opData succeeds or fails, returning the outcome it waited for. An
+// unbind only succeeds once the stack has gone and the binding's
+// secret has been deleted, see lastBindingOperation.
+func (s *Provider) waitForBindingStack(ctx context.Context, stackName string, opData string) (string, error) {
 	for {
 		select {
 		case <-ctx.Done():
-			return ErrBindingDeadlineExceeded
+			return OutcomeTimeout, ErrBindingDeadlineExceeded
 		case <-time.After(PollingInterval):
-			lastOperation, err := s.lastBindingOperation(ctx, stackName, BindOperation)
+			lastOperation, err := s.lastBindingOperation(ctx, stackName, opData)
 			if err != nil {
-				return err
+				return OutcomeFailed, err
 			}
 			switch lastOperation.State {
 			case domain.Succeeded:
-				return nil
+				return OutcomeSucceeded, nil
 			case domain.Failed:
-				return fmt.Errorf("%s", lastOperation.Description)
+				return OutcomeFailed, fmt.Errorf("%s", lastOperation.Description)
 			default:
 				continue
 			}
@@ -276,7 +380,10 @@ func (s *Provider) Unbind(ctx context.Context, unbindData provideriface.UnbindDa
 	stack, err := s.getStack(ctx, stackName)
 	if err == ErrStackNotFound {
 		// resource is already deleted (or never existsed)
-		// so we're done here
+		// so we're done here, once its secret is gone too
+		if err := s.deleteBindingSecret(ctx, unbindData.BindingID); err != nil {
+			return nil, err
+		}
 		return &domain.UnbindSpec{
 			OperationData: UnbindOperation,
 			IsAsync:       false,
@@ -287,9 +394,14 @@ func (s *Provider) Unbind(ctx context.Context, unbindData provideriface.UnbindDa
 	}
 	if *stack.StackStatus == cloudformation.StackStatusDeleteComplete {
 		// resource already deleted
+		if err := s.deleteBindingSecret(ctx, unbindData.BindingID); err != nil {
+			return nil, err
+		}
 		return &domain.UnbindSpec{}, nil
 	}
 	// trigger a delete unless we're already in a deleting state
+	// the binding's secret is deleted once the stack has gone, see
+	// lastBindingOperation
 	if *stack.StackStatus != cloudformation.StackStatusDeleteInProgress {
 		_, err := s.Client.DeleteStackWithContext(ctx, &cloudformation.DeleteStackInput{
 			StackName: aws.String(stackName),
@@ -299,36 +411,669 @@ func (s *Provider) Unbind(ctx context.Context, unbindData provideriface.UnbindDa
 		}
 	}
 
+	if !unbindData.AsyncAllowed {
+		// nobody will poll for a synchronous unbind, so wait for the
+		// stack to go and its secret to be deleted before returning
+		if _, err := s.waitForBindingStack(ctx, stackName, UnbindOperation); err != nil {
+			return nil, err
+		}
+		return &domain.UnbindSpec{}, nil
+	}
+
 	return &domain.UnbindSpec{
 		OperationData: UnbindOperation,
-		IsAsync:       unbindData.AsyncAllowed,
+		IsAsync:       true,
 	}, nil
 }
 
 func (s *Provider) Update(ctx context.Context, updateData provideriface.UpdateData) (*domain.UpdateServiceSpec, error) {
-	params := QueueParams{}
+	planConfig, err := NewPlanConfig(updateData.Plan)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateInstanceParameters(planConfig, updateData.Details.RawParameters, true); err != nil {
+		return nil, err
+	}
+	params := InstanceParams{}
 	if updateData.Details.RawParameters != nil {
-		if err := json.Unmarshal(updateData.Details.RawParameters, &params); err != nil {
-			return nil, err
+		decoder := json.NewDecoder(bytes.NewReader(updateData.Details.RawParameters))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&params); err != nil {
+			return nil, apiresponses.NewFailureResponse(
+				err,
+				http.StatusBadRequest,
+				"bad-json-format",
+			)
 		}
 	}
-
-	_, err := s.Client.UpdateStackWithContext(ctx, &cloudformation.UpdateStackInput{
-		Capabilities:        capabilities,
-		StackName:           aws.String(s.getStackName(updateData.InstanceID)),
-		Parameters:          params.UpdateParams(),
-		UsePreviousTemplate: aws.Bool(true),
-	})
+	if err := s.validateQueueParams(params.QueueParams, planConfig); err != nil {
+		return nil, err
+	}
+	if err := s.validateTemplateParams(params.TemplateParams(nil)); err != nil {
+		return nil, err
+	}
+	platformContext, err := ParsePlatformContext(updateData.Details.RawContext)
 	if err != nil {
 		return nil, err
 	}
 
+	stackName := s.getStackName(updateData.InstanceID)
+	stack, err := s.getStack(ctx, stackName)
+	if err == ErrStackNotFound {
+		return nil, apiresponses.ErrInstanceDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := s.validateKMSKeyChange(ctx, stack, updateData.InstanceID, params.KmsMasterKeyId); err != nil {
+		return nil, err
+	}
+	if updateData.Details.PlanID != updateData.Details.PreviousValues.PlanID {
+		if err := s.validatePlanChange(ctx, stack, updateData.InstanceID, planConfig, params); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.updateQueueStack(ctx, stack, updateData.InstanceID, updateData.Details.ServiceID, planConfig, params, s.ContextTagKeys.Tags(platformContext))
+	if IsNoUpdatesError(err) {
+		// nothing changed, so there is no operation to poll
+		return &domain.UpdateServiceSpec{
+			IsAsync: false,
+		}, nil
+	} else if err != nil {
+		return nil, updateStackError(err)
+	}
+
 	return &domain.UpdateServiceSpec{
 		OperationData: UpdateOperation,
 		IsAsync:       true,
 	}, nil
 }
 
+// InstanceDetails are the parameters reported when an instance is
+// retrieved: the effective parameters of its queues, including any
+// plan defaults, and where to find them.
+type InstanceDetails struct {
+	InstanceParams
+	PrimaryQueueURL   string                `json:"primary_queue_url"`
+	SecondaryQueueURL string                `json:"secondary_queue_url,omitempty"`
+	NamedQueues       map[string]NamedQueue `json:"named_queues,omitempty"`
+}
+
+// ErrInstanceNotFound is returned when retrieving an instance that
+// does not exist or is still being provisioned.
+var ErrInstanceNotFound = apiresponses.NewFailureResponseBuilder(
+	fmt.Errorf("instance does not exist"),
+	http.StatusNotFound,
+	"instance-not-found",
+).WithEmptyResponse().Build()
+
+// GetInstance reports the plan and effective parameters of an
+// instance, read from its stack.  Instances created before the plan
+// was recorded on the stack report an empty plan until they are next
+// updated.
+func (s *Provider) GetInstance(ctx context.Context, instanceID string) (*domain.GetInstanceDetailsSpec, error) {
+	stackName := s.getStackName(instanceID)
+	stack, err := s.getStack(ctx, stackName)
+	if err == ErrStackNotFound {
+		return nil, ErrInstanceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	switch aws.StringValue(stack.StackStatus) {
+	case cloudformation.StackStatusCreateInProgress,
+		cloudformation.StackStatusCreateFailed,
+		cloudformation.StackStatusRollbackInProgress,
+		cloudformation.StackStatusRollbackComplete,
+		cloudformation.StackStatusRollbackFailed,
+		cloudformation.StackStatusDeleteInProgress,
+		cloudformation.StackStatusDeleteComplete:
+		return nil, ErrInstanceNotFound
+	case cloudformation.StackStatusUpdateInProgress,
+		cloudformation.StackStatusUpdateCompleteCleanupInProgress,
+		cloudformation.StackStatusUpdateRollbackInProgress,
+		cloudformation.StackStatusUpdateRollbackCompleteCleanupInProgress:
+		return nil, apiresponses.ErrConcurrentInstanceAccess
+	}
+
+	body, err := s.getTemplateBody(ctx, stackName)
+	if err != nil {
+		return nil, err
+	}
+	templateParams, err := ParseQueueTemplateParams(body)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := templateResourceTags(body, ResourcePrimaryQueue)
+	if err != nil {
+		return nil, err
+	}
+
+	details := InstanceDetails{
+		InstanceParams: InstanceParams{
+			SNSSubscriptions: templateParams.SNSSubscriptions,
+			QueuePolicy:      templateParams.QueuePolicy,
+		},
+		PrimaryQueueURL:   getStackOutput(stack, OutputPrimaryQueueURL),
+		SecondaryQueueURL: getStackOutput(stack, OutputSecondaryQueueURL),
+	}
+	// the template declares the FIFO only parameters for every queue, so
+	// standard queues have their defaults, which do not apply to them
+	fifo := strings.HasSuffix(getStackOutput(stack, OutputPrimaryQueueURL), ExtFIFO)
+	details.QueueParams, err = StackQueueParams(stack, "")
+	if err != nil {
+		return nil, err
+	}
+	if !fifo {
+		details.QueueParams.WithoutFIFOParams()
+	}
+	if len(templateParams.QueueNames) > 0 {
+		details.Queues = map[string]*QueueParams{}
+		details.NamedQueues = namedQueueOutputs(stack, templateParams.QueueNames)
+	}
+	for _, name := range templateParams.QueueNames {
+		params, err := StackQueueParams(stack, NamedQueuePrefix(name))
+		if err != nil {
+			return nil, err
+		}
+		if !fifo {
+			params.WithoutFIFOParams()
+		}
+		details.Queues[name] = &params
+	}
+
+	return &domain.GetInstanceDetailsSpec{
+		ServiceID:  tags[TagServiceId],
+		PlanID:     getStackTag(stack, TagPlanId),
+		Parameters: details,
+	}, nil
+}
+
+// validateKMSKeyChange refuses to set or change the KMS key of an
+// instance that has bindings, as their policies only grant the key the
+// queues were encrypted with when they were created.
+func (s *Provider) validateKMSKeyChange(ctx context.Context, stack *cloudformation.Stack, instanceID string, kmsMasterKeyID *string) error {
+	if kmsMasterKeyID == nil || *kmsMasterKeyID == "" {
+		return nil
+	}
+	for _, param := range stack.Parameters {
+		if aws.StringValue(param.ParameterKey) == ParamKmsMasterKeyId && aws.StringValue(param.ParameterValue) == *kmsMasterKeyID {
+			return nil
+		}
+	}
+	hasBindings, err := s.hasBindings(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	if hasBindings {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("kms_master_key_id cannot be set or changed while the instance has bindings, unbind them first"),
+			http.StatusUnprocessableEntity,
+			"kms-key-change-with-bindings",
+		)
+	}
+	return nil
+}
+
+// validatePlanChange checks that the values the instance's queues keep
+// when it moves to another plan are within the new plan's limits.
+func (s *Provider) validatePlanChange(ctx context.Context, stack *cloudformation.Stack, instanceID string, planConfig *PlanConfig, params InstanceParams) error {
+	previous, err := s.getQueueTemplateParams(ctx, s.getStackName(instanceID))
+	if err != nil {
+		return err
+	}
+	existing, err := StackQueueParams(stack, "")
+	if err != nil {
+		return err
+	}
+	if err := planConfig.CheckLimits(withDefaults(params.QueueParams, existing)); err != nil {
+		return err
+	}
+	for _, name := range previous.QueueNames {
+		queue, ok := params.Queues[name]
+		if ok && queue == nil {
+			// the queue is being removed
+			continue
+		}
+		if queue == nil {
+			queue = &QueueParams{}
+		}
+		existing, err := StackQueueParams(stack, NamedQueuePrefix(name))
+		if err != nil {
+			return err
+		}
+		if err := planConfig.CheckLimits(withDefaults(*queue, existing)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasBindings tells whether an instance has any binding stacks that are
+// not being deleted.
+func (s *Provider) hasBindings(ctx context.Context, instanceID string) (bool, error) {
+	stacks, err := s.listStacks(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, stack := range stacks {
+		switch aws.StringValue(stack.StackStatus) {
+		case cloudformation.StackStatusDeleteInProgress, cloudformation.StackStatusDeleteComplete:
+			continue
+		}
+		if stackKind(stack) != StackKindBinding {
+			continue
+		}
+		bindingInstanceID, err := s.bindingInstanceID(ctx, stack)
+		if err != nil {
+			return false, err
+		}
+		if bindingInstanceID == instanceID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// updateQueueStack updates the instance's stack with params, using a
+// newly built template.  The stack's context tags are kept, apart from
+// those replaced by contextTags.
+func (s *Provider) updateQueueStack(ctx context.Context, stack *cloudformation.Stack, instanceID, serviceID string, planConfig *PlanConfig, params InstanceParams, contextTags map[string]string) error {
+	// the template is always rebuilt so that instances pick up changes
+	// to the broker's template, keeping whatever the user did not change
+	stackName := s.getStackName(instanceID)
+	previous, err := s.getQueueTemplateParams(ctx, stackName)
+	if err != nil {
+		return err
+	}
+	templateParams := params.TemplateParams(previous.QueueNames)
+	queues, err := s.namedQueueParams(params.Queues, previous.QueueNames, planConfig, s.maxQueueNameLength(instanceID, isFIFOStack(stack, planConfig)))
+	if err != nil {
+		return err
+	}
+
+	stackParams := params.UpdateParams()
+	for _, name := range sortedKeys(queues) {
+		queue := queues[name]
+		stackParams = append(stackParams, queue.NamedQueueUpdateParams(name)...)
+	}
+
+	contextTags = withTemplateTags(s.ContextTagKeys.stackTags(stack), contextTags)
+	queueTemplate := s.queueTemplateBuilder(instanceID, serviceID, planConfig)
+	queueTemplate.Tags = withTemplateTags(queueTemplate.Tags, contextTags)
+	queueTemplate.FIFOQueue = isFIFOStack(stack, planConfig)
+	queueTemplate.QueueTemplateParams = previous.Merge(templateParams)
+	tmpl, err := queueTemplate.Build()
+	if err != nil {
+		return err
+	}
+	// parameters of removed queues must not be passed to the new
+	// template, and parameters the stack predates must not reuse a
+	// previous value
+	stackParams, err = withDeclaredParameters(withPreviousValues(stackParams, stack), tmpl)
+	if err != nil {
+		return err
+	}
+
+	input := &cloudformation.UpdateStackInput{
+		Capabilities: capabilities,
+		StackName:    aws.String(stackName),
+		TemplateBody: aws.String(tmpl),
+		Parameters:   withoutUnknownPreviousValues(stackParams, stack),
+		Tags:         withContextTags(queueStackTags(stack.Tags, planConfig), contextTags),
+	}
+	_, err = s.Client.UpdateStackWithContext(ctx, input)
+	return err
+}
+
+// queueTemplateBuilder returns a QueueTemplateBuilder for an instance
+// of the given plan.
+func (s *Provider) queueTemplateBuilder(instanceID, serviceID string, planConfig *PlanConfig) QueueTemplateBuilder {
+	return QueueTemplateBuilder{
+		QueueName: s.getStackName(instanceID),
+		FIFOQueue: planConfig.IsFIFO(),
+		Tags: map[string]string{
+			TagName:           instanceID,
+			TagService:        "sqs",
+			TagServiceId:      serviceID,
+			TagEnvironment:    s.Environment,
+			TagCostAllocation: instanceID,
+		},
+		RequireSecureTransport: s.RequireSecureTransport,
+	}
+}
+
+// userTemplateBuilder returns a UserTemplateBuilder for a binding to
+// the instance whose queues are in queueStack.
+func (s *Provider) userTemplateBuilder(ctx context.Context, queueStack *cloudformation.Stack, instanceID, serviceID, bindingID string) (UserTemplateBuilder, error) {
+	queueTemplateParams, err := s.getQueueTemplateParams(ctx, s.getStackName(instanceID))
+	if err != nil {
+		return UserTemplateBuilder{}, err
+	}
+	return UserTemplateBuilder{
+		BindingID:            bindingID,
+		ResourcePrefix:       s.ResourcePrefix,
+		AdditionalUserPolicy: s.AdditionalUserPolicy,
+		PermissionsBoundary:  s.PermissionsBoundary,
+		Tags: map[string]string{
+			TagName:           bindingID,
+			TagService:        "sqs",
+			TagServiceId:      serviceID,
+			TagEnvironment:    s.Environment,
+			TagCostAllocation: instanceID,
+		},
+		PrimaryQueueARN:   getStackOutput(queueStack, OutputPrimaryQueueARN),
+		PrimaryQueueURL:   getStackOutput(queueStack, OutputPrimaryQueueURL),
+		SecondaryQueueARN: getStackOutput(queueStack, OutputSecondaryQueueARN),
+		SecondaryQueueURL: getStackOutput(queueStack, OutputSecondaryQueueURL),
+		KmsKeyARN:         getStackOutput(queueStack, OutputKmsKeyARN),
+		NamedQueues:       namedQueueOutputs(queueStack, queueTemplateParams.QueueNames),
+		TrustedPrincipal:  s.RoleTrustedPrincipal,
+		SQSEndpoint:       s.SQSEndpoint,
+		AccessPolicies:    s.AccessPolicies,
+		SecretKMSKey:      s.SecretKMSKey,
+	}, nil
+}
+
+// planConfig returns the configuration of a plan, or that of a plan
+// with no metadata if the plan is not known.
+func (s *Provider) planConfig(planID string) *PlanConfig {
+	if planConfig, ok := s.PlanConfigs[planID]; ok {
+		return planConfig
+	}
+	return &PlanConfig{PlanID: planID, QueueType: QueueTypeStandard, BindingMode: BindingModeUser}
+}
+
+// namedQueueOutputs returns the URLs and ARNs of the named queues in
+// an instance's stack.
+func namedQueueOutputs(queueStack *cloudformation.Stack, names []string) map[string]NamedQueue {
+	namedQueues := map[string]NamedQueue{}
+	for _, name := range names {
+		prefix := NamedQueuePrefix(name)
+		queue := NamedQueue{
+			PrimaryQueueURL:   getStackOutput(queueStack, prefix+OutputPrimaryQueueURL),
+			PrimaryQueueARN:   getStackOutput(queueStack, prefix+OutputPrimaryQueueARN),
+			SecondaryQueueURL: getStackOutput(queueStack, prefix+OutputSecondaryQueueURL),
+			SecondaryQueueARN: getStackOutput(queueStack, prefix+OutputSecondaryQueueARN),
+		}
+		queue.PrimaryQueueName = queueNameFromARN(queue.PrimaryQueueARN)
+		queue.SecondaryQueueName = queueNameFromARN(queue.SecondaryQueueARN)
+		namedQueues[name] = queue
+	}
+	return namedQueues
+}
+
+// getQueueTemplateParams returns the QueueTemplateParams that the
+// instance's current template was built with.
+func (s *Provider) getQueueTemplateParams(ctx context.Context, stackName string) (QueueTemplateParams, error) {
+	body, err := s.getTemplateBody(ctx, stackName)
+	if err != nil {
+		return QueueTemplateParams{}, err
+	}
+	return ParseQueueTemplateParams(body)
+}
+
+// getTemplateBody returns the template the stack was last created or
+// updated with.
+func (s *Provider) getTemplateBody(ctx context.Context, stackName string) (string, error) {
+	output, err := s.Client.GetTemplateWithContext(ctx, &cloudformation.GetTemplateInput{
+		StackName:     aws.String(stackName),
+		TemplateStage: aws.String(cloudformation.TemplateStageOriginal),
+	})
+	if err != nil {
+		return "", err
+	}
+	if output == nil || output.TemplateBody == nil {
+		return "", fmt.Errorf("getTemplateOutput contained no TemplateBody, potential issue with AWS Client")
+	}
+	return *output.TemplateBody, nil
+}
+
+var queueNamePattern = regexp.MustCompile(`^[a-z][a-z0-9]{0,15}$`)
+
+// MaxSQSQueueNameLength is the longest name SQS allows for a queue.
+const MaxSQSQueueNameLength = 80
+
+// maxQueueNameLength returns the longest name a new named queue of the
+// instance can have, as its queues are named after the stack, which
+// depends on the resource prefix, and FIFO queues end in .fifo.
+func (s *Provider) maxQueueNameLength(instanceID string, fifo bool) int {
+	set := QueueSet{QueueName: s.getStackName(instanceID) + "-", FIFOQueue: fifo}
+	return MaxSQSQueueNameLength - len(set.PrimaryQueueName())
+}
+
+// namedQueueParams validates the parameters of named queues, and
+// fills in the plan's defaults for queues that do not exist yet.
+// Queues set to null are left out.  previous holds the names of the
+// instance's existing named queues, and maxNameLength is the longest
+// name a new queue can have.
+func (s *Provider) namedQueueParams(queues map[string]*QueueParams, previous []string, planConfig *PlanConfig, maxNameLength int) (map[string]QueueParams, error) {
+	names := []string{}
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	count := len(previous)
+	validated := map[string]QueueParams{}
+	for _, name := range names {
+		if !queueNamePattern.MatchString(name) {
+			return nil, apiresponses.NewFailureResponse(
+				fmt.Errorf("queue name %#v must be 1-16 lowercase letters and digits, starting with a letter", name),
+				http.StatusBadRequest,
+				"invalid-queue-name",
+			)
+		}
+		existing := contains(previous, name)
+		if queues[name] == nil {
+			if existing {
+				count--
+			}
+			continue
+		}
+		if !existing {
+			if len(name) > maxNameLength {
+				return nil, apiresponses.NewFailureResponse(
+					fmt.Errorf("queue name %#v must be at most %d characters for this instance, as SQS queue names are limited to %d characters", name, maxNameLength, MaxSQSQueueNameLength),
+					http.StatusBadRequest,
+					"invalid-queue-name",
+				)
+			}
+			count++
+		}
+		params := *queues[name]
+		if params.HasSharedParams() {
+			return nil, apiresponses.NewFailureResponse(
+				fmt.Errorf("kms_data_key_reuse_period_seconds, kms_master_key_id and sqs_managed_sse_enabled apply to every queue and can only be set for the instance"),
+				http.StatusBadRequest,
+				"instance-wide-parameter",
+			)
+		}
+		if !existing {
+			var err error
+			params, err = planConfig.NamedQueueParams(params)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := s.validateQueueParams(params, planConfig); err != nil {
+			return nil, err
+		}
+		validated[name] = params
+	}
+	if count > MaxNamedQueues {
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("an instance can have at most %d named queues", MaxNamedQueues),
+			http.StatusBadRequest,
+			"too-many-queues",
+		)
+	}
+	return validated, nil
+}
+
+// validateTemplateParams checks user supplied template parameters.
+func (s *Provider) validateTemplateParams(params QueueTemplateParams) error {
+	if err := s.validateSubscriptions(params.SNSSubscriptions); err != nil {
+		return err
+	}
+	return validateQueuePolicy(params.QueuePolicy)
+}
+
+// validateSubscriptions checks that users only subscribe queues to
+// SNS topics the operator has allowed, and only once to each.
+func (s *Provider) validateSubscriptions(subscriptions []SNSSubscription) error {
+	seen := map[string]bool{}
+	for _, sub := range subscriptions {
+		if !snsTopicARNPattern.MatchString(sub.TopicARN) {
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("%#v is not an SNS topic ARN", sub.TopicARN),
+				http.StatusBadRequest,
+				"invalid-sns-topic",
+			)
+		}
+		if seen[sub.TopicARN] {
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("SNS topic %#v is subscribed to more than once", sub.TopicARN),
+				http.StatusBadRequest,
+				"invalid-sns-topic",
+			)
+		}
+		seen[sub.TopicARN] = true
+		account := strings.Split(sub.TopicARN, ":")[4]
+		if !contains(s.AllowedSNSAccountIDs, account) && !hasAnyPrefix(sub.TopicARN, s.AllowedSNSTopicARNs) {
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("SNS topic %#v is not allowed", sub.TopicARN),
+				http.StatusBadRequest,
+				"sns-topic-not-allowed",
+			)
+		}
+	}
+	return nil
+}
+
+var (
+	accountIDPattern        = regexp.MustCompile(`^[0-9]{12}$`)
+	servicePrincipalPattern = regexp.MustCompile(`^[a-z0-9.-]+\.amazonaws\.com$`)
+	snsTopicARNPattern      = regexp.MustCompile(`^arn:aws[a-z-]*:sns:[a-z0-9-]+:[0-9]{12}:[A-Za-z0-9_-]{1,256}(\.fifo)?$`)
+	sourceARNPattern        = regexp.MustCompile(`^arn:aws[a-z-]*:[a-z0-9-]+:[a-z0-9-]*:[0-9]{0,12}:[A-Za-z0-9_+=,.@/:*-]+$`)
+)
+
+// validateQueuePolicy checks a user supplied queue policy.  Services
+// must be restricted to particular sources so that they cannot be used
+// to send messages on behalf of someone else.
+func validateQueuePolicy(policy *QueuePolicy) error {
+	if policy == nil {
+		return nil
+	}
+	invalid := func(format string, a ...interface{}) error {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("invalid queue_policy: "+format, a...),
+			http.StatusBadRequest,
+			"invalid-queue-policy",
+		)
+	}
+	for _, service := range policy.ServicePrincipals {
+		if !servicePrincipalPattern.MatchString(service) {
+			return invalid("%#v is not an AWS service principal", service)
+		}
+	}
+	for _, arn := range policy.SourceARNs {
+		if !sourceARNPattern.MatchString(arn) {
+			return invalid("%#v is not an ARN", arn)
+		}
+	}
+	for _, account := range append(append([]string{}, policy.SourceAccounts...), policy.AccountIDs...) {
+		if !accountIDPattern.MatchString(account) {
+			return invalid("%#v is not an AWS account ID", account)
+		}
+	}
+	hasSources := len(policy.SourceARNs) > 0 || len(policy.SourceAccounts) > 0
+	if len(policy.ServicePrincipals) > 0 && !hasSources {
+		return invalid("service_principals require source_arns or source_accounts")
+	}
+	if len(policy.ServicePrincipals) == 0 && hasSources {
+		return invalid("source_arns and source_accounts only apply to service_principals")
+	}
+	return nil
+}
+
+// validateInstanceParameters checks the raw instance parameters
+// against the schema published in the catalog for the plan.
+func validateInstanceParameters(planConfig *PlanConfig, raw json.RawMessage, update bool) error {
+	schema, err := InstanceSchema(planConfig, update)
+	if err != nil {
+		return err
+	}
+	return ValidateParameters(schema, raw)
+}
+
+// validateQueueParams checks user supplied queue parameters against
+// the restrictions configured by the operator and the plan.
+func (s *Provider) validateQueueParams(params QueueParams, planConfig *PlanConfig) error {
+	if err := planConfig.CheckLimits(params); err != nil {
+		return err
+	}
+	if !planConfig.IsFIFO() && params.HasFIFOParams() {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("content_based_deduplication, deduplication_scope and fifo_throughput_limit can only be used with FIFO queues"),
+			http.StatusBadRequest,
+			"fifo-only-parameter",
+		)
+	}
+	if params.DeduplicationScope != nil && !contains([]string{DeduplicationScopeQueue, DeduplicationScopeMessageGroup}, *params.DeduplicationScope) {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("unknown deduplication scope %#v", *params.DeduplicationScope),
+			http.StatusBadRequest,
+			"invalid-parameter",
+		)
+	}
+	if params.FifoThroughputLimit != nil && !contains([]string{FifoThroughputLimitPerQueue, FifoThroughputLimitPerMessageGroupId}, *params.FifoThroughputLimit) {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("unknown fifo throughput limit %#v", *params.FifoThroughputLimit),
+			http.StatusBadRequest,
+			"invalid-parameter",
+		)
+	}
+	if params.DeadLetterQueue != nil && !*params.DeadLetterQueue && params.HasDeadLetterParams() {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("dead_letter_message_retention_period, dead_letter_visibility_timeout and redrive_max_receive_count cannot be used without a dead-letter queue"),
+			http.StatusBadRequest,
+			"dead-letter-queue-disabled",
+		)
+	}
+	if v := params.DeadLetterMessageRetentionPeriod; v != nil && (*v < 60 || *v > 1209600) {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("dead_letter_message_retention_period must be between 60 and 1209600"),
+			http.StatusBadRequest,
+			"invalid-parameter",
+		)
+	}
+	if v := params.DeadLetterVisibilityTimeout; v != nil && (*v < 0 || *v > 43200) {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("dead_letter_visibility_timeout must be between 0 and 43200"),
+			http.StatusBadRequest,
+			"invalid-parameter",
+		)
+	}
+	if params.KmsMasterKeyId != nil && *params.KmsMasterKeyId != "" {
+		if !contains(s.AllowedKMSKeys, *params.KmsMasterKeyId) {
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("kms key %#v is not allowed", *params.KmsMasterKeyId),
+				http.StatusBadRequest,
+				"kms-key-not-allowed",
+			)
+		}
+		if params.SqsManagedSseEnabled != nil && *params.SqsManagedSseEnabled {
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("kms_master_key_id and sqs_managed_sse_enabled cannot be used together"),
+				http.StatusBadRequest,
+				"conflicting-encryption-options",
+			)
+		}
+	}
+	return nil
+}
+
 func (s *Provider) LastOperation(ctx context.Context, lastOperationData provideriface.LastOperationData) (*domain.LastOperation, error) {
 	stackName := s.getStackName(lastOperationData.InstanceID)
 	stack, err := s.getStack(ctx, stackName)
@@ -350,11 +1095,10 @@ func (s *Provider) LastOperation(ctx context.Context, lastOperationData provider
 
 	switch *stack.StackStatus {
 	case cloudformation.StackStatusDeleteFailed, cloudformation.StackStatusCreateFailed, cloudformation.StackStatusRollbackFailed, cloudformation.StackStatusUpdateRollbackFailed, cloudformation.StackStatusRollbackComplete, cloudformation.StackStatusUpdateRollbackComplete:
-		return &domain.LastOperation{
-			State:       domain.Failed,
-			Description: fmt.Sprintf("failed: %s", *stack.StackStatus),
-		}, nil
+		observeStackSettled(stack, StackKindQueue, domain.Failed)
+		return s.failedOperation(ctx, stack), nil
 	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete, cloudformation.StackStatusDeleteComplete:
+		observeStackSettled(stack, StackKindQueue, domain.Succeeded)
 		return &domain.LastOperation{
 			State:       domain.Succeeded,
 			Description: "done",
@@ -376,10 +1120,7 @@ func (s *Provider) lastBindingOperation(ctx context.Context, stackName string, o
 	stack, err := s.getStack(ctx, stackName)
 	if err == ErrStackNotFound {
 		if opData == UnbindOperation {
-			return &domain.LastOperation{
-				State:       domain.Succeeded,
-				Description: "done",
-			}, nil
+			return s.unbindComplete(ctx, stackName)
 		}
 		return &domain.LastOperation{
 			State:       domain.Failed,
@@ -392,11 +1133,13 @@ func (s *Provider) lastBindingOperation(ctx context.Context, stackName string, o
 
 	switch *stack.StackStatus {
 	case cloudformation.StackStatusDeleteFailed, cloudformation.StackStatusCreateFailed, cloudformation.StackStatusRollbackFailed, cloudformation.StackStatusUpdateRollbackFailed, cloudformation.StackStatusRollbackComplete, cloudformation.StackStatusUpdateRollbackComplete:
-		return &domain.LastOperation{
-			State:       domain.Failed,
-			Description: fmt.Sprintf("failed: %s", *stack.StackStatus),
-		}, nil
+		observeStackSettled(stack, StackKindBinding, domain.Failed)
+		return s.failedOperation(ctx, stack), nil
 	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete, cloudformation.StackStatusDeleteComplete:
+		observeStackSettled(stack, StackKindBinding, domain.Succeeded)
+		if opData == UnbindOperation && *stack.StackStatus == cloudformation.StackStatusDeleteComplete {
+			return s.unbindComplete(ctx, stackName)
+		}
 		return &domain.LastOperation{
 			State:       domain.Succeeded,
 			Description: "ready",
@@ -409,9 +1152,36 @@ func (s *Provider) lastBindingOperation(ctx context.Context, stackName string, o
 	}
 }
 
+// unbindComplete deletes the secret of a binding whose stack has been
+// deleted.  Stacks retain their secret, which is only deleted once the
+// stack has gone so that a binding is never left without its secret.
+// Failing to delete it fails the poll, so that it is tried again.
+func (s *Provider) unbindComplete(ctx context.Context, stackName string) (*domain.LastOperation, error) {
+	bindingID := strings.TrimPrefix(stackName, s.ResourcePrefix+"-")
+	if err := s.deleteBindingSecret(ctx, bindingID); err != nil {
+		return nil, err
+	}
+	return &domain.LastOperation{
+		State:       domain.Succeeded,
+		Description: "done",
+	}, nil
+}
+
 func (s *Provider) GetBinding(ctx context.Context, getBindingData provideriface.GetBindData) (*domain.GetBindingSpec, error) {
 	userStackName := s.getStackName(getBindingData.BindingID)
-	return s.getBinding(ctx, userStackName)
+	binding, err := s.getBinding(ctx, userStackName)
+	if err != nil {
+		return nil, err
+	}
+	body, err := s.getTemplateBody(ctx, userStackName)
+	if err != nil {
+		return nil, err
+	}
+	binding.Parameters, err = ParseUserTemplateParams(body)
+	if err != nil {
+		return nil, err
+	}
+	return binding, nil
 }
 
 func (s *Provider) getBinding(ctx context.Context, userStackName string) (*domain.GetBindingSpec, error) {
@@ -469,6 +1239,33 @@ func (s *Provider) getStack(ctx context.Context, stackName string) (*cloudformat
 	return state, nil
 }
 
+// listStacks returns all of the broker's stacks.
+func (s *Provider) listStacks(ctx context.Context) ([]*cloudformation.Stack, error) {
+	prefix := s.ResourcePrefix + "-"
+	stacks := []*cloudformation.Stack{}
+	var nextToken *string
+	for {
+		output, err := s.Client.DescribeStacksWithContext(ctx, &cloudformation.DescribeStacksInput{
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if output == nil {
+			return nil, fmt.Errorf("describeOutput was nil, potential issue with AWS Client")
+		}
+		for _, stack := range output.Stacks {
+			if strings.HasPrefix(aws.StringValue(stack.StackName), prefix) {
+				stacks = append(stacks, stack)
+			}
+		}
+		if output.NextToken == nil {
+			return stacks, nil
+		}
+		nextToken = output.NextToken
+	}
+}
+
 // tryDestroyStack removes the cloudformation stack by name. It does not use
 // the request's context to avoid the siutation where a timeout has been
 // reached and the stack needs to be cleaned up.
@@ -483,9 +1280,45 @@ func (s *Provider) tryDestroyStack(stackName string) {
 	})
 	if err != nil {
 		s.Logger.Error("try-destroy-stack", err)
+		return
+	}
+	// the binding never existed, so its secret can go while the stack
+	// is being deleted
+	bindingID := strings.TrimPrefix(stackName, s.ResourcePrefix+"-")
+	if err := s.deleteBindingSecret(deleteCtx, bindingID); err != nil {
+		s.Logger.Error("try-destroy-binding-secret", err)
 	}
 }
 
+// deleteBindingSecret deletes the secret holding a binding's
+// credentials, using the configured recovery window.  Binding stacks
+// retain their secret so that the broker, rather than CloudFormation,
+// decides how it is deleted.  A secret that does not exist or is
+// already being deleted is not an error.
+func (s *Provider) deleteBindingSecret(ctx context.Context, bindingID string) error {
+	input := &secretsmanager.DeleteSecretInput{
+		SecretId: aws.String(s.getStackName(bindingID)),
+	}
+	if s.SecretForceDelete {
+		input.ForceDeleteWithoutRecovery = aws.Bool(true)
+	} else if s.SecretRecoveryWindow > 0 {
+		input.RecoveryWindowInDays = aws.Int64(s.SecretRecoveryWindow)
+	}
+	_, err := s.Client.DeleteSecretWithContext(ctx, input)
+	if err == nil || IsNotFoundError(err) || isSecretScheduledForDeletion(err) {
+		return nil
+	}
+	return err
+}
+
+func isSecretScheduledForDeletion(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == secretsmanager.ErrCodeInvalidRequestException &&
+			strings.Contains(awsErr.Message(), "marked for deletion")
+	}
+	return false
+}
+
 func (s *Provider) getStackName(instanceID string) string {
 	return fmt.Sprintf("%s-%s", s.ResourcePrefix, instanceID)
 }
@@ -501,6 +1334,174 @@ func IsNotFoundError(err error) bool {
 	return false
 }
 
+// withoutUnknownPreviousValues drops any UsePreviousValue parameters
+// that the stack does not already have. Stacks created by older
+// versions of the broker may not know about newer parameters and
+// cloudformation rejects attempts to reuse a value that was never set.
+func withoutUnknownPreviousValues(params []*cloudformation.Parameter, stack *cloudformation.Stack) []*cloudformation.Parameter {
+	known := map[string]bool{}
+	for _, p := range stack.Parameters {
+		if p.ParameterKey != nil {
+			known[*p.ParameterKey] = true
+		}
+	}
+	filtered := []*cloudformation.Parameter{}
+	for _, p := range params {
+		if aws.BoolValue(p.UsePreviousValue) && !known[aws.StringValue(p.ParameterKey)] {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	return filtered
+}
+
+// queueStackTags returns the tags for an instance's stack, recording
+// the template version and, when it is known, the plan.
+func queueStackTags(tags []*cloudformation.Tag, planConfig *PlanConfig) []*cloudformation.Tag {
+	tags = withTemplateVersion(tags, QueueTemplateVersion)
+	if planConfig.PlanID != "" {
+		tags = withStackTag(tags, TagPlanId, planConfig.PlanID)
+	}
+	return tags
+}
+
+// bindingStackTags returns the tags for a binding's stack, recording
+// the template version and the instance it belongs to.
+func bindingStackTags(tags []*cloudformation.Tag, instanceID string) []*cloudformation.Tag {
+	tags = withTemplateVersion(tags, UserTemplateVersion)
+	return withStackTag(tags, TagInstanceId, instanceID)
+}
+
+// IsNoUpdatesError reports whether err is CloudFormation refusing to
+// update a stack because nothing would change.
+func IsNoUpdatesError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == "ValidationError" && strings.Contains(awsErr.Message(), "No updates are to be performed")
+	}
+	return false
+}
+
+var stackStatePattern = regexp.MustCompile(`is in ([A-Z_]+) state and can not be updated`)
+
+// isStackInProgressError tells whether an update was rejected because
+// another operation is in progress on the stack.
+func isStackInProgressError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok || awsErr.Code() != "ValidationError" {
+		return false
+	}
+	match := stackStatePattern.FindStringSubmatch(awsErr.Message())
+	return match != nil && strings.HasSuffix(match[1], "_IN_PROGRESS")
+}
+
+// updateStackError translates the ValidationErrors CloudFormation
+// returns when a stack cannot be updated into OSBAPI failure
+// responses.  Other errors are returned unchanged.
+func updateStackError(err error) error {
+	awsErr, ok := err.(awserr.Error)
+	if !ok || awsErr.Code() != "ValidationError" {
+		return err
+	}
+	if match := stackStatePattern.FindStringSubmatch(awsErr.Message()); match != nil {
+		if strings.HasSuffix(match[1], "_IN_PROGRESS") {
+			return apiresponses.NewFailureResponseBuilder(
+				fmt.Errorf("another operation is in progress on this instance, try again once it has finished"),
+				http.StatusUnprocessableEntity,
+				"concurrency-error",
+			).WithErrorKey("ConcurrencyError").Build()
+		}
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("the instance cannot be updated while its stack is in state %s, contact support", match[1]),
+			http.StatusUnprocessableEntity,
+			"stack-not-updatable",
+		)
+	}
+	if strings.HasPrefix(awsErr.Message(), "Parameter ") {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("invalid parameter: %s", awsErr.Message()),
+			http.StatusBadRequest,
+			"invalid-parameter",
+		)
+	}
+	return err
+}
+
+// isFIFOStack reports whether the instance's queues are FIFO queues.
+// This is read from the existing queue rather than the plan so that a
+// rebuilt template can never change the type of a queue.
+func isFIFOStack(stack *cloudformation.Stack, planConfig *PlanConfig) bool {
+	url := getStackOutput(stack, OutputPrimaryQueueURL)
+	if url == "" {
+		return planConfig.IsFIFO()
+	}
+	return strings.HasSuffix(url, ExtFIFO)
+}
+
+func sortedKeys(m map[string]QueueParams) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withPreviousValues adds a UsePreviousValue parameter for each of
+// the stack's parameters that are not in params, so that updates only
+// change what the user asked for.
+func withPreviousValues(params []*cloudformation.Parameter, stack *cloudformation.Stack) []*cloudformation.Parameter {
+	set := map[string]bool{}
+	for _, p := range params {
+		set[aws.StringValue(p.ParameterKey)] = true
+	}
+	for _, p := range stack.Parameters {
+		if p.ParameterKey == nil || set[*p.ParameterKey] {
+			continue
+		}
+		params = append(params, &cloudformation.Parameter{
+			ParameterKey:     p.ParameterKey,
+			UsePreviousValue: aws.Bool(true),
+		})
+	}
+	return params
+}
+
+// withDeclaredParameters drops any parameters that the template does
+// not declare, which cloudformation would reject.
+func withDeclaredParameters(params []*cloudformation.Parameter, templateBody string) ([]*cloudformation.Parameter, error) {
+	var tmpl struct {
+		Parameters map[string]interface{} `yaml:"Parameters"`
+	}
+	if err := yaml.Unmarshal([]byte(templateBody), &tmpl); err != nil {
+		return nil, err
+	}
+	filtered := []*cloudformation.Parameter{}
+	for _, p := range params {
+		if _, ok := tmpl.Parameters[aws.StringValue(p.ParameterKey)]; ok {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
 func getStackOutput(stack *cloudformation.Stack, key string) string {
 	for _, item := range stack.Outputs {
 		if item.OutputKey == nil || item.OutputValue == nil {
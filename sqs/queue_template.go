@@ -2,30 +2,54 @@ package sqs
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"text/template"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"gopkg.in/yaml.v2"
 )
 
 const (
-	ParamDelaySeconds                  = "DelaySeconds"
-	ParamMaximumMessageSize            = "MaximumMessageSize"
-	ParamMessageRetentionPeriod        = "MessageRetentionPeriod"
-	ParamReceiveMessageWaitTimeSeconds = "ReceiveMessageWaitTimeSeconds"
-	ParamRedriveMaxReceiveCount        = "RedriveMaxReceiveCount"
-	ParamVisibilityTimeout             = "VisibilityTimeout"
+	ParamContentBasedDeduplication        = "ContentBasedDeduplication"
+	ParamDeadLetterMessageRetentionPeriod = "DeadLetterMessageRetentionPeriod"
+	ParamDeadLetterQueue                  = "DeadLetterQueue"
+	ParamDeadLetterVisibilityTimeout      = "DeadLetterVisibilityTimeout"
+	ParamDeduplicationScope               = "DeduplicationScope"
+	ParamDelaySeconds                     = "DelaySeconds"
+	ParamFifoThroughputLimit              = "FifoThroughputLimit"
+	ParamKmsDataKeyReusePeriodSeconds     = "KmsDataKeyReusePeriodSeconds"
+	ParamKmsMasterKeyId                   = "KmsMasterKeyId"
+	ParamMaximumMessageSize               = "MaximumMessageSize"
+	ParamMessageRetentionPeriod           = "MessageRetentionPeriod"
+	ParamReceiveMessageWaitTimeSeconds    = "ReceiveMessageWaitTimeSeconds"
+	ParamRedriveMaxReceiveCount           = "RedriveMaxReceiveCount"
+	ParamSqsManagedSseEnabled             = "SqsManagedSseEnabled"
+	ParamVisibilityTimeout                = "VisibilityTimeout"
 )
 
 const (
-	ConditionShouldNotUseDLQ = "ShouldNotUseDLQ"
+	ConditionShouldCreateDLQ                           = "ShouldCreateDLQ"
+	ConditionShouldNotUseDLQ                           = "ShouldNotUseDLQ"
+	ConditionShouldSetDeadLetterMessageRetentionPeriod = "ShouldSetDeadLetterMessageRetentionPeriod"
+	ConditionShouldSetDeadLetterVisibilityTimeout      = "ShouldSetDeadLetterVisibilityTimeout"
+	ConditionShouldUseContentBasedDeduplication        = "ShouldUseContentBasedDeduplication"
+	ConditionShouldUseKMS                              = "ShouldUseKMS"
+	ConditionShouldSetSqsManagedSse                    = "ShouldSetSqsManagedSse"
 )
 
 const (
-	ResourcePrimaryQueue   = "PrimaryQueue"
-	ResourceSecondaryQueue = "SecondaryQueue"
+	ResourcePrimaryQueue         = "PrimaryQueue"
+	ResourcePrimaryQueuePolicy   = "PrimaryQueuePolicy"
+	ResourceSecondaryQueue       = "SecondaryQueue"
+	ResourceSecondaryQueuePolicy = "SecondaryQueuePolicy"
+	ResourceSubscriptionPrefix   = "Subscription"
 )
 
 const (
@@ -33,6 +57,7 @@ const (
 	OutputPrimaryQueueARN   = "PrimaryQueueARN"
 	OutputSecondaryQueueURL = "SecondaryQueueURL"
 	OutputSecondaryQueueARN = "SecondaryQueueARN"
+	OutputKmsKeyARN         = "KmsKeyARN"
 )
 
 const (
@@ -40,13 +65,219 @@ const (
 	ExtStandard = ""
 )
 
+// MaxNamedQueues is the most named queues an instance may have, which
+// keeps templates within the CloudFormation limit on parameters.
+const MaxNamedQueues = 10
+
+const (
+	DeduplicationScopeQueue        = "queue"
+	DeduplicationScopeMessageGroup = "messageGroup"
+)
+
+const (
+	FifoThroughputLimitPerQueue          = "perQueue"
+	FifoThroughputLimitPerMessageGroupId = "perMessageGroupId"
+)
+
 // A QueueTemplateBuilder is responsible for building the
 // CloudFormation YAML template.  You can configure it to control
 // exactly how the template is built.
 type QueueTemplateBuilder struct {
+	QueueTemplateParams
 	QueueName string
 	FIFOQueue bool
 	Tags      map[string]string
+	// RequireSecureTransport adds a statement to the queue policies
+	// denying any access that does not use TLS.
+	RequireSecureTransport bool
+}
+
+// QueueTemplateParams are user parameters that change the shape of
+// the queue template rather than being passed to it as stack
+// parameters.  They are recorded in the template's metadata so that
+// the template can be rebuilt without losing them.
+type QueueTemplateParams struct {
+	// SNSSubscriptions subscribes the primary queue to SNS topics.
+	// When updating, a nil value keeps the current subscriptions and
+	// an empty list removes them.
+	SNSSubscriptions []SNSSubscription `json:"sns_subscriptions,omitempty"`
+	// QueuePolicy allows AWS services and other accounts to send
+	// messages to the primary queue.  When updating, a nil value keeps
+	// the current policy and an empty one removes it.
+	QueuePolicy *QueuePolicy `json:"queue_policy,omitempty"`
+	// QueueNames are the names of the instance's named queues, which
+	// are created in addition to the default queue.
+	QueueNames []string `json:"queue_names,omitempty"`
+}
+
+// QueuePolicy describes who, other than bindings, may send messages
+// to the primary queue.
+type QueuePolicy struct {
+	// ServicePrincipals are AWS services, such as s3.amazonaws.com or
+	// events.amazonaws.com, that may send messages on behalf of the
+	// SourceARNs and SourceAccounts.
+	ServicePrincipals []string `json:"service_principals,omitempty"`
+	// SourceARNs restricts ServicePrincipals to sending on behalf of
+	// these resources, for example a particular S3 bucket or
+	// EventBridge rule.  Wildcards are allowed.
+	SourceARNs []string `json:"source_arns,omitempty"`
+	// SourceAccounts restricts ServicePrincipals to sending on behalf
+	// of resources in these accounts.
+	SourceAccounts []string `json:"source_accounts,omitempty"`
+	// AccountIDs are other AWS accounts whose IAM principals may send
+	// messages directly.
+	AccountIDs []string `json:"account_ids,omitempty"`
+}
+
+// IsEmpty reports whether the policy allows anything.
+func (policy *QueuePolicy) IsEmpty() bool {
+	return policy == nil || (len(policy.ServicePrincipals) == 0 && len(policy.AccountIDs) == 0)
+}
+
+// Merge returns a copy of params with any fields set in changes
+// replaced.
+func (params QueueTemplateParams) Merge(changes QueueTemplateParams) QueueTemplateParams {
+	if changes.SNSSubscriptions != nil {
+		params.SNSSubscriptions = changes.SNSSubscriptions
+	}
+	if changes.QueuePolicy != nil {
+		params.QueuePolicy = changes.QueuePolicy
+	}
+	if changes.QueueNames != nil {
+		params.QueueNames = changes.QueueNames
+	}
+	return params
+}
+
+// IsEmpty reports whether no template parameters have been set.
+func (params QueueTemplateParams) IsEmpty() bool {
+	return params.SNSSubscriptions == nil && params.QueuePolicy == nil && params.QueueNames == nil
+}
+
+// SNSSubscription subscribes the primary queue to an existing SNS
+// topic.
+type SNSSubscription struct {
+	// TopicARN is the ARN of the topic to subscribe to.  The topic
+	// must belong to an account or match a prefix the operator has
+	// allowed in the broker configuration.
+	TopicARN string `json:"topic_arn"`
+	// RawMessageDelivery delivers the message body as sent to the
+	// topic, rather than wrapped in an SNS JSON envelope.
+	RawMessageDelivery bool `json:"raw_message_delivery,omitempty"`
+	// FilterPolicy is an SNS subscription filter policy restricting
+	// which messages published to the topic are delivered.
+	FilterPolicy map[string]interface{} `json:"filter_policy,omitempty"`
+}
+
+// LogicalID returns the CloudFormation resource name for the
+// subscription.  It is derived from the topic ARN so that adding or
+// removing other subscriptions does not replace this one.
+func (sub SNSSubscription) LogicalID() string {
+	sum := sha256.Sum256([]byte(sub.TopicARN))
+	return fmt.Sprintf("%s%x", ResourceSubscriptionPrefix, sum[:8])
+}
+
+// FilterPolicyJSON returns the filter policy encoded as JSON, which
+// is also valid YAML for embedding in the template.
+func (sub SNSSubscription) FilterPolicyJSON() (string, error) {
+	data, err := json.Marshal(sub.FilterPolicy)
+	return string(data), err
+}
+
+// MetadataJSON returns the QueueTemplateParams as a quoted JSON string
+// for recording in the template metadata.
+func (params *QueueTemplateBuilder) MetadataJSON() (string, error) {
+	data, err := json.Marshal(params.QueueTemplateParams)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(string(data)), nil
+}
+
+// ParseQueueTemplateParams reads the QueueTemplateParams recorded in
+// the metadata of a queue template.  Templates that predate the
+// metadata have no parameters.
+func ParseQueueTemplateParams(templateBody string) (QueueTemplateParams, error) {
+	params := QueueTemplateParams{}
+	var t struct {
+		Metadata struct {
+			QueueTemplateParams string `yaml:"QueueTemplateParams"`
+		} `yaml:"Metadata"`
+	}
+	if err := yaml.Unmarshal([]byte(templateBody), &t); err != nil {
+		return params, err
+	}
+	if t.Metadata.QueueTemplateParams == "" {
+		return params, nil
+	}
+	err := json.Unmarshal([]byte(t.Metadata.QueueTemplateParams), &params)
+	return params, err
+}
+
+// A QueueSet is a primary queue and its dead-letter queue.  Every
+// instance has a default QueueSet, with an empty Name and Prefix, and
+// one for each of its named queues.
+type QueueSet struct {
+	// Name is the user's name for the queue, or empty for the default
+	// queue.
+	Name string
+	// Prefix is prepended to the names of the set's parameters,
+	// conditions, resources and outputs.
+	Prefix                 string
+	QueueName              string
+	FIFOQueue              bool
+	Tags                   map[string]string
+	RequireSecureTransport bool
+	// SNSSubscriptions and QueuePolicy only apply to the default
+	// queue.
+	SNSSubscriptions []SNSSubscription
+	QueuePolicy      *QueuePolicy
+}
+
+// NamedQueuePrefix returns the Prefix for the named queue's QueueSet.
+// Queue names start with a lowercase letter, see queueNamePattern.
+func NamedQueuePrefix(name string) string {
+	return "Queue" + strings.ToUpper(name[:1]) + name[1:]
+}
+
+// QueueSets returns the default QueueSet followed by one for each
+// named queue.
+func (params *QueueTemplateBuilder) QueueSets() []QueueSet {
+	sets := []QueueSet{{
+		QueueName:              params.QueueName,
+		FIFOQueue:              params.FIFOQueue,
+		Tags:                   params.Tags,
+		RequireSecureTransport: params.RequireSecureTransport,
+		SNSSubscriptions:       params.SNSSubscriptions,
+		QueuePolicy:            params.QueuePolicy,
+	}}
+	for _, name := range params.QueueNames {
+		sets = append(sets, QueueSet{
+			Name:                   name,
+			Prefix:                 NamedQueuePrefix(name),
+			QueueName:              fmt.Sprintf("%s-%s", params.QueueName, name),
+			FIFOQueue:              params.FIFOQueue,
+			Tags:                   params.Tags,
+			RequireSecureTransport: params.RequireSecureTransport,
+		})
+	}
+	return sets
+}
+
+// PrimaryQueueName builds the name for the set's primary queue
+func (set QueueSet) PrimaryQueueName() string {
+	return fmt.Sprintf("%s-pri%s", set.QueueName, ext(set.FIFOQueue))
+}
+
+// SecondaryQueueName builds the name for the set's secondary queue
+func (set QueueSet) SecondaryQueueName() string {
+	return fmt.Sprintf("%s-sec%s", set.QueueName, ext(set.FIFOQueue))
+}
+
+// HasPrimaryQueuePolicy reports whether the set's primary queue needs
+// a queue policy.
+func (set QueueSet) HasPrimaryQueuePolicy() bool {
+	return set.RequireSecureTransport || len(set.SNSSubscriptions) > 0 || !set.QueuePolicy.IsEmpty()
 }
 
 // PrimaryQueueName builds the name for the primary queue
@@ -62,7 +293,11 @@ func (params *QueueTemplateBuilder) SecondaryQueueName() string {
 // ext returns the suffix for the queue names. this is important because
 // FIFO queues require a sepecific suffix
 func (params *QueueTemplateBuilder) ext() string {
-	if params.FIFOQueue {
+	return ext(params.FIFOQueue)
+}
+
+func ext(fifo bool) string {
+	if fifo {
 		return ExtFIFO
 	} else {
 		return ExtStandard
@@ -72,7 +307,11 @@ func (params *QueueTemplateBuilder) ext() string {
 // Build returns a cloudformation Template for provisioning an SQS
 // queue
 func (params *QueueTemplateBuilder) Build() (string, error) {
-	t, err := template.New("queue-template").Parse(queueTemplateFormat)
+	t, err := template.New("queue-template").Funcs(templateFuncs).Parse(queueTemplateFormat)
+	if err != nil {
+		return "", err
+	}
+	_, err = t.New("queue-set").Parse(queueSetTemplateFormat)
 	if err != nil {
 		return "", err
 	}
@@ -92,10 +331,45 @@ func (params *QueueTemplateBuilder) Build() (string, error) {
 // then it should be in QueueParams so that CloudFormation
 // can keep track of its value across updates.
 type QueueParams struct {
+	// ContentBasedDeduplication enables content-based deduplication
+	// for FIFO queues. Only valid for FIFO queues.
+	ContentBasedDeduplication *bool `json:"content_based_deduplication,omitempty"`
+	// DeadLetterMessageRetentionPeriod The number of seconds that
+	// Amazon SQS retains a message in the dead-letter queue. You can
+	// specify an integer value from 60 seconds (1 minute) to 1,209,600
+	// seconds (14 days). Defaults to MessageRetentionPeriod.
+	DeadLetterMessageRetentionPeriod *int `json:"dead_letter_message_retention_period,omitempty"`
+	// DeadLetterQueue controls whether a dead-letter queue is created
+	// alongside the primary queue. Defaults to true.
+	DeadLetterQueue *bool `json:"dead_letter_queue,omitempty"`
+	// DeadLetterVisibilityTimeout The visibility timeout of the
+	// dead-letter queue. Values must be from 0 to 43,200 seconds (12
+	// hours). Defaults to VisibilityTimeout.
+	DeadLetterVisibilityTimeout *int `json:"dead_letter_visibility_timeout,omitempty"`
+	// DeduplicationScope specifies whether message deduplication occurs
+	// at the message group or queue level. Valid values are queue and
+	// messageGroup. Only valid for FIFO queues.
+	DeduplicationScope *string `json:"deduplication_scope,omitempty"`
 	// DelaySeconds The time in seconds for which the delivery of all messages
 	// in the queue is delayed. You can specify an integer value of 0 to 900
 	// (15 minutes).
 	DelaySeconds *int `json:"delay_seconds,omitempty"`
+	// FifoThroughputLimit specifies whether the FIFO queue throughput
+	// quota applies to the entire queue or per message group. Valid
+	// values are perQueue and perMessageGroupId. Only valid for FIFO
+	// queues.
+	FifoThroughputLimit *string `json:"fifo_throughput_limit,omitempty"`
+	// KmsDataKeyReusePeriodSeconds The length of time, in seconds, for
+	// which Amazon SQS can reuse a data key to encrypt or decrypt
+	// messages before calling AWS KMS again. You can specify an
+	// integer value from 60 seconds (1 minute) to 86,400 seconds (24
+	// hours). Only used when KmsMasterKeyId is set.
+	KmsDataKeyReusePeriodSeconds *int `json:"kms_data_key_reuse_period_seconds,omitempty"`
+	// KmsMasterKeyId is the ARN of a customer managed AWS KMS key used
+	// to encrypt both queues. The key must be one the operator has
+	// allowed in the broker configuration. An empty string removes
+	// the key.
+	KmsMasterKeyId *string `json:"kms_master_key_id,omitempty"`
 	// MaximumMessageSize is the limit of how many bytes that a message can
 	// contain before Amazon SQS rejects it. You can specify an integer value
 	// from 1,024 bytes (1 KiB) to 262,144 bytes (256 KiB). The default value
@@ -121,6 +395,10 @@ type QueueParams struct {
 	// message is delivered to the source queue before
 	// being moved to the dead-letter queue.
 	RedriveMaxReceiveCount *int `json:"redrive_max_receive_count,omitempty"`
+	// SqsManagedSseEnabled enables server-side encryption using SQS
+	// owned encryption keys. It cannot be combined with
+	// KmsMasterKeyId.
+	SqsManagedSseEnabled *bool `json:"sqs_managed_sse_enabled,omitempty"`
 	// VisibilityTimeout The length of time during
 	// which a message will be unavailable after a
 	// message is delivered from the queue. This blocks
@@ -131,35 +409,155 @@ type QueueParams struct {
 	VisibilityTimeout *int `json:"visibility_timeout,omitempty"`
 }
 
+// InstanceParams are the parameters users may pass when creating or
+// updating an instance.  QueueParams become stack parameters, the
+// rest change the shape of the template itself.
+type InstanceParams struct {
+	QueueParams
+	// SNSSubscriptions subscribes the primary queue to SNS topics.
+	SNSSubscriptions []SNSSubscription `json:"sns_subscriptions,omitempty"`
+	// QueuePolicy allows AWS services and other accounts to send
+	// messages to the primary queue.
+	QueuePolicy *QueuePolicy `json:"queue_policy,omitempty"`
+	// Queues declares named queues, each with its own parameters.
+	// When updating, queues that are not mentioned are left alone and
+	// a null value removes a queue.
+	Queues map[string]*QueueParams `json:"queues,omitempty"`
+}
+
+// TemplateParams returns the QueueTemplateParams set by the user.
+// previous is the list of named queues the instance already has,
+// which Queues is applied to.
+func (params InstanceParams) TemplateParams(previous []string) QueueTemplateParams {
+	templateParams := QueueTemplateParams{
+		SNSSubscriptions: params.SNSSubscriptions,
+		QueuePolicy:      params.QueuePolicy,
+	}
+	if params.Queues != nil {
+		names := []string{}
+		for _, name := range previous {
+			if _, ok := params.Queues[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		for name, queueParams := range params.Queues {
+			if queueParams != nil {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		templateParams.QueueNames = names
+	}
+	return templateParams
+}
+
+// HasFIFOParams reports whether any of the parameters that only apply
+// to FIFO queues have been set.
+func (params *QueueParams) HasFIFOParams() bool {
+	return params.ContentBasedDeduplication != nil ||
+		params.DeduplicationScope != nil ||
+		params.FifoThroughputLimit != nil
+}
+
+// WithoutFIFOParams clears the parameters that only apply to FIFO
+// queues.
+func (params *QueueParams) WithoutFIFOParams() {
+	params.ContentBasedDeduplication = nil
+	params.DeduplicationScope = nil
+	params.FifoThroughputLimit = nil
+}
+
+// HasDeadLetterParams reports whether any of the parameters that
+// configure the dead-letter queue have been set.
+func (params *QueueParams) HasDeadLetterParams() bool {
+	return params.DeadLetterMessageRetentionPeriod != nil ||
+		params.DeadLetterVisibilityTimeout != nil ||
+		(params.RedriveMaxReceiveCount != nil && *params.RedriveMaxReceiveCount > 0)
+}
+
+// HasSharedParams reports whether any of the parameters that apply to
+// every queue in an instance have been set.  These can only be set
+// for the default queue.
+func (params *QueueParams) HasSharedParams() bool {
+	return params.KmsDataKeyReusePeriodSeconds != nil ||
+		params.KmsMasterKeyId != nil ||
+		params.SqsManagedSseEnabled != nil
+}
+
 // CreateParams returns a set of cloudformation.Parameter suitable for
 // passing to CreateStackWithContext().
 func (params *QueueParams) CreateParams() []*cloudformation.Parameter {
+	return params.createParams("")
+}
+
+// NamedQueueCreateParams returns the CreateParams for a named queue.
+// Shared parameters are ignored.
+func (params *QueueParams) NamedQueueCreateParams(name string) []*cloudformation.Parameter {
+	named := *params
+	named.KmsDataKeyReusePeriodSeconds = nil
+	named.KmsMasterKeyId = nil
+	named.SqsManagedSseEnabled = nil
+	return named.createParams(NamedQueuePrefix(name))
+}
+
+func (params *QueueParams) createParams(prefix string) []*cloudformation.Parameter {
 	stackParams := []*cloudformation.Parameter{}
+	if params.ContentBasedDeduplication != nil {
+		stackParams = append(stackParams, mkStringParameter(prefix+ParamContentBasedDeduplication, strconv.FormatBool(*params.ContentBasedDeduplication)))
+	}
+	if params.DeadLetterMessageRetentionPeriod != nil {
+		stackParams = append(stackParams, mkParameter(prefix+ParamDeadLetterMessageRetentionPeriod, *params.DeadLetterMessageRetentionPeriod))
+	}
+	if params.DeadLetterQueue != nil {
+		stackParams = append(stackParams, mkStringParameter(prefix+ParamDeadLetterQueue, strconv.FormatBool(*params.DeadLetterQueue)))
+	}
+	if params.DeadLetterVisibilityTimeout != nil {
+		stackParams = append(stackParams, mkParameter(prefix+ParamDeadLetterVisibilityTimeout, *params.DeadLetterVisibilityTimeout))
+	}
+	if params.DeduplicationScope != nil {
+		stackParams = append(stackParams, mkStringParameter(prefix+ParamDeduplicationScope, *params.DeduplicationScope))
+	}
 	if params.DelaySeconds != nil {
-		stackParams = append(stackParams, mkParameter(ParamDelaySeconds, *params.DelaySeconds))
+		stackParams = append(stackParams, mkParameter(prefix+ParamDelaySeconds, *params.DelaySeconds))
+	}
+	if params.FifoThroughputLimit != nil {
+		stackParams = append(stackParams, mkStringParameter(prefix+ParamFifoThroughputLimit, *params.FifoThroughputLimit))
+	}
+	if params.KmsDataKeyReusePeriodSeconds != nil {
+		stackParams = append(stackParams, mkParameter(prefix+ParamKmsDataKeyReusePeriodSeconds, *params.KmsDataKeyReusePeriodSeconds))
+	}
+	if params.KmsMasterKeyId != nil {
+		stackParams = append(stackParams, mkStringParameter(prefix+ParamKmsMasterKeyId, *params.KmsMasterKeyId))
 	}
 	if params.MaximumMessageSize != nil {
-		stackParams = append(stackParams, mkParameter(ParamMaximumMessageSize, *params.MaximumMessageSize))
+		stackParams = append(stackParams, mkParameter(prefix+ParamMaximumMessageSize, *params.MaximumMessageSize))
 	}
 	if params.MessageRetentionPeriod != nil {
-		stackParams = append(stackParams, mkParameter(ParamMessageRetentionPeriod, *params.MessageRetentionPeriod))
+		stackParams = append(stackParams, mkParameter(prefix+ParamMessageRetentionPeriod, *params.MessageRetentionPeriod))
 	}
 	if params.ReceiveMessageWaitTimeSeconds != nil {
-		stackParams = append(stackParams, mkParameter(ParamReceiveMessageWaitTimeSeconds, *params.ReceiveMessageWaitTimeSeconds))
+		stackParams = append(stackParams, mkParameter(prefix+ParamReceiveMessageWaitTimeSeconds, *params.ReceiveMessageWaitTimeSeconds))
 	}
 	if params.RedriveMaxReceiveCount != nil {
-		stackParams = append(stackParams, mkParameter(ParamRedriveMaxReceiveCount, *params.RedriveMaxReceiveCount))
+		stackParams = append(stackParams, mkParameter(prefix+ParamRedriveMaxReceiveCount, *params.RedriveMaxReceiveCount))
+	}
+	if params.SqsManagedSseEnabled != nil {
+		stackParams = append(stackParams, mkStringParameter(prefix+ParamSqsManagedSseEnabled, strconv.FormatBool(*params.SqsManagedSseEnabled)))
 	}
 	if params.VisibilityTimeout != nil {
-		stackParams = append(stackParams, mkParameter(ParamVisibilityTimeout, *params.VisibilityTimeout))
+		stackParams = append(stackParams, mkParameter(prefix+ParamVisibilityTimeout, *params.VisibilityTimeout))
 	}
 	return stackParams
 }
 
 func mkParameter(name string, value int) *cloudformation.Parameter {
+	return mkStringParameter(name, strconv.Itoa(value))
+}
+
+func mkStringParameter(name string, value string) *cloudformation.Parameter {
 	return &cloudformation.Parameter{
 		ParameterKey:   aws.String(name),
-		ParameterValue: aws.String(strconv.Itoa(value)),
+		ParameterValue: aws.String(value),
 	}
 }
 
@@ -168,26 +566,103 @@ func mkParameter(name string, value int) *cloudformation.Parameter {
 // is nil, UpdateParams will return a cloudformation.Parameter with
 // UsePreviousValue set to true.
 func (params *QueueParams) UpdateParams() []*cloudformation.Parameter {
-	return []*cloudformation.Parameter{
-		mkOptionalParameter(ParamDelaySeconds, params.DelaySeconds),
-		mkOptionalParameter(ParamMaximumMessageSize, params.MaximumMessageSize),
-		mkOptionalParameter(ParamMessageRetentionPeriod, params.MessageRetentionPeriod),
-		mkOptionalParameter(ParamReceiveMessageWaitTimeSeconds, params.ReceiveMessageWaitTimeSeconds),
-		mkOptionalParameter(ParamRedriveMaxReceiveCount, params.RedriveMaxReceiveCount),
-		mkOptionalParameter(ParamVisibilityTimeout, params.VisibilityTimeout),
+	return params.updateParams("", true)
+}
+
+// NamedQueueUpdateParams returns the UpdateParams for a named queue.
+// Shared parameters are ignored.
+func (params *QueueParams) NamedQueueUpdateParams(name string) []*cloudformation.Parameter {
+	return params.updateParams(NamedQueuePrefix(name), false)
+}
+
+func (params *QueueParams) updateParams(prefix string, shared bool) []*cloudformation.Parameter {
+	stackParams := []*cloudformation.Parameter{
+		mkOptionalBoolParameter(prefix+ParamContentBasedDeduplication, params.ContentBasedDeduplication),
+		mkOptionalParameter(prefix+ParamDeadLetterMessageRetentionPeriod, params.DeadLetterMessageRetentionPeriod),
+		mkOptionalBoolParameter(prefix+ParamDeadLetterQueue, params.DeadLetterQueue),
+		mkOptionalParameter(prefix+ParamDeadLetterVisibilityTimeout, params.DeadLetterVisibilityTimeout),
+		mkOptionalStringParameter(prefix+ParamDeduplicationScope, params.DeduplicationScope),
+		mkOptionalParameter(prefix+ParamDelaySeconds, params.DelaySeconds),
+		mkOptionalStringParameter(prefix+ParamFifoThroughputLimit, params.FifoThroughputLimit),
+		mkOptionalParameter(prefix+ParamMaximumMessageSize, params.MaximumMessageSize),
+		mkOptionalParameter(prefix+ParamMessageRetentionPeriod, params.MessageRetentionPeriod),
+		mkOptionalParameter(prefix+ParamReceiveMessageWaitTimeSeconds, params.ReceiveMessageWaitTimeSeconds),
+		mkOptionalParameter(prefix+ParamRedriveMaxReceiveCount, params.RedriveMaxReceiveCount),
+		mkOptionalParameter(prefix+ParamVisibilityTimeout, params.VisibilityTimeout),
+	}
+	if shared {
+		stackParams = append(stackParams,
+			mkOptionalParameter(prefix+ParamKmsDataKeyReusePeriodSeconds, params.KmsDataKeyReusePeriodSeconds),
+			mkOptionalStringParameter(prefix+ParamKmsMasterKeyId, params.KmsMasterKeyId),
+			mkOptionalBoolParameter(prefix+ParamSqsManagedSseEnabled, params.SqsManagedSseEnabled),
+		)
 	}
+	return stackParams
 }
 
 func mkOptionalParameter(name string, value *int) *cloudformation.Parameter {
 	if value == nil {
-		return &cloudformation.Parameter{
-			ParameterKey:     aws.String(name),
-			UsePreviousValue: aws.Bool(true),
+		return mkPreviousParameter(name)
+	}
+	return mkParameter(name, *value)
+}
+
+func mkOptionalStringParameter(name string, value *string) *cloudformation.Parameter {
+	if value == nil {
+		return mkPreviousParameter(name)
+	}
+	return mkStringParameter(name, *value)
+}
+
+func mkOptionalBoolParameter(name string, value *bool) *cloudformation.Parameter {
+	if value == nil {
+		return mkPreviousParameter(name)
+	}
+	return mkStringParameter(name, strconv.FormatBool(*value))
+}
+
+func mkPreviousParameter(name string) *cloudformation.Parameter {
+	return &cloudformation.Parameter{
+		ParameterKey:     aws.String(name),
+		UsePreviousValue: aws.Bool(true),
+	}
+}
+
+// StackQueueParams reads the effective parameters of a queue from the
+// parameters of its stack.  prefix is empty for the default queue and
+// NamedQueuePrefix(name) for a named queue.  Empty values, which leave
+// a setting to its default, are left out.
+func StackQueueParams(stack *cloudformation.Stack, prefix string) (QueueParams, error) {
+	values := map[string]string{}
+	for _, param := range stack.Parameters {
+		values[aws.StringValue(param.ParameterKey)] = aws.StringValue(param.ParameterValue)
+	}
+	params := QueueParams{}
+	v := reflect.ValueOf(&params).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		value, ok := values[prefix+name]
+		if !ok || value == "" {
+			continue
 		}
-	} else {
-		return &cloudformation.Parameter{
-			ParameterKey:   aws.String(name),
-			ParameterValue: aws.String(strconv.Itoa(*value)),
+		field := v.Field(i)
+		switch field.Type().Elem().Kind() {
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return params, fmt.Errorf("invalid value %#v for stack parameter %s", value, prefix+name)
+			}
+			field.Set(reflect.ValueOf(&n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return params, fmt.Errorf("invalid value %#v for stack parameter %s", value, prefix+name)
+			}
+			field.Set(reflect.ValueOf(&b))
+		case reflect.String:
+			s := value
+			field.Set(reflect.ValueOf(&s))
 		}
 	}
+	return params, nil
 }
@@ -2,11 +2,12 @@ package sqs_test
 
 import (
 	"github.com/alphagov/paas-sqs-broker/sqs"
-	goformation "github.com/awslabs/goformation/v4"
+	goformationsns "github.com/awslabs/goformation/v4/cloudformation/sns"
 	goformationsqs "github.com/awslabs/goformation/v4/cloudformation/sqs"
 	goformationtags "github.com/awslabs/goformation/v4/cloudformation/tags"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
 )
 
 var _ = Describe("QueueTemplateBuilder", func() {
@@ -21,7 +22,7 @@ var _ = Describe("QueueTemplateBuilder", func() {
 	JustBeforeEach(func() {
 		text, err := builder.Build()
 		Expect(err).ToNot(HaveOccurred())
-		t, err := goformation.ParseYAML([]byte(text))
+		t, err := parseTemplate(text)
 		Expect(err).ToNot(HaveOccurred())
 
 		Expect(t.Resources).To(ContainElement(BeAssignableToTypeOf(&goformationsqs.Queue{})))
@@ -141,11 +142,354 @@ var _ = Describe("QueueTemplateBuilder", func() {
 		})
 	})
 
+	Context("when FIFO queue is configured", func() {
+		BeforeEach(func() {
+			builder.FIFOQueue = true
+		})
+		It("should set deduplication and throughput options on the primary queue", func() {
+			text, err := builder.Build()
+			Expect(err).ToNot(HaveOccurred())
+			var t map[string]interface{}
+			Expect(yaml.Unmarshal([]byte(text), &t)).To(Succeed())
+			resources := t["Resources"].(map[interface{}]interface{})
+			resource := resources[sqs.ResourcePrimaryQueue].(map[interface{}]interface{})
+			properties := resource["Properties"].(map[interface{}]interface{})
+			Expect(properties).To(HaveKey("ContentBasedDeduplication"))
+			Expect(properties).To(HaveKeyWithValue("DeduplicationScope", sqs.ParamDeduplicationScope))
+			Expect(properties).To(HaveKeyWithValue("FifoThroughputLimit", sqs.ParamFifoThroughputLimit))
+		})
+	})
+
+	It("should not set FIFO only options on standard queues", func() {
+		text, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		var t map[string]interface{}
+		Expect(yaml.Unmarshal([]byte(text), &t)).To(Succeed())
+		resources := t["Resources"].(map[interface{}]interface{})
+		resource := resources[sqs.ResourcePrimaryQueue].(map[interface{}]interface{})
+		properties := resource["Properties"].(map[interface{}]interface{})
+		Expect(properties).ToNot(HaveKey("ContentBasedDeduplication"))
+		Expect(properties).ToNot(HaveKey("DeduplicationScope"))
+		Expect(properties).ToNot(HaveKey("FifoThroughputLimit"))
+	})
+
+	It("should use the same encryption settings for both queues", func() {
+		text, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		var t map[string]interface{}
+		Expect(yaml.Unmarshal([]byte(text), &t)).To(Succeed())
+		resources := t["Resources"].(map[interface{}]interface{})
+		for _, name := range []string{sqs.ResourcePrimaryQueue, sqs.ResourceSecondaryQueue} {
+			resource := resources[name].(map[interface{}]interface{})
+			properties := resource["Properties"].(map[interface{}]interface{})
+			Expect(properties).To(HaveKey("KmsMasterKeyId"), name)
+			Expect(properties).To(HaveKey("KmsDataKeyReusePeriodSeconds"), name)
+			Expect(properties).To(HaveKey("SqsManagedSseEnabled"), name)
+		}
+	})
+
+	It("should only output the kms key when one is used", func() {
+		text, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		var t map[string]interface{}
+		Expect(yaml.Unmarshal([]byte(text), &t)).To(Succeed())
+		outputs := t["Outputs"].(map[interface{}]interface{})
+		Expect(outputs).To(HaveKeyWithValue(sqs.OutputKmsKeyARN,
+			HaveKeyWithValue("Condition", sqs.ConditionShouldUseKMS)))
+	})
+
+	It("should only create the dead-letter queue when it is enabled", func() {
+		Expect(secondaryQueue.AWSCloudFormationCondition).To(Equal(sqs.ConditionShouldCreateDLQ))
+
+		text, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		var t map[string]interface{}
+		Expect(yaml.Unmarshal([]byte(text), &t)).To(Succeed())
+		outputs := t["Outputs"].(map[interface{}]interface{})
+		Expect(outputs).To(HaveKeyWithValue(sqs.OutputSecondaryQueueARN,
+			HaveKeyWithValue("Condition", sqs.ConditionShouldCreateDLQ)))
+		Expect(outputs).To(HaveKeyWithValue(sqs.OutputSecondaryQueueURL,
+			HaveKeyWithValue("Condition", sqs.ConditionShouldCreateDLQ)))
+	})
+
+	It("should configure dead-letter queue retention and visibility separately", func() {
+		text, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		var t map[string]interface{}
+		Expect(yaml.Unmarshal([]byte(text), &t)).To(Succeed())
+		resources := t["Resources"].(map[interface{}]interface{})
+		resource := resources[sqs.ResourceSecondaryQueue].(map[interface{}]interface{})
+		properties := resource["Properties"].(map[interface{}]interface{})
+		Expect(properties).To(HaveKeyWithValue("MessageRetentionPeriod",
+			ContainElements(sqs.ConditionShouldSetDeadLetterMessageRetentionPeriod, sqs.ParamDeadLetterMessageRetentionPeriod)))
+		Expect(properties).To(HaveKeyWithValue("VisibilityTimeout",
+			ContainElements(sqs.ConditionShouldSetDeadLetterVisibilityTimeout, sqs.ParamDeadLetterVisibilityTimeout)))
+	})
+
+	It("should not have a queue policy without subscriptions", func() {
+		text, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		t, err := parseTemplate(text)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.Resources).ToNot(HaveKey(sqs.ResourcePrimaryQueuePolicy))
+		Expect(t.Resources).ToNot(ContainElement(BeAssignableToTypeOf(&goformationsns.Subscription{})))
+	})
+
+	Context("when SNS subscriptions are configured", func() {
+		var (
+			subscriptions map[string]*goformationsns.Subscription
+			queuePolicy   *goformationsqs.QueuePolicy
+		)
+
+		BeforeEach(func() {
+			builder.SNSSubscriptions = []sqs.SNSSubscription{
+				{
+					TopicARN: "arn:aws:sns:eu-west-2:123456789012:topic-a",
+				},
+				{
+					TopicARN:           "arn:aws:sns:eu-west-2:123456789012:topic-b",
+					RawMessageDelivery: true,
+					FilterPolicy: map[string]interface{}{
+						"event": []interface{}{"created", "deleted"},
+					},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			text, err := builder.Build()
+			Expect(err).ToNot(HaveOccurred())
+			t, err := parseTemplate(text)
+			Expect(err).ToNot(HaveOccurred())
+			subscriptions = map[string]*goformationsns.Subscription{}
+			for name, resource := range t.Resources {
+				if sub, ok := resource.(*goformationsns.Subscription); ok {
+					subscriptions[name] = sub
+				}
+			}
+			var ok bool
+			queuePolicy, ok = t.Resources[sqs.ResourcePrimaryQueuePolicy].(*goformationsqs.QueuePolicy)
+			Expect(ok).To(BeTrue())
+		})
+
+		It("should subscribe the primary queue to each topic", func() {
+			Expect(subscriptions).To(HaveLen(2))
+			a := subscriptions[builder.SNSSubscriptions[0].LogicalID()]
+			Expect(a).ToNot(BeNil())
+			Expect(a.Protocol).To(Equal("sqs"))
+			Expect(a.TopicArn).To(Equal("arn:aws:sns:eu-west-2:123456789012:topic-a"))
+			Expect(a.RawMessageDelivery).To(BeFalse())
+			Expect(a.FilterPolicy).To(BeNil())
+		})
+
+		It("should set raw message delivery and filter policies", func() {
+			b := subscriptions[builder.SNSSubscriptions[1].LogicalID()]
+			Expect(b).ToNot(BeNil())
+			Expect(b.RawMessageDelivery).To(BeTrue())
+			Expect(b.FilterPolicy).To(Equal(map[string]interface{}{
+				"event": []interface{}{"created", "deleted"},
+			}))
+		})
+
+		It("should allow the topics to send to the primary queue", func() {
+			Expect(queuePolicy.PolicyDocument).To(HaveKeyWithValue("Statement", ConsistOf(And(
+				HaveKeyWithValue("Action", "sqs:SendMessage"),
+				HaveKeyWithValue("Principal", HaveKeyWithValue("Service", "sns.amazonaws.com")),
+				HaveKeyWithValue("Condition", HaveKeyWithValue("ArnEquals", HaveKeyWithValue("aws:SourceArn", ConsistOf(
+					"arn:aws:sns:eu-west-2:123456789012:topic-a",
+					"arn:aws:sns:eu-west-2:123456789012:topic-b",
+				)))),
+			))))
+		})
+	})
+
+	Context("when secure transport is required", func() {
+		BeforeEach(func() {
+			builder.RequireSecureTransport = true
+		})
+
+		It("should deny insecure access to both queues", func() {
+			text, err := builder.Build()
+			Expect(err).ToNot(HaveOccurred())
+			t, err := parseTemplate(text)
+			Expect(err).ToNot(HaveOccurred())
+			for _, name := range []string{sqs.ResourcePrimaryQueuePolicy, sqs.ResourceSecondaryQueuePolicy} {
+				policy, ok := t.Resources[name].(*goformationsqs.QueuePolicy)
+				Expect(ok).To(BeTrue(), name)
+				Expect(policy.PolicyDocument).To(HaveKeyWithValue("Statement", ConsistOf(And(
+					HaveKeyWithValue("Effect", "Deny"),
+					HaveKeyWithValue("Principal", "*"),
+					HaveKeyWithValue("Action", "sqs:*"),
+					HaveKeyWithValue("Condition", HaveKeyWithValue("Bool", HaveKeyWithValue("aws:SecureTransport", "false"))),
+				))), name)
+			}
+			secondaryPolicy := t.Resources[sqs.ResourceSecondaryQueuePolicy].(*goformationsqs.QueuePolicy)
+			Expect(secondaryPolicy.AWSCloudFormationCondition).To(Equal(sqs.ConditionShouldCreateDLQ))
+		})
+	})
+
+	Context("when a queue policy is configured", func() {
+		var queuePolicy *goformationsqs.QueuePolicy
+
+		BeforeEach(func() {
+			builder.QueuePolicy = &sqs.QueuePolicy{
+				ServicePrincipals: []string{"s3.amazonaws.com"},
+				SourceARNs:        []string{"arn:aws:s3:::my-bucket"},
+				SourceAccounts:    []string{"123456789012"},
+				AccountIDs:        []string{"210987654321"},
+			}
+		})
+
+		JustBeforeEach(func() {
+			text, err := builder.Build()
+			Expect(err).ToNot(HaveOccurred())
+			t, err := parseTemplate(text)
+			Expect(err).ToNot(HaveOccurred())
+			var ok bool
+			queuePolicy, ok = t.Resources[sqs.ResourcePrimaryQueuePolicy].(*goformationsqs.QueuePolicy)
+			Expect(ok).To(BeTrue())
+			Expect(t.Resources).ToNot(HaveKey(sqs.ResourceSecondaryQueuePolicy))
+		})
+
+		It("should allow the services to send on behalf of the sources", func() {
+			Expect(queuePolicy.PolicyDocument).To(HaveKeyWithValue("Statement", ContainElement(And(
+				HaveKeyWithValue("Effect", "Allow"),
+				HaveKeyWithValue("Action", "sqs:SendMessage"),
+				HaveKeyWithValue("Principal", HaveKeyWithValue("Service", ConsistOf("s3.amazonaws.com"))),
+				HaveKeyWithValue("Condition", And(
+					HaveKeyWithValue("ArnLike", HaveKeyWithValue("aws:SourceArn", ConsistOf("arn:aws:s3:::my-bucket"))),
+					HaveKeyWithValue("StringEquals", HaveKeyWithValue("aws:SourceAccount", ConsistOf("123456789012"))),
+				)),
+			))))
+		})
+
+		It("should allow the other accounts to send", func() {
+			Expect(queuePolicy.PolicyDocument).To(HaveKeyWithValue("Statement", ContainElement(And(
+				HaveKeyWithValue("Effect", "Allow"),
+				HaveKeyWithValue("Action", ContainElement("sqs:SendMessage")),
+				HaveKeyWithValue("Principal", HaveKeyWithValue("AWS", ConsistOf("210987654321"))),
+			))))
+		})
+
+		Context("and a source ARN contains quotes and new lines", func() {
+			BeforeEach(func() {
+				builder.QueuePolicy.SourceARNs = []string{"arn:aws:s3:::my-bucket\"\n  EvilUser:\n    Type: AWS::IAM::User\n#"}
+			})
+
+			It("should keep it within the policy", func() {
+				Expect(queuePolicy.PolicyDocument).To(HaveKeyWithValue("Statement", ContainElement(
+					HaveKeyWithValue("Condition", HaveKeyWithValue("ArnLike", HaveKeyWithValue("aws:SourceArn", ConsistOf(
+						builder.QueuePolicy.SourceARNs[0],
+					)))),
+				)))
+			})
+		})
+	})
+
+	Context("when named queues are configured", func() {
+		var (
+			text      string
+			resources map[string]*goformationsqs.Queue
+		)
+
+		BeforeEach(func() {
+			builder.QueueName = "paas-sqs-broker-a-guid"
+			builder.Tags = map[string]string{"Name": "a-guid"}
+			builder.QueueNames = []string{"audit", "orders"}
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			text, err = builder.Build()
+			Expect(err).ToNot(HaveOccurred())
+			t, err := parseTemplate(text)
+			Expect(err).ToNot(HaveOccurred())
+			resources = t.GetAllSQSQueueResources()
+		})
+
+		It("should create a primary and dead-letter queue for each name", func() {
+			Expect(resources).To(HaveLen(6))
+			Expect(resources["QueueOrders"+sqs.ResourcePrimaryQueue].QueueName).To(Equal("paas-sqs-broker-a-guid-orders-pri"))
+			Expect(resources["QueueOrders"+sqs.ResourceSecondaryQueue].QueueName).To(Equal("paas-sqs-broker-a-guid-orders-sec"))
+			Expect(resources["QueueAudit"+sqs.ResourcePrimaryQueue].QueueName).To(Equal("paas-sqs-broker-a-guid-audit-pri"))
+			Expect(resources["QueueAudit"+sqs.ResourceSecondaryQueue].AWSCloudFormationCondition).To(
+				Equal("QueueAudit" + sqs.ConditionShouldCreateDLQ))
+		})
+
+		It("should give each queue its own parameters", func() {
+			var t map[string]interface{}
+			Expect(yaml.Unmarshal([]byte(text), &t)).To(Succeed())
+			parameters := t["Parameters"].(map[interface{}]interface{})
+			Expect(parameters).To(HaveKey("QueueOrders" + sqs.ParamDelaySeconds))
+			Expect(parameters).To(HaveKey("QueueAudit" + sqs.ParamDeadLetterQueue))
+			Expect(parameters).To(HaveKey(sqs.ParamKmsMasterKeyId))
+			Expect(parameters).ToNot(HaveKey("QueueOrders" + sqs.ParamKmsMasterKeyId))
+		})
+
+		It("should have outputs for each queue", func() {
+			var t map[string]interface{}
+			Expect(yaml.Unmarshal([]byte(text), &t)).To(Succeed())
+			outputs := t["Outputs"].(map[interface{}]interface{})
+			Expect(outputs).To(HaveKey("QueueOrders" + sqs.OutputPrimaryQueueURL))
+			Expect(outputs).To(HaveKey("QueueOrders" + sqs.OutputPrimaryQueueARN))
+			Expect(outputs).To(HaveKeyWithValue("QueueAudit"+sqs.OutputSecondaryQueueARN,
+				HaveKeyWithValue("Condition", "QueueAudit"+sqs.ConditionShouldCreateDLQ)))
+		})
+
+		It("should record the queue names in the metadata", func() {
+			params, err := sqs.ParseQueueTemplateParams(text)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(params.QueueNames).To(Equal([]string{"audit", "orders"}))
+		})
+
+		Context("when secure transport is required", func() {
+			BeforeEach(func() {
+				builder.RequireSecureTransport = true
+			})
+
+			It("should deny insecure access to the named queues", func() {
+				t, err := parseTemplate(text)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(t.Resources).To(HaveKey("QueueOrders" + sqs.ResourcePrimaryQueuePolicy))
+				Expect(t.Resources).To(HaveKey("QueueOrders" + sqs.ResourceSecondaryQueuePolicy))
+			})
+		})
+	})
+
+	It("should record the template params in the metadata", func() {
+		builder.SNSSubscriptions = []sqs.SNSSubscription{
+			{
+				TopicARN:     "arn:aws:sns:eu-west-2:123456789012:topic-a",
+				FilterPolicy: map[string]interface{}{"note": []interface{}{"it's \"quoted\""}},
+			},
+		}
+		builder.QueuePolicy = &sqs.QueuePolicy{AccountIDs: []string{"210987654321"}}
+		text, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		params, err := sqs.ParseQueueTemplateParams(text)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(params).To(Equal(builder.QueueTemplateParams))
+	})
+
+	It("should treat templates without metadata as having no template params", func() {
+		params, err := sqs.ParseQueueTemplateParams("AWSTemplateFormatVersion: 2010-09-09\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(params.IsEmpty()).To(BeTrue())
+	})
+
+	It("should name subscriptions after their topic", func() {
+		a := sqs.SNSSubscription{TopicARN: "arn:aws:sns:eu-west-2:123456789012:topic-a"}
+		b := sqs.SNSSubscription{TopicARN: "arn:aws:sns:eu-west-2:123456789012:topic-b"}
+		Expect(a.LogicalID()).To(MatchRegexp("^Subscription[0-9a-f]{16}$"))
+		Expect(a.LogicalID()).To(Equal(sqs.SNSSubscription{TopicARN: a.TopicARN, RawMessageDelivery: true}.LogicalID()))
+		Expect(a.LogicalID()).ToNot(Equal(b.LogicalID()))
+	})
+
 	It("should have outputs for connection details", func() {
 		builder := &sqs.QueueTemplateBuilder{}
 		text, err := builder.Build()
 		Expect(err).ToNot(HaveOccurred())
-		t, err := goformation.ParseYAML([]byte(text))
+		t, err := parseTemplate(text)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(t.Outputs).To(And(
 			HaveKey(sqs.OutputPrimaryQueueARN),
@@ -0,0 +1,375 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/alphagov/paas-sqs-broker/metrics"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+const (
+	ReconcileHealthy  = "healthy"
+	ReconcileOrphaned = "orphaned"
+	ReconcileFailed   = "failed"
+)
+
+const (
+	ReconcileActionNone     = "none"
+	ReconcileActionReported = "reported"
+	ReconcileActionDeleted  = "deleted"
+	ReconcileActionDeferred = "deferred"
+	ReconcileActionFailed   = "delete-failed"
+)
+
+const (
+	ResourceKindStack  = "stack"
+	ResourceKindSecret = "secret"
+)
+
+// Stacks is the number of the broker's stacks, by kind and status, as
+// of the last time the Reconciler ran, and StacksReconciled is when
+// that was.  Only the Reconciler lists every stack, so they are not set
+// if it is not run, and are up to its Interval out of date otherwise.
+// Polls for operations see too few stacks to keep the counts right.
+var (
+	Stacks = metrics.NewGauge(
+		"sqs_broker_stacks",
+		"The broker's stacks by kind (queue or binding) and status, as of the last reconciler run.",
+		"kind", "status",
+	)
+	StacksReconciled = metrics.NewGauge(
+		"sqs_broker_stacks_reconciled_timestamp_seconds",
+		"Unix time of the reconciler run that sqs_broker_stacks counted the stacks in.",
+	)
+)
+
+// A ReconcileResult is what the Reconciler found out about, and did
+// with, one of the broker's resources.
+type ReconcileResult struct {
+	Resource       string
+	Name           string
+	StackKind      string
+	Status         string
+	Classification string
+	Reason         string
+	Action         string
+}
+
+// Reconciler looks for the broker's resources that have been left
+// behind: binding stacks whose instance is gone, stacks stuck in a
+// failed state, and binding secrets whose stack is gone.  It only
+// reports them unless Cleanup is set, in which case it deletes up to
+// MaxDeletions of them each run.
+type Reconciler struct {
+	Provider *Provider
+	// Interval is the time between runs.
+	Interval time.Duration
+	// Cleanup deletes what is found rather than only reporting it.
+	Cleanup bool
+	// MaxDeletions is the most resources deleted in a run.  The rest
+	// are left for later runs.
+	MaxDeletions int
+	// MinAge is how long a resource must have been left alone before
+	// it is treated as left behind, so that operations in progress
+	// and recent failures are not touched.
+	MinAge time.Duration
+	Logger lager.Logger
+	// Now returns the current time.  Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Run reconciles every Interval until ctx is done.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Reconcile(ctx); err != nil {
+			r.Logger.Error("reconcile", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile classifies all of the broker's stacks and binding secrets,
+// logging and returning what it found and did.
+func (r *Reconciler) Reconcile(ctx context.Context) ([]ReconcileResult, error) {
+	stacks, err := r.Provider.listStacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := r.listSecrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stackNames := map[string]bool{}
+	for _, stack := range stacks {
+		stackNames[aws.StringValue(stack.StackName)] = true
+	}
+	setStackGauge(stacks, r.now())
+
+	results := []ReconcileResult{}
+	deletions := 0
+	act := func(result ReconcileResult, del func() error) {
+		switch {
+		case result.Classification == ReconcileHealthy:
+			result.Action = ReconcileActionNone
+		case !r.Cleanup:
+			result.Action = ReconcileActionReported
+		case deletions >= r.MaxDeletions:
+			result.Action = ReconcileActionDeferred
+		default:
+			deletions++
+			if err := del(); err != nil {
+				result.Action = ReconcileActionFailed
+				r.Logger.Error("reconcile-delete", err, result.logData())
+			} else {
+				result.Action = ReconcileActionDeleted
+			}
+		}
+		if result.Classification != ReconcileHealthy {
+			r.Logger.Info("reconcile-resource", result.logData())
+		}
+		results = append(results, result)
+	}
+
+	for _, stack := range stacks {
+		stack := stack
+		result := r.classifyStack(ctx, stack, stackNames)
+		act(result, func() error {
+			return r.deleteStack(ctx, stack, result.StackKind)
+		})
+	}
+	for _, secret := range secrets {
+		name := aws.StringValue(secret.Name)
+		result := r.classifySecret(secret, stackNames)
+		act(result, func() error {
+			return r.Provider.deleteBindingSecret(ctx, strings.TrimPrefix(name, r.Provider.ResourcePrefix+"-"))
+		})
+	}
+
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Classification]++
+		if result.Action != ReconcileActionNone {
+			counts[result.Action]++
+		}
+	}
+	summary := lager.Data{"cleanup": r.Cleanup}
+	for key, count := range counts {
+		summary[key] = count
+	}
+	r.Logger.Info("reconcile-complete", summary)
+	return results, nil
+}
+
+// classifyStack finds out whether a stack is healthy, failed, or a
+// binding whose instance's stack is gone.
+func (r *Reconciler) classifyStack(ctx context.Context, stack *cloudformation.Stack, stackNames map[string]bool) ReconcileResult {
+	status := aws.StringValue(stack.StackStatus)
+	result := ReconcileResult{
+		Resource:       ResourceKindStack,
+		Name:           aws.StringValue(stack.StackName),
+		StackKind:      stackKind(stack),
+		Status:         status,
+		Classification: ReconcileHealthy,
+	}
+	if r.isRecent(lastChanged(stack)) {
+		return result
+	}
+
+	switch status {
+	case cloudformation.StackStatusCreateFailed,
+		cloudformation.StackStatusRollbackComplete,
+		cloudformation.StackStatusRollbackFailed,
+		cloudformation.StackStatusDeleteFailed:
+		result.Classification = ReconcileFailed
+		result.Reason = "stack is " + status
+		return result
+	}
+
+	if result.StackKind == StackKindBinding {
+		instanceID, err := r.Provider.bindingInstanceID(ctx, stack)
+		if err != nil {
+			r.Logger.Error("reconcile-binding-instance", err, lager.Data{"stack": result.Name})
+			return result
+		}
+		if instanceID != "" && !stackNames[r.Provider.getStackName(instanceID)] {
+			result.Classification = ReconcileOrphaned
+			result.Reason = "instance " + instanceID + " has no stack"
+		}
+	}
+	return result
+}
+
+// classifySecret finds out whether a binding secret's stack is gone.
+func (r *Reconciler) classifySecret(secret *secretsmanager.SecretListEntry, stackNames map[string]bool) ReconcileResult {
+	name := aws.StringValue(secret.Name)
+	result := ReconcileResult{
+		Resource:       ResourceKindSecret,
+		Name:           name,
+		Classification: ReconcileHealthy,
+	}
+	changed := secret.LastChangedDate
+	if changed == nil {
+		changed = secret.CreatedDate
+	}
+	if !stackNames[name] && !r.isRecent(changed) {
+		result.Classification = ReconcileOrphaned
+		result.Reason = "binding has no stack"
+	}
+	return result
+}
+
+// deleteStack deletes a stack, along with its secret if it is, or may
+// be, a binding's.
+func (r *Reconciler) deleteStack(ctx context.Context, stack *cloudformation.Stack, kind string) error {
+	stackName := aws.StringValue(stack.StackName)
+	if kind != StackKindQueue {
+		bindingID := strings.TrimPrefix(stackName, r.Provider.ResourcePrefix+"-")
+		if err := r.Provider.deleteBindingSecret(ctx, bindingID); err != nil {
+			return err
+		}
+	}
+	_, err := r.Provider.Client.DeleteStackWithContext(ctx, &cloudformation.DeleteStackInput{
+		StackName: aws.String(stackName),
+	})
+	return err
+}
+
+// listSecrets returns the secrets named with the broker's prefix that
+// are not already being deleted.
+func (r *Reconciler) listSecrets(ctx context.Context) ([]*secretsmanager.SecretListEntry, error) {
+	prefix := r.Provider.ResourcePrefix + "-"
+	secrets := []*secretsmanager.SecretListEntry{}
+	var nextToken *string
+	for {
+		output, err := r.Provider.Client.ListSecretsWithContext(ctx, &secretsmanager.ListSecretsInput{
+			Filters: []*secretsmanager.Filter{{
+				Key:    aws.String(secretsmanager.FilterNameStringTypeName),
+				Values: []*string{aws.String(prefix)},
+			}},
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if output == nil {
+			return nil, fmt.Errorf("listSecrets output was nil, potential issue with AWS Client")
+		}
+		for _, secret := range output.SecretList {
+			if strings.HasPrefix(aws.StringValue(secret.Name), prefix) && secret.DeletedDate == nil {
+				secrets = append(secrets, secret)
+			}
+		}
+		if output.NextToken == nil {
+			return secrets, nil
+		}
+		nextToken = output.NextToken
+	}
+}
+
+func (r *Reconciler) isRecent(t *time.Time) bool {
+	return t != nil && r.now().Sub(*t) < r.MinAge
+}
+
+func (r *Reconciler) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func (result ReconcileResult) logData() lager.Data {
+	data := lager.Data{
+		"resource":       result.Resource,
+		"name":           result.Name,
+		"classification": result.Classification,
+		"action":         result.Action,
+	}
+	if result.StackKind != "" {
+		data["stack-kind"] = result.StackKind
+	}
+	if result.Status != "" {
+		data["status"] = result.Status
+	}
+	if result.Reason != "" {
+		data["reason"] = result.Reason
+	}
+	return data
+}
+
+// setStackGauge replaces the values of the Stacks gauge with counts of
+// stacks listed at the given time.
+func setStackGauge(stacks []*cloudformation.Stack, listed time.Time) {
+	type key struct{ kind, status string }
+	counts := map[key]int{}
+	for _, stack := range stacks {
+		kind := stackKind(stack)
+		if kind == "" {
+			kind = "unknown"
+		}
+		counts[key{kind, aws.StringValue(stack.StackStatus)}]++
+	}
+	Stacks.Reset()
+	for k, count := range counts {
+		Stacks.Set(float64(count), k.kind, k.status)
+	}
+	StacksReconciled.Set(float64(listed.Unix()))
+}
+
+// stackKind tells queue and binding stacks apart by their outputs, or
+// by their tags if they failed before they had any outputs.
+func stackKind(stack *cloudformation.Stack) string {
+	switch {
+	case getStackOutput(stack, OutputPrimaryQueueARN) != "" || getStackTag(stack, TagPlanId) != "":
+		return StackKindQueue
+	case getStackOutput(stack, OutputCredentialsARN) != "" || getStackTag(stack, TagInstanceId) != "":
+		return StackKindBinding
+	}
+	return ""
+}
+
+// lastChanged returns when a stack was last created or updated.
+func lastChanged(stack *cloudformation.Stack) *time.Time {
+	if stack.LastUpdatedTime != nil {
+		return stack.LastUpdatedTime
+	}
+	return stack.CreationTime
+}
+
+// bindingInstanceID returns the ID of the instance a binding belongs
+// to.  Stacks created before it was recorded in TagInstanceId have it
+// read from the tags of the binding's IAM user or role.
+func (s *Provider) bindingInstanceID(ctx context.Context, stack *cloudformation.Stack) (string, error) {
+	if instanceID := getStackTag(stack, TagInstanceId); instanceID != "" {
+		return instanceID, nil
+	}
+	body, err := s.getTemplateBody(ctx, aws.StringValue(stack.StackName))
+	if err != nil {
+		return "", err
+	}
+	role, err := ParseBindingRole(body)
+	if err != nil {
+		return "", err
+	}
+	resource := ResourceUser
+	if role != nil {
+		resource = ResourceRole
+	}
+	tags, err := templateResourceTags(body, resource)
+	if err != nil {
+		return "", err
+	}
+	return tags[TagCostAllocation], nil
+}
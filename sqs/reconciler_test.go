@@ -0,0 +1,276 @@
+package sqs_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	fakeClient "github.com/alphagov/paas-sqs-broker/sqs/fakes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+var _ = Describe("Reconciler", func() {
+	var (
+		fakeCfnClient *fakeClient.FakeClient
+		reconciler    *sqs.Reconciler
+		now           time.Time
+		results       []sqs.ReconcileResult
+		err           error
+	)
+
+	stack := func(name, status string, age time.Duration, tags map[string]string, outputs map[string]string) *cloudformation.Stack {
+		s := &cloudformation.Stack{
+			StackName:    aws.String(name),
+			StackStatus:  aws.String(status),
+			CreationTime: aws.Time(now.Add(-age)),
+		}
+		for key, value := range tags {
+			s.Tags = append(s.Tags, &cloudformation.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		for key, value := range outputs {
+			s.Outputs = append(s.Outputs, &cloudformation.Output{OutputKey: aws.String(key), OutputValue: aws.String(value)})
+		}
+		return s
+	}
+
+	secret := func(name string, age time.Duration) *secretsmanager.SecretListEntry {
+		return &secretsmanager.SecretListEntry{
+			Name:        aws.String(name),
+			CreatedDate: aws.Time(now.Add(-age)),
+		}
+	}
+
+	resultFor := func(name string) sqs.ReconcileResult {
+		for _, result := range results {
+			if result.Name == name {
+				return result
+			}
+		}
+		Fail("no result for " + name)
+		return sqs.ReconcileResult{}
+	}
+
+	deletedStacks := func() []string {
+		names := []string{}
+		for i := 0; i < fakeCfnClient.DeleteStackWithContextCallCount(); i++ {
+			_, input, _ := fakeCfnClient.DeleteStackWithContextArgsForCall(i)
+			names = append(names, aws.StringValue(input.StackName))
+		}
+		return names
+	}
+
+	deletedSecrets := func() []string {
+		names := []string{}
+		for i := 0; i < fakeCfnClient.DeleteSecretWithContextCallCount(); i++ {
+			_, input, _ := fakeCfnClient.DeleteSecretWithContextArgsForCall(i)
+			names = append(names, aws.StringValue(input.SecretId))
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		fakeCfnClient = &fakeClient.FakeClient{}
+		reconciler = &sqs.Reconciler{
+			Provider: &sqs.Provider{
+				Client:         fakeCfnClient,
+				Environment:    "test",
+				ResourcePrefix: "testprefix",
+			},
+			MaxDeletions: 10,
+			MinAge:       time.Hour,
+			Logger:       lager.NewLogger("reconciler"),
+			Now:          func() time.Time { return now },
+		}
+		day := 24 * time.Hour
+		fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				stack("testprefix-instance-1", cloudformation.StackStatusCreateComplete, day,
+					map[string]string{sqs.TagPlanId: "plan-1"},
+					map[string]string{sqs.OutputPrimaryQueueARN: "arn-1"}),
+				stack("testprefix-binding-1", cloudformation.StackStatusCreateComplete, day,
+					map[string]string{sqs.TagInstanceId: "instance-1"},
+					map[string]string{sqs.OutputCredentialsARN: "arn-secret-1"}),
+				stack("testprefix-binding-2", cloudformation.StackStatusCreateComplete, day,
+					map[string]string{sqs.TagInstanceId: "instance-gone"},
+					map[string]string{sqs.OutputCredentialsARN: "arn-secret-2"}),
+				stack("testprefix-binding-3", cloudformation.StackStatusRollbackComplete, day,
+					map[string]string{sqs.TagInstanceId: "instance-1"}, nil),
+				stack("testprefix-instance-2", cloudformation.StackStatusDeleteFailed, day,
+					map[string]string{sqs.TagPlanId: "plan-1"}, nil),
+				stack("testprefix-binding-4", cloudformation.StackStatusRollbackComplete, 10*time.Minute,
+					map[string]string{sqs.TagInstanceId: "instance-1"}, nil),
+				stack("testprefix-binding-5", cloudformation.StackStatusUpdateComplete, day,
+					nil,
+					map[string]string{sqs.OutputCredentialsARN: "arn-secret-5"}),
+				stack("someone-elses-stack", cloudformation.StackStatusRollbackComplete, day, nil, nil),
+			},
+		}, nil)
+		fakeCfnClient.GetTemplateWithContextStub = func(ctx context.Context, input *cloudformation.GetTemplateInput, opts ...request.Option) (*cloudformation.GetTemplateOutput, error) {
+			Expect(aws.StringValue(input.StackName)).To(Equal("testprefix-binding-5"))
+			body, err := (&sqs.UserTemplateBuilder{
+				BindingID: "binding-5",
+				Tags:      map[string]string{sqs.TagCostAllocation: "instance-gone"},
+			}).Build()
+			return &cloudformation.GetTemplateOutput{TemplateBody: aws.String(body)}, err
+		}
+		fakeCfnClient.ListSecretsWithContextReturns(&secretsmanager.ListSecretsOutput{
+			SecretList: []*secretsmanager.SecretListEntry{
+				secret("testprefix-binding-1", day),
+				secret("testprefix-binding-6", day),
+				secret("testprefix-binding-7", 10*time.Minute),
+				secret("someone-elses-secret", day),
+			},
+		}, nil)
+	})
+
+	JustBeforeEach(func() {
+		results, err = reconciler.Reconcile(context.Background())
+	})
+
+	It("classifies the broker's stacks and secrets", func() {
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(10))
+
+		Expect(resultFor("testprefix-instance-1").Classification).To(Equal(sqs.ReconcileHealthy))
+		Expect(resultFor("testprefix-binding-1").Classification).To(Equal(sqs.ReconcileHealthy))
+		Expect(resultFor("testprefix-binding-2")).To(Equal(sqs.ReconcileResult{
+			Resource:       sqs.ResourceKindStack,
+			Name:           "testprefix-binding-2",
+			StackKind:      sqs.StackKindBinding,
+			Status:         cloudformation.StackStatusCreateComplete,
+			Classification: sqs.ReconcileOrphaned,
+			Reason:         "instance instance-gone has no stack",
+			Action:         sqs.ReconcileActionReported,
+		}))
+		Expect(resultFor("testprefix-binding-3").Classification).To(Equal(sqs.ReconcileFailed))
+		Expect(resultFor("testprefix-instance-2").Classification).To(Equal(sqs.ReconcileFailed))
+		Expect(resultFor("testprefix-binding-5").Classification).To(Equal(sqs.ReconcileOrphaned))
+		Expect(resultFor("testprefix-binding-6")).To(Equal(sqs.ReconcileResult{
+			Resource:       sqs.ResourceKindSecret,
+			Name:           "testprefix-binding-6",
+			Classification: sqs.ReconcileOrphaned,
+			Reason:         "binding has no stack",
+			Action:         sqs.ReconcileActionReported,
+		}))
+	})
+
+	It("counts the stacks by kind and status", func() {
+		Expect(sqs.Stacks.Value(sqs.StackKindBinding, cloudformation.StackStatusRollbackComplete)).To(Equal(2.0))
+		Expect(sqs.Stacks.Value(sqs.StackKindBinding, cloudformation.StackStatusCreateComplete)).To(Equal(2.0))
+		Expect(sqs.Stacks.Value(sqs.StackKindQueue, cloudformation.StackStatusDeleteFailed)).To(Equal(1.0))
+		Expect(sqs.StacksReconciled.Value()).To(Equal(float64(now.Unix())))
+	})
+
+	It("recounts the stacks on each run", func() {
+		Expect(err).ToNot(HaveOccurred())
+		fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				stack("testprefix-instance-1", cloudformation.StackStatusUpdateInProgress, time.Minute,
+					map[string]string{sqs.TagPlanId: "plan-1"},
+					map[string]string{sqs.OutputPrimaryQueueARN: "arn-1"}),
+			},
+		}, nil)
+		now = now.Add(time.Hour)
+
+		_, err = reconciler.Reconcile(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sqs.Stacks.Value(sqs.StackKindQueue, cloudformation.StackStatusUpdateInProgress)).To(Equal(1.0))
+		Expect(sqs.Stacks.Value(sqs.StackKindBinding, cloudformation.StackStatusCreateComplete)).To(BeZero())
+		Expect(sqs.Stacks.Value(sqs.StackKindQueue, cloudformation.StackStatusDeleteFailed)).To(BeZero())
+		Expect(sqs.StacksReconciled.Value()).To(Equal(float64(now.Unix())))
+	})
+
+	Context("when the stacks cannot be listed", func() {
+		BeforeEach(func() {
+			fakeCfnClient.DescribeStacksWithContextReturns(nil, errors.New("throttled"))
+			sqs.Stacks.Set(3, sqs.StackKindQueue, cloudformation.StackStatusCreateComplete)
+			sqs.StacksReconciled.Set(1)
+		})
+
+		It("leaves the previous counts in place", func() {
+			Expect(err).To(MatchError("throttled"))
+			Expect(sqs.Stacks.Value(sqs.StackKindQueue, cloudformation.StackStatusCreateComplete)).To(Equal(3.0))
+			Expect(sqs.StacksReconciled.Value()).To(Equal(1.0))
+		})
+	})
+
+	It("leaves recently changed resources alone", func() {
+		Expect(resultFor("testprefix-binding-4").Classification).To(Equal(sqs.ReconcileHealthy))
+		Expect(resultFor("testprefix-binding-7").Classification).To(Equal(sqs.ReconcileHealthy))
+	})
+
+	It("only reports by default", func() {
+		Expect(fakeCfnClient.DeleteStackWithContextCallCount()).To(BeZero())
+		Expect(fakeCfnClient.DeleteSecretWithContextCallCount()).To(BeZero())
+	})
+
+	Context("when cleaning up", func() {
+		BeforeEach(func() {
+			reconciler.Cleanup = true
+		})
+
+		It("deletes orphaned and failed stacks and orphaned secrets", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deletedStacks()).To(ConsistOf(
+				"testprefix-binding-2",
+				"testprefix-binding-3",
+				"testprefix-instance-2",
+				"testprefix-binding-5",
+			))
+			Expect(resultFor("testprefix-binding-2").Action).To(Equal(sqs.ReconcileActionDeleted))
+			Expect(resultFor("testprefix-binding-1").Action).To(Equal(sqs.ReconcileActionNone))
+		})
+
+		It("deletes the secrets of binding stacks it deletes", func() {
+			Expect(deletedSecrets()).To(ConsistOf(
+				"testprefix-binding-2",
+				"testprefix-binding-3",
+				"testprefix-binding-5",
+				"testprefix-binding-6",
+			))
+		})
+
+		Context("and there are more to delete than the limit", func() {
+			BeforeEach(func() {
+				reconciler.MaxDeletions = 2
+			})
+
+			It("leaves the rest for later runs", func() {
+				Expect(deletedStacks()).To(Equal([]string{"testprefix-binding-2", "testprefix-binding-3"}))
+				Expect(resultFor("testprefix-instance-2").Action).To(Equal(sqs.ReconcileActionDeferred))
+				Expect(resultFor("testprefix-binding-6").Action).To(Equal(sqs.ReconcileActionDeferred))
+			})
+		})
+
+		Context("and a deletion fails", func() {
+			BeforeEach(func() {
+				fakeCfnClient.DeleteStackWithContextReturns(nil, errors.New("boom"))
+			})
+
+			It("carries on and reports it", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resultFor("testprefix-binding-2").Action).To(Equal(sqs.ReconcileActionFailed))
+				Expect(resultFor("testprefix-binding-6").Action).To(Equal(sqs.ReconcileActionDeleted))
+			})
+		})
+	})
+
+	Context("when the stacks cannot be listed", func() {
+		BeforeEach(func() {
+			fakeCfnClient.DescribeStacksWithContextReturns(nil, errors.New("boom"))
+		})
+
+		It("returns the error", func() {
+			Expect(err).To(MatchError("boom"))
+		})
+	})
+})
@@ -0,0 +1,361 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/alphagov/paas-sqs-broker/metrics"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// ErrAWSUnavailable is returned instead of calling AWS while the
+// circuit breaker is open.
+var ErrAWSUnavailable = apiresponses.NewFailureResponse(
+	fmt.Errorf("AWS is currently unavailable, please try again later"),
+	http.StatusServiceUnavailable,
+	"aws-unavailable",
+)
+
+// AWSCallDuration times each attempt at an AWS call, and
+// AWSCallErrors counts those that failed, by the error code AWS gave.
+var (
+	AWSCallDuration = metrics.NewHistogram(
+		"sqs_broker_aws_call_duration_seconds",
+		"Time taken by each attempt at an AWS API call.",
+		metrics.DefaultBuckets,
+		"operation",
+	)
+	AWSCallErrors = metrics.NewCounter(
+		"sqs_broker_aws_call_errors_total",
+		"AWS API call attempts that failed, by error code.",
+		"operation", "code",
+	)
+)
+
+// RetryingClient is a Client that retries calls failing with
+// throttling or transient server errors, with jittered exponential
+// backoff.  Calls that create or update stacks are only retried when
+// throttled, as other failures may have happened after the change was
+// made.
+//
+// Every attempt waits for a token from Limiter, if set, and calls are
+// refused with ErrAWSUnavailable while Breaker, if set, is open.
+type RetryingClient struct {
+	Client
+	// MaxAttempts is the most times a call is tried.
+	MaxAttempts int
+	// BaseDelay is the longest wait before the first retry, doubling
+	// with each retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Limiter   *TokenBucket
+	Breaker   *CircuitBreaker
+	Logger    lager.Logger
+}
+
+func (c *RetryingClient) DescribeStacksWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (output *cloudformation.DescribeStacksOutput, err error) {
+	err = c.call(ctx, "DescribeStacks", true, func() error {
+		output, err = c.Client.DescribeStacksWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c *RetryingClient) CreateStackWithContext(ctx aws.Context, input *cloudformation.CreateStackInput, opts ...request.Option) (output *cloudformation.CreateStackOutput, err error) {
+	err = c.call(ctx, "CreateStack", false, func() error {
+		output, err = c.Client.CreateStackWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c *RetryingClient) UpdateStackWithContext(ctx aws.Context, input *cloudformation.UpdateStackInput, opts ...request.Option) (output *cloudformation.UpdateStackOutput, err error) {
+	err = c.call(ctx, "UpdateStack", false, func() error {
+		output, err = c.Client.UpdateStackWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c *RetryingClient) DeleteStackWithContext(ctx aws.Context, input *cloudformation.DeleteStackInput, opts ...request.Option) (output *cloudformation.DeleteStackOutput, err error) {
+	err = c.call(ctx, "DeleteStack", true, func() error {
+		output, err = c.Client.DeleteStackWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c *RetryingClient) GetTemplateWithContext(ctx aws.Context, input *cloudformation.GetTemplateInput, opts ...request.Option) (output *cloudformation.GetTemplateOutput, err error) {
+	err = c.call(ctx, "GetTemplate", true, func() error {
+		output, err = c.Client.GetTemplateWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c *RetryingClient) DescribeStackEventsWithContext(ctx aws.Context, input *cloudformation.DescribeStackEventsInput, opts ...request.Option) (output *cloudformation.DescribeStackEventsOutput, err error) {
+	err = c.call(ctx, "DescribeStackEvents", true, func() error {
+		output, err = c.Client.DescribeStackEventsWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c *RetryingClient) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (output *secretsmanager.GetSecretValueOutput, err error) {
+	err = c.call(ctx, "GetSecretValue", true, func() error {
+		output, err = c.Client.GetSecretValueWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c *RetryingClient) DeleteSecretWithContext(ctx aws.Context, input *secretsmanager.DeleteSecretInput, opts ...request.Option) (output *secretsmanager.DeleteSecretOutput, err error) {
+	err = c.call(ctx, "DeleteSecret", true, func() error {
+		output, err = c.Client.DeleteSecretWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (c *RetryingClient) ListSecretsWithContext(ctx aws.Context, input *secretsmanager.ListSecretsInput, opts ...request.Option) (output *secretsmanager.ListSecretsOutput, err error) {
+	err = c.call(ctx, "ListSecrets", true, func() error {
+		output, err = c.Client.ListSecretsWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+// call runs fn until it succeeds, fails with an error that is not
+// worth retrying, runs out of attempts, or would have to wait past the
+// context's deadline.  idempotent says whether fn can be retried after
+// transient errors as well as throttling.
+func (c *RetryingClient) call(ctx context.Context, operation string, idempotent bool, fn func() error) error {
+	if c.Breaker != nil && !c.Breaker.Allow() {
+		return ErrAWSUnavailable
+	}
+	var err error
+attempts:
+	for attempt := 1; ; attempt++ {
+		if c.Limiter != nil {
+			if waitErr := c.Limiter.Wait(ctx); waitErr != nil {
+				if err == nil {
+					err = waitErr
+				}
+				break
+			}
+		}
+		start := time.Now()
+		err = fn()
+		AWSCallDuration.Observe(time.Since(start).Seconds(), operation)
+		if err != nil {
+			AWSCallErrors.Inc(operation, awsErrorCode(err))
+		}
+		retryable := isThrottleError(err) || (idempotent && isTransientError(err))
+		if !retryable || attempt >= c.MaxAttempts {
+			break
+		}
+		delay := c.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			break
+		}
+		c.Logger.Debug("retry-aws-call", lager.Data{
+			"operation": operation,
+			"attempt":   attempt,
+			"delay":     delay.String(),
+			"error":     err.Error(),
+		})
+		select {
+		case <-ctx.Done():
+			break attempts
+		case <-time.After(delay):
+		}
+	}
+	if c.Breaker != nil {
+		switch {
+		case isThrottleError(err) || isTransientError(err):
+			if c.Breaker.Failure() {
+				c.Logger.Error("circuit-breaker-open", err, lager.Data{
+					"operation": operation,
+					"cooldown":  c.Breaker.Cooldown.String(),
+				})
+			}
+		case ctx.Err() != nil:
+			// the caller gave up, which says nothing about AWS
+			c.Breaker.Abandon()
+		default:
+			c.Breaker.Success()
+		}
+	}
+	return err
+}
+
+// backoff returns a random delay of up to BaseDelay doubled for each
+// attempt already made, capped at MaxDelay.
+func (c *RetryingClient) backoff(attempt int) time.Duration {
+	limit := c.BaseDelay << uint(attempt-1)
+	if limit > c.MaxDelay || limit <= 0 {
+		limit = c.MaxDelay
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// awsErrorCode returns the code of an error from AWS, or "unknown" for
+// other errors such as the context being done.
+func awsErrorCode(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() != "" {
+		return awsErr.Code()
+	}
+	return "unknown"
+}
+
+func isThrottleError(err error) bool {
+	return err != nil && request.IsErrorThrottle(err)
+}
+
+// isTransientError reports whether err is a server-side or network
+// failure from the AWS SDK that may not happen again.
+func isTransientError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	if request.IsErrorRetryable(awsErr) {
+		return true
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		if reqErr.StatusCode() >= 500 && reqErr.StatusCode() != http.StatusNotImplemented {
+			return true
+		}
+	}
+	switch awsErr.Code() {
+	case "InternalFailure", "InternalServiceError", "ServiceUnavailable":
+		return true
+	}
+	return false
+}
+
+// TokenBucket limits calls to a rate a second, allowing bursts of up
+// to a number of calls.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available and takes it, or returns the
+// context's error if it is done first.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// CircuitBreaker opens after Threshold calls in a row fail because
+// AWS is throttling or unavailable.  Calls are refused while it is
+// open.  After Cooldown one call is let through, and the breaker
+// closes if it succeeds or opens again if it fails.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	// Now returns the current time.  Defaults to time.Now.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// Allow reports whether a call may be made.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.Threshold {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Success records a call that AWS answered.
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure records a call that failed because AWS is degraded, and
+// reports whether that opened the breaker.
+func (b *CircuitBreaker) Failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.failures >= b.Threshold
+	b.failures++
+	b.probing = false
+	if b.failures < b.Threshold {
+		return false
+	}
+	b.openUntil = b.now().Add(b.Cooldown)
+	return !wasOpen
+}
+
+// Abandon records a call that ended without an answer from AWS, such
+// as one whose context was cancelled.
+func (b *CircuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
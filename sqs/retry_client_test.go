@@ -0,0 +1,185 @@
+package sqs_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	fakeClient "github.com/alphagov/paas-sqs-broker/sqs/fakes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+var _ = Describe("RetryingClient", func() {
+	var (
+		fakeCfnClient *fakeClient.FakeClient
+		client        *sqs.RetryingClient
+		throttled     error
+		unavailable   error
+	)
+
+	describe := func(ctx context.Context) error {
+		_, err := client.DescribeStacksWithContext(ctx, &cloudformation.DescribeStacksInput{
+			StackName: aws.String("stack"),
+		})
+		return err
+	}
+
+	BeforeEach(func() {
+		fakeCfnClient = &fakeClient.FakeClient{}
+		client = &sqs.RetryingClient{
+			Client:      fakeCfnClient,
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    5 * time.Millisecond,
+			Logger:      lager.NewLogger("aws-client"),
+		}
+		throttled = &fakeClient.MockAWSError{C: "Throttling", M: "Rate exceeded"}
+		unavailable = awserr.NewRequestFailure(awserr.New("InternalFailure", "oops", nil), 503, "request-id")
+	})
+
+	It("retries calls that are throttled", func() {
+		fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, nil, throttled)
+		fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, &cloudformation.DescribeStacksOutput{}, nil)
+		Expect(describe(context.Background())).To(Succeed())
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+	})
+
+	It("retries reads that fail with a server error", func() {
+		fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, nil, unavailable)
+		fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, &cloudformation.DescribeStacksOutput{}, nil)
+		Expect(describe(context.Background())).To(Succeed())
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+	})
+
+	It("gives up after the maximum number of attempts", func() {
+		fakeCfnClient.DescribeStacksWithContextReturns(nil, throttled)
+		Expect(describe(context.Background())).To(MatchError(throttled))
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(3))
+	})
+
+	It("does not retry errors that will happen again", func() {
+		notFound := &fakeClient.MockAWSError{C: "ValidationError", M: "Stack with id stack does not exist"}
+		fakeCfnClient.DescribeStacksWithContextReturns(nil, notFound)
+		Expect(describe(context.Background())).To(MatchError(notFound))
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(1))
+	})
+
+	It("times each attempt and counts failures by error code", func() {
+		beforeCalls := sqs.AWSCallDuration.Count("DescribeStacks")
+		beforeErrors := sqs.AWSCallErrors.Value("DescribeStacks", "Throttling")
+		fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, nil, throttled)
+		fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, &cloudformation.DescribeStacksOutput{}, nil)
+		Expect(describe(context.Background())).To(Succeed())
+		Expect(sqs.AWSCallDuration.Count("DescribeStacks")).To(Equal(beforeCalls + 2))
+		Expect(sqs.AWSCallErrors.Value("DescribeStacks", "Throttling")).To(Equal(beforeErrors + 1))
+	})
+
+	It("does not wait past the context's deadline", func() {
+		client.BaseDelay = time.Hour
+		client.MaxDelay = time.Hour
+		fakeCfnClient.DescribeStacksWithContextReturns(nil, throttled)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		Expect(describe(ctx)).To(MatchError(throttled))
+		Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(1))
+	})
+
+	Context("when creating or updating stacks", func() {
+		It("retries when throttled", func() {
+			fakeCfnClient.UpdateStackWithContextReturnsOnCall(0, nil, throttled)
+			fakeCfnClient.UpdateStackWithContextReturnsOnCall(1, &cloudformation.UpdateStackOutput{}, nil)
+			_, err := client.UpdateStackWithContext(context.Background(), &cloudformation.UpdateStackInput{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(2))
+		})
+
+		It("does not retry server errors, as the change may have been made", func() {
+			fakeCfnClient.CreateStackWithContextReturns(nil, unavailable)
+			_, err := client.CreateStackWithContext(context.Background(), &cloudformation.CreateStackInput{})
+			Expect(err).To(MatchError(unavailable))
+			Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("with a rate limit", func() {
+		BeforeEach(func() {
+			client.Limiter = sqs.NewTokenBucket(1, 2)
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{}, nil)
+		})
+
+		It("allows bursts and then waits for tokens", func() {
+			Expect(describe(context.Background())).To(Succeed())
+			Expect(describe(context.Background())).To(Succeed())
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			Expect(describe(ctx)).To(MatchError(context.DeadlineExceeded))
+			Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("with a circuit breaker", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+			client.MaxAttempts = 1
+			client.Breaker = &sqs.CircuitBreaker{
+				Threshold: 2,
+				Cooldown:  30 * time.Second,
+				Now:       func() time.Time { return now },
+			}
+			fakeCfnClient.DescribeStacksWithContextReturns(nil, unavailable)
+		})
+
+		It("fails fast with a 503 once AWS keeps failing", func() {
+			Expect(describe(context.Background())).To(MatchError(unavailable))
+			Expect(describe(context.Background())).To(MatchError(unavailable))
+
+			err := describe(context.Background())
+			Expect(err).To(Equal(sqs.ErrAWSUnavailable))
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(503))
+			Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+		})
+
+		It("does not count errors that say nothing about AWS's health", func() {
+			fakeCfnClient.DescribeStacksWithContextReturns(nil, errors.New("Stack with id stack does not exist"))
+			for i := 0; i < 3; i++ {
+				describe(context.Background())
+			}
+			Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(3))
+		})
+
+		It("lets a call through after the cooldown and closes if it succeeds", func() {
+			describe(context.Background())
+			describe(context.Background())
+			now = now.Add(30 * time.Second)
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{}, nil)
+
+			Expect(describe(context.Background())).To(Succeed())
+			Expect(describe(context.Background())).To(Succeed())
+			Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(4))
+		})
+
+		It("opens again if the call after the cooldown fails", func() {
+			describe(context.Background())
+			describe(context.Background())
+			now = now.Add(30 * time.Second)
+
+			Expect(describe(context.Background())).To(MatchError(unavailable))
+			Expect(describe(context.Background())).To(Equal(sqs.ErrAWSUnavailable))
+			Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(3))
+		})
+	})
+})
@@ -0,0 +1,215 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/alphagov/paas-sqs-broker/metrics"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const (
+	RotationResultRotated = "rotated"
+	RotationResultRetired = "retired"
+	RotationResultFailed  = "failed"
+	RotationResultSkipped = "skipped"
+)
+
+// AccessKeyRotations counts the access key rotations done by
+// KeyRotator.
+var AccessKeyRotations = metrics.NewCounter(
+	"sqs_broker_access_key_rotations_total",
+	"Binding access keys rotated, retired, skipped because another broker was updating the binding, or failed to rotate.",
+	"result",
+)
+
+// KeyRotator replaces the access keys of bindings once they reach a
+// maximum age.  A new key is created and put in the binding's
+// credentials, and the old key is kept active for a grace period so
+// that apps using it carry on working until they pick up the new one.
+//
+// Brokers running side by side each have a KeyRotator.  Checks are
+// randomly delayed by up to Jitter so that they do not run at the same
+// moment, and a binding whose stack another broker has started to
+// update is left alone.
+type KeyRotator struct {
+	Provider *Provider
+	// MaxAge is the age at which a binding's access key is replaced.
+	MaxAge time.Duration
+	// GracePeriod is how long a replaced key stays active.
+	GracePeriod time.Duration
+	// Interval is the time between checks for keys to rotate.
+	Interval time.Duration
+	// Jitter is the most each check is randomly delayed by.  Defaults
+	// to a tenth of Interval.
+	Jitter time.Duration
+	Logger lager.Logger
+	// Now returns the current time.  Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Run rotates keys every Interval until ctx is done.
+func (r *KeyRotator) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.jitter()):
+		}
+		if err := r.RotateKeys(ctx); err != nil {
+			r.Logger.Error("rotate-access-keys", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *KeyRotator) jitter() time.Duration {
+	max := r.Jitter
+	if max == 0 {
+		max = r.Interval / 10
+	}
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// RotateKeys checks every binding, rotating keys older than MaxAge
+// and retiring keys whose grace period is over.  It carries on past
+// bindings that fail, returning an error if any did.
+func (r *KeyRotator) RotateKeys(ctx context.Context) error {
+	stacks, err := r.Provider.listStacks(ctx)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, stack := range stacks {
+		if getStackOutput(stack, OutputCredentialsARN) == "" {
+			continue
+		}
+		if !isRotatable(stack) {
+			// try again once whatever is happening to it has finished
+			continue
+		}
+		if err := r.rotate(ctx, aws.StringValue(stack.StackName)); err != nil {
+			failed++
+			AccessKeyRotations.Inc(RotationResultFailed)
+			r.Logger.Error("rotate-access-key", err, lager.Data{
+				"stack": aws.StringValue(stack.StackName),
+			})
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d bindings failed to rotate access keys", failed)
+	}
+	return nil
+}
+
+// isRotatable tells whether a binding's stack is settled, so that its
+// keys can be rotated.
+func isRotatable(stack *cloudformation.Stack) bool {
+	switch aws.StringValue(stack.StackStatus) {
+	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete, cloudformation.StackStatusUpdateRollbackComplete:
+		return true
+	}
+	return false
+}
+
+// rotate rotates or retires the keys of a binding if they are due.
+func (r *KeyRotator) rotate(ctx context.Context, stackName string) error {
+	// the listed stack may be out of date, and another broker may have
+	// started rotating the keys since
+	stack, err := r.Provider.getStack(ctx, stackName)
+	if err == ErrStackNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !isRotatable(stack) {
+		return nil
+	}
+	body, err := r.Provider.getTemplateBody(ctx, stackName)
+	if err != nil {
+		return err
+	}
+	role, err := ParseBindingRole(body)
+	if err != nil || role != nil {
+		// role-based bindings have no access keys
+		return err
+	}
+	keys, err := ParseAccessKeys(body)
+	if err != nil {
+		return err
+	}
+
+	now := r.now()
+	var updated AccessKeys
+	var result string
+	if keys.Retiring != nil {
+		if keys.Retiring.RetireAt != nil && now.Before(*keys.Retiring.RetireAt) {
+			return nil
+		}
+		updated = keys.Retire()
+		result = RotationResultRetired
+	} else {
+		createdAt := stack.CreationTime
+		if keys.Current.CreatedAt != nil {
+			createdAt = keys.Current.CreatedAt
+		}
+		if createdAt == nil || now.Sub(*createdAt) < r.MaxAge {
+			return nil
+		}
+		updated = keys.Rotate(now, r.GracePeriod)
+		result = RotationResultRotated
+	}
+
+	// the stack is rebuilt from the current user template, so an
+	// outdated binding is upgraded along with its keys
+	if version := getStackTag(stack, TagTemplateVersion); version != UserTemplateVersion {
+		r.Logger.Info("rotate-access-key-upgrades-template", lager.Data{
+			"stack":        stackName,
+			"from-version": version,
+			"to-version":   UserTemplateVersion,
+		})
+	}
+	err = r.Provider.updateBindingStack(ctx, stack, body, updated)
+	if isStackInProgressError(err) {
+		// another broker got there first
+		AccessKeyRotations.Inc(RotationResultSkipped)
+		r.Logger.Info("rotate-access-key-skipped", lager.Data{
+			"stack": stackName,
+		})
+		return nil
+	} else if err != nil {
+		return err
+	}
+	AccessKeyRotations.Inc(result)
+	data := lager.Data{
+		"stack":  stackName,
+		"result": result,
+		"serial": updated.Current.Serial,
+	}
+	if updated.Retiring != nil {
+		data["retiring-serial"] = updated.Retiring.Serial
+		data["retire-at"] = updated.Retiring.RetireAt
+	}
+	r.Logger.Info("rotate-access-key", data)
+	return nil
+}
+
+func (r *KeyRotator) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,333 @@
+package sqs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	fakeClient "github.com/alphagov/paas-sqs-broker/sqs/fakes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+var _ = Describe("KeyRotator", func() {
+	var (
+		fakeCfnClient *fakeClient.FakeClient
+		rotator       *sqs.KeyRotator
+		log           *bytes.Buffer
+		bindingStack  *cloudformation.Stack
+		bindingKeys   sqs.AccessKeys
+		now           time.Time
+		err           error
+	)
+
+	metricValue := func(result string) float64 {
+		return sqs.AccessKeyRotations.Value(result)
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		fakeCfnClient = &fakeClient.FakeClient{}
+		log = &bytes.Buffer{}
+		logger := lager.NewLogger("key-rotator")
+		logger.RegisterSink(lager.NewWriterSink(log, lager.INFO))
+		rotator = &sqs.KeyRotator{
+			Provider: &sqs.Provider{
+				Client:         fakeCfnClient,
+				Environment:    "test",
+				ResourcePrefix: "testprefix",
+			},
+			MaxAge:      30 * 24 * time.Hour,
+			GracePeriod: 24 * time.Hour,
+			Logger:      logger,
+			Now:         func() time.Time { return now },
+		}
+		bindingStack = &cloudformation.Stack{
+			StackName:    aws.String("testprefix-binding-guid"),
+			StackStatus:  aws.String(cloudformation.StackStatusCreateComplete),
+			CreationTime: aws.Time(now.Add(-60 * 24 * time.Hour)),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String(sqs.OutputCredentialsARN), OutputValue: aws.String("arn-secret")},
+			},
+		}
+		bindingKeys = sqs.AccessKeys{Current: sqs.AccessKey{Serial: 1}}
+		queueStack := &cloudformation.Stack{
+			StackName:   aws.String("testprefix-instance-guid"),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String(sqs.OutputPrimaryQueueARN), OutputValue: aws.String("arn-1")},
+				{OutputKey: aws.String(sqs.OutputPrimaryQueueURL), OutputValue: aws.String("https://queue-1")},
+			},
+		}
+		queueTemplate, err := (&sqs.QueueTemplateBuilder{
+			QueueName: "testprefix-instance-guid",
+		}).Build()
+		Expect(err).ToNot(HaveOccurred())
+
+		fakeCfnClient.DescribeStacksWithContextStub = func(ctx context.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+			if aws.StringValue(input.StackName) == aws.StringValue(bindingStack.StackName) {
+				return &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{bindingStack},
+				}, nil
+			}
+			if input.StackName != nil {
+				return &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{queueStack},
+				}, nil
+			}
+			return &cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{queueStack, bindingStack},
+			}, nil
+		}
+		fakeCfnClient.GetTemplateWithContextStub = func(ctx context.Context, input *cloudformation.GetTemplateInput, opts ...request.Option) (*cloudformation.GetTemplateOutput, error) {
+			if aws.StringValue(input.StackName) == "testprefix-instance-guid" {
+				return &cloudformation.GetTemplateOutput{TemplateBody: aws.String(queueTemplate)}, nil
+			}
+			bindingTemplate, err := (&sqs.UserTemplateBuilder{
+				BindingID:    "binding-guid",
+				AccessPolicy: sqs.AccessPolicyConsumer,
+				AccessKeys:   bindingKeys,
+				Tags: map[string]string{
+					sqs.TagServiceId:      "service-guid",
+					sqs.TagCostAllocation: "instance-guid",
+				},
+			}).Build()
+			return &cloudformation.GetTemplateOutput{TemplateBody: aws.String(bindingTemplate)}, err
+		}
+		fakeCfnClient.UpdateStackWithContextReturns(&cloudformation.UpdateStackOutput{}, nil)
+	})
+
+	JustBeforeEach(func() {
+		err = rotator.RotateKeys(context.Background())
+	})
+
+	Context("when a binding's access key is older than the maximum age", func() {
+		var before float64
+
+		BeforeEach(func() {
+			before = metricValue(sqs.RotationResultRotated)
+		})
+
+		It("adds a new key and keeps the old one active", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+			_, input, _ := fakeCfnClient.UpdateStackWithContextArgsForCall(0)
+			Expect(aws.StringValue(input.StackName)).To(Equal("testprefix-binding-guid"))
+
+			keys, err := sqs.ParseAccessKeys(*input.TemplateBody)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys.Current.Serial).To(Equal(2))
+			Expect(*keys.Current.CreatedAt).To(BeTemporally("==", now))
+			Expect(keys.Retiring.Serial).To(Equal(1))
+			Expect(*keys.Retiring.RetireAt).To(BeTemporally("==", now.Add(24*time.Hour)))
+
+			t, err := parseTemplate(*input.TemplateBody)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(t.Resources).To(HaveKey("IAMAccessKey"))
+			Expect(t.Resources).To(HaveKey("IAMAccessKey2"))
+			Expect(*input.TemplateBody).To(ContainSubstring("${IAMAccessKey2}"))
+		})
+
+		It("keeps the binding's access policy", func() {
+			_, input, _ := fakeCfnClient.UpdateStackWithContextArgsForCall(0)
+			params, err := sqs.ParseUserTemplateParams(*input.TemplateBody)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(params.AccessPolicy).To(Equal(sqs.AccessPolicyConsumer))
+		})
+
+		It("counts the rotation", func() {
+			Expect(metricValue(sqs.RotationResultRotated)).To(Equal(before + 1))
+		})
+	})
+
+	Context("when a binding's access key is younger than the maximum age", func() {
+		BeforeEach(func() {
+			createdAt := now.Add(-24 * time.Hour)
+			bindingKeys.Current.CreatedAt = &createdAt
+		})
+
+		It("leaves the binding alone", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(BeZero())
+		})
+	})
+
+	Context("when an old key is retiring", func() {
+		BeforeEach(func() {
+			createdAt := now.Add(-2 * time.Hour)
+			retireAt := now.Add(-time.Hour)
+			bindingKeys = sqs.AccessKeys{
+				Current:  sqs.AccessKey{Serial: 2, CreatedAt: &createdAt},
+				Retiring: &sqs.AccessKey{Serial: 1, RetireAt: &retireAt},
+			}
+		})
+
+		It("removes it once the grace period is over", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+			_, input, _ := fakeCfnClient.UpdateStackWithContextArgsForCall(0)
+			t, err := parseTemplate(*input.TemplateBody)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(t.Resources).ToNot(HaveKey("IAMAccessKey"))
+			Expect(t.Resources).To(HaveKey("IAMAccessKey2"))
+		})
+
+		Context("and the grace period is not over", func() {
+			BeforeEach(func() {
+				retireAt := now.Add(time.Hour)
+				bindingKeys.Retiring.RetireAt = &retireAt
+			})
+
+			It("keeps it", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(BeZero())
+			})
+		})
+	})
+
+	Context("when the binding has a role instead of a user", func() {
+		BeforeEach(func() {
+			rotator.Provider.RoleTrustedPrincipal = "arn:aws:iam::123456789012:role/apps"
+			stub := fakeCfnClient.GetTemplateWithContextStub
+			fakeCfnClient.GetTemplateWithContextStub = func(ctx context.Context, input *cloudformation.GetTemplateInput, opts ...request.Option) (*cloudformation.GetTemplateOutput, error) {
+				if aws.StringValue(input.StackName) == "testprefix-instance-guid" {
+					return stub(ctx, input, opts...)
+				}
+				bindingTemplate, err := (&sqs.UserTemplateBuilder{
+					BindingID:        "binding-guid",
+					Role:             &sqs.BindingRole{ExternalID: "external-id"},
+					TrustedPrincipal: rotator.Provider.RoleTrustedPrincipal,
+				}).Build()
+				return &cloudformation.GetTemplateOutput{TemplateBody: aws.String(bindingTemplate)}, err
+			}
+		})
+
+		It("has no keys to rotate", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(BeZero())
+		})
+	})
+
+	Context("when the binding's stack is being updated", func() {
+		BeforeEach(func() {
+			bindingStack.StackStatus = aws.String(cloudformation.StackStatusUpdateInProgress)
+		})
+
+		It("leaves it for the next run", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(BeZero())
+		})
+	})
+
+	Context("when the binding's template is outdated", func() {
+		BeforeEach(func() {
+			bindingStack.Tags = []*cloudformation.Tag{{
+				Key:   aws.String(sqs.TagTemplateVersion),
+				Value: aws.String("old-version"),
+			}}
+		})
+
+		It("logs that rotating the key upgrades the template", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+			Expect(log.String()).To(ContainSubstring("rotate-access-key-upgrades-template"))
+			Expect(log.String()).To(ContainSubstring(`"from-version":"old-version"`))
+			Expect(log.String()).To(ContainSubstring(`"to-version":"` + sqs.UserTemplateVersion + `"`))
+		})
+	})
+
+	Context("when another broker rotates the same binding at the same time", func() {
+		var (
+			other          *sqs.KeyRotator
+			listedStack    *cloudformation.Stack
+			seesOtherStart bool
+			rotatedBefore  float64
+			skippedBefore  float64
+			failedBefore   float64
+		)
+
+		BeforeEach(func() {
+			rotatedBefore = metricValue(sqs.RotationResultRotated)
+			skippedBefore = metricValue(sqs.RotationResultSkipped)
+			failedBefore = metricValue(sqs.RotationResultFailed)
+			seesOtherStart = false
+			// both brokers list the stack before either updates it
+			listedStack = &cloudformation.Stack{}
+			*listedStack = *bindingStack
+			describeStacks := fakeCfnClient.DescribeStacksWithContextStub
+			fakeCfnClient.DescribeStacksWithContextStub = func(ctx context.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+				if input.StackName == nil {
+					return &cloudformation.DescribeStacksOutput{
+						Stacks: []*cloudformation.Stack{listedStack},
+					}, nil
+				}
+				if !seesOtherStart && aws.StringValue(input.StackName) == aws.StringValue(bindingStack.StackName) {
+					return &cloudformation.DescribeStacksOutput{
+						Stacks: []*cloudformation.Stack{listedStack},
+					}, nil
+				}
+				return describeStacks(ctx, input, opts...)
+			}
+			// CloudFormation only lets one update of a stack run at once
+			fakeCfnClient.UpdateStackWithContextStub = func(ctx context.Context, input *cloudformation.UpdateStackInput, opts ...request.Option) (*cloudformation.UpdateStackOutput, error) {
+				if aws.StringValue(bindingStack.StackStatus) == cloudformation.StackStatusUpdateInProgress {
+					return nil, awserr.New("ValidationError", "Stack:arn:aws:cloudformation:eu-west-2:123456789012:stack/testprefix-binding-guid/id is in UPDATE_IN_PROGRESS state and can not be updated.", nil)
+				}
+				bindingStack.StackStatus = aws.String(cloudformation.StackStatusUpdateInProgress)
+				return &cloudformation.UpdateStackOutput{}, nil
+			}
+			other = &sqs.KeyRotator{
+				Provider: &sqs.Provider{
+					Client:         fakeCfnClient,
+					Environment:    "test",
+					ResourcePrefix: "testprefix",
+				},
+				MaxAge:      rotator.MaxAge,
+				GracePeriod: rotator.GracePeriod,
+				Logger:      rotator.Logger,
+				Now:         rotator.Now,
+			}
+		})
+
+		It("skips the binding when the other broker's update has already started", func() {
+			seesOtherStart = true
+			Expect(err).ToNot(HaveOccurred())
+			Expect(other.RotateKeys(context.Background())).To(Succeed())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+			Expect(metricValue(sqs.RotationResultRotated)).To(Equal(rotatedBefore + 1))
+			Expect(metricValue(sqs.RotationResultFailed)).To(Equal(failedBefore))
+		})
+
+		It("skips the binding when the other broker's update starts first", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(other.RotateKeys(context.Background())).To(Succeed())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(2))
+			Expect(metricValue(sqs.RotationResultRotated)).To(Equal(rotatedBefore + 1))
+			Expect(metricValue(sqs.RotationResultSkipped)).To(Equal(skippedBefore + 1))
+			Expect(metricValue(sqs.RotationResultFailed)).To(Equal(failedBefore))
+			Expect(log.String()).To(ContainSubstring("rotate-access-key-skipped"))
+		})
+	})
+
+	Context("when the update fails", func() {
+		var before float64
+
+		BeforeEach(func() {
+			before = metricValue(sqs.RotationResultFailed)
+			fakeCfnClient.UpdateStackWithContextReturns(nil, errors.New("boom"))
+		})
+
+		It("reports and counts the failure", func() {
+			Expect(err).To(MatchError("1 bindings failed to rotate access keys"))
+			Expect(metricValue(sqs.RotationResultFailed)).To(Equal(before + 1))
+		})
+	})
+})
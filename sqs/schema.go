@@ -0,0 +1,492 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pivotal-cf/brokerapi/domain"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"gopkg.in/yaml.v2"
+)
+
+// SchemaVersion is the JSON schema draft that OSBAPI schemas use.
+const SchemaVersion = "http://json-schema.org/draft-04/schema#"
+
+// stringParamLimits are the ranges of numeric parameters that are
+// String template parameters, so that they can be left empty, and
+// cannot have a MinValue and MaxValue.
+var stringParamLimits = map[string]ParamLimit{
+	ParamDeadLetterMessageRetentionPeriod: {Min: intRef(60), Max: intRef(1209600)},
+	ParamDeadLetterVisibilityTimeout:      {Min: intRef(0), Max: intRef(43200)},
+}
+
+// sharedParams apply to every queue in an instance, so are not in the
+// schema for named queues.
+var sharedParams = []string{
+	ParamKmsDataKeyReusePeriodSeconds,
+	ParamKmsMasterKeyId,
+	ParamSqsManagedSseEnabled,
+}
+
+var fifoOnlyParams = []string{
+	ParamContentBasedDeduplication,
+	ParamDeduplicationScope,
+	ParamFifoThroughputLimit,
+}
+
+type templateParameter struct {
+	AllowedValues []string    `yaml:"AllowedValues"`
+	Default       interface{} `yaml:"Default"`
+	Description   string      `yaml:"Description"`
+	MaxValue      *int        `yaml:"MaxValue"`
+	MinValue      *int        `yaml:"MinValue"`
+}
+
+var (
+	templateParametersOnce sync.Once
+	templateParameters     map[string]templateParameter
+	templateParametersErr  error
+)
+
+// getTemplateParameters returns the parameters declared by the queue
+// template, which the schema's descriptions and constraints are taken
+// from.
+func getTemplateParameters() (map[string]templateParameter, error) {
+	templateParametersOnce.Do(func() {
+		body, err := (&QueueTemplateBuilder{}).Build()
+		if err != nil {
+			templateParametersErr = err
+			return
+		}
+		var t struct {
+			Parameters map[string]templateParameter `yaml:"Parameters"`
+		}
+		templateParametersErr = yaml.Unmarshal([]byte(body), &t)
+		templateParameters = t.Parameters
+	})
+	return templateParameters, templateParametersErr
+}
+
+// templateDefaults returns the numeric parameters that the queue
+// template gives a default, which is what a queue gets when neither
+// the user nor the plan sets them.
+func templateDefaults() (QueueParams, error) {
+	params := QueueParams{}
+	parameters, err := getTemplateParameters()
+	if err != nil {
+		return params, err
+	}
+	v := reflect.ValueOf(&params).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if n, ok := parameters[v.Type().Field(i).Name].Default.(int); ok && v.Field(i).Type().Elem().Kind() == reflect.Int {
+			v.Field(i).Set(reflect.ValueOf(&n))
+		}
+	}
+	return params, nil
+}
+
+// PlanSchemas returns the OSBAPI schemas for the plan's instance and
+// binding parameters.
+func PlanSchemas(plan domain.ServicePlan, accessPolicies map[string][]string) (*domain.ServiceSchemas, error) {
+	planConfig, err := NewPlanConfig(plan)
+	if err != nil {
+		return nil, err
+	}
+	create, err := InstanceSchema(planConfig, false)
+	if err != nil {
+		return nil, err
+	}
+	update, err := InstanceSchema(planConfig, true)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.ServiceSchemas{
+		Instance: domain.ServiceInstanceSchema{
+			Create: domain.Schema{Parameters: create},
+			Update: domain.Schema{Parameters: update},
+		},
+		Binding: domain.ServiceBindingSchema{
+			Create: domain.Schema{Parameters: BindingSchema(planConfig.AccessPolicyNames(accessPolicies))},
+		},
+	}, nil
+}
+
+// InstanceSchema returns the JSON schema for the parameters of an
+// instance of the plan.  Updates may set named queues to null to
+// remove them.
+func InstanceSchema(planConfig *PlanConfig, update bool) (map[string]interface{}, error) {
+	properties, err := queueParamsSchema(planConfig, true)
+	if err != nil {
+		return nil, err
+	}
+	namedQueueProperties, err := queueParamsSchema(planConfig, false)
+	if err != nil {
+		return nil, err
+	}
+	namedQueue := map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           namedQueueProperties,
+	}
+	if update {
+		namedQueue["type"] = []interface{}{"object", "null"}
+	}
+	stringList := func(description, pattern string) map[string]interface{} {
+		items := map[string]interface{}{"type": "string"}
+		if pattern != "" {
+			items["pattern"] = pattern
+		}
+		return map[string]interface{}{
+			"type":        "array",
+			"description": description,
+			"items":       items,
+		}
+	}
+	properties["sns_subscriptions"] = map[string]interface{}{
+		"type":        "array",
+		"description": "SNS topics to subscribe the primary queue to. On update this replaces the existing subscriptions.",
+		"items": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": false,
+			"required":             []interface{}{"topic_arn"},
+			"properties": map[string]interface{}{
+				"topic_arn": map[string]interface{}{
+					"type":    "string",
+					"pattern": snsTopicARNPattern.String(),
+				},
+				"raw_message_delivery": map[string]interface{}{"type": "boolean"},
+				"filter_policy":        map[string]interface{}{"type": "object"},
+			},
+		},
+	}
+	properties["queue_policy"] = map[string]interface{}{
+		"type":                 "object",
+		"description":          "AWS services and accounts allowed to send messages to the primary queue. On update this replaces the existing policy.",
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"service_principals": stringList("AWS service principals that may send messages", servicePrincipalPattern.String()),
+			"source_arns":        stringList("resources the services may send on behalf of", sourceARNPattern.String()),
+			"source_accounts":    stringList("accounts the services may send on behalf of", accountIDPattern.String()),
+			"account_ids":        stringList("other accounts that may send messages", accountIDPattern.String()),
+		},
+	}
+	properties["queues"] = map[string]interface{}{
+		"type":                 "object",
+		"description":          "Named queues to create alongside the default queue.",
+		"maxProperties":        MaxNamedQueues,
+		"additionalProperties": false,
+		"patternProperties": map[string]interface{}{
+			queueNamePattern.String(): namedQueue,
+		},
+	}
+	return map[string]interface{}{
+		"$schema":              SchemaVersion,
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           properties,
+	}, nil
+}
+
+// queueParamsSchema returns the schemas for the fields of QueueParams,
+// taking descriptions and constraints from the queue template and
+// narrowing them to the plan's limits.
+func queueParamsSchema(planConfig *PlanConfig, shared bool) (map[string]interface{}, error) {
+	parameters, err := getTemplateParameters()
+	if err != nil {
+		return nil, err
+	}
+	properties := map[string]interface{}{}
+	t := reflect.TypeOf(QueueParams{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !shared && contains(sharedParams, field.Name) {
+			continue
+		}
+		if !planConfig.IsFIFO() && contains(fifoOnlyParams, field.Name) {
+			continue
+		}
+		parameter, ok := parameters[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("queue template has no %s parameter", field.Name)
+		}
+		property := map[string]interface{}{
+			"description": strings.Join(strings.Fields(parameter.Description), " "),
+		}
+		switch field.Type.Elem().Kind() {
+		case reflect.Int:
+			property["type"] = "integer"
+			limit := ParamLimit{Min: parameter.MinValue, Max: parameter.MaxValue}
+			if l, ok := stringParamLimits[field.Name]; ok {
+				limit = l
+			}
+			limit = limit.narrow(planConfig.Limits[jsonName(field)])
+			if limit.Min != nil {
+				property["minimum"] = *limit.Min
+			}
+			if limit.Max != nil {
+				property["maximum"] = *limit.Max
+			}
+		case reflect.Bool:
+			property["type"] = "boolean"
+		case reflect.String:
+			property["type"] = "string"
+			enum := []interface{}{}
+			for _, value := range parameter.AllowedValues {
+				if value != "" {
+					enum = append(enum, value)
+				}
+			}
+			if len(enum) > 0 {
+				property["enum"] = enum
+			}
+		}
+		properties[jsonName(field)] = property
+	}
+	return properties, nil
+}
+
+// narrow returns the intersection of two limits.
+func (l ParamLimit) narrow(other ParamLimit) ParamLimit {
+	if other.Min != nil && (l.Min == nil || *other.Min > *l.Min) {
+		l.Min = other.Min
+	}
+	if other.Max != nil && (l.Max == nil || *other.Max < *l.Max) {
+		l.Max = other.Max
+	}
+	return l
+}
+
+// BindingSchema returns the JSON schema for binding parameters, which
+// may use the named access policies.
+func BindingSchema(accessPolicyNames []string) map[string]interface{} {
+	accessPolicies := []interface{}{}
+	for _, name := range accessPolicyNames {
+		accessPolicies = append(accessPolicies, name)
+	}
+	return map[string]interface{}{
+		"$schema":              SchemaVersion,
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"access_policy": map[string]interface{}{
+				"type":        "string",
+				"description": "The permissions the binding has on the queues. Defaults to full.",
+				"enum":        accessPolicies,
+			},
+			"dead_letter_access_policy": map[string]interface{}{
+				"type":        "string",
+				"description": "The permissions the binding has on the dead-letter queues. Defaults to the access_policy.",
+				"enum":        accessPolicies,
+			},
+			"allowed_source_ips": map[string]interface{}{
+				"type":        "array",
+				"description": "CIDR ranges the binding may be used from.",
+				"items": map[string]interface{}{
+					"type":    "string",
+					"pattern": `^[0-9a-fA-F.:]+/[0-9]+$`,
+				},
+			},
+			"allowed_source_vpcs": map[string]interface{}{
+				"type":        "array",
+				"description": "IDs of VPCs the binding may be used from through a VPC endpoint.",
+				"items": map[string]interface{}{
+					"type":    "string",
+					"pattern": `^vpc-[0-9a-f]+$`,
+				},
+			},
+			"allowed_source_vpc_endpoints": map[string]interface{}{
+				"type":        "array",
+				"description": "IDs of VPC endpoints the binding may be used through.",
+				"items": map[string]interface{}{
+					"type":    "string",
+					"pattern": `^vpce-[0-9a-f]+$`,
+				},
+			},
+		},
+	}
+}
+
+// ValidateParameters checks raw parameters against a schema, returning
+// a 400 failure response listing every invalid field.
+func ValidateParameters(schema map[string]interface{}, raw json.RawMessage) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return apiresponses.NewFailureResponse(err, http.StatusBadRequest, "bad-json-format")
+	}
+	problems := validateSchema(schema, value, "")
+	if len(problems) == 0 {
+		return nil
+	}
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("%s", strings.Join(problems, "; ")),
+		http.StatusBadRequest,
+		"invalid-parameter",
+	)
+}
+
+// validateSchema implements the parts of JSON schema that the
+// broker's schemas use.
+func validateSchema(schema map[string]interface{}, value interface{}, path string) []string {
+	name := path
+	if name == "" {
+		name = "parameters"
+	}
+	if types, ok := schema["type"]; ok {
+		allowed := []string{}
+		switch t := types.(type) {
+		case string:
+			allowed = append(allowed, t)
+		case []interface{}:
+			for _, v := range t {
+				allowed = append(allowed, v.(string))
+			}
+		}
+		matched := false
+		for _, t := range allowed {
+			if hasSchemaType(value, t) {
+				matched = true
+			}
+		}
+		if !matched {
+			return []string{fmt.Sprintf("%s must be %s", name, describeTypes(allowed))}
+		}
+	}
+	problems := []string{}
+	switch v := value.(type) {
+	case float64:
+		if min, ok := schema["minimum"].(int); ok && v < float64(min) {
+			problems = append(problems, fmt.Sprintf("%s must be %s", name, schemaLimit(schema)))
+		} else if max, ok := schema["maximum"].(int); ok && v > float64(max) {
+			problems = append(problems, fmt.Sprintf("%s must be %s", name, schemaLimit(schema)))
+		}
+	case string:
+		if enum, ok := schema["enum"].([]interface{}); ok {
+			found := false
+			quoted := []string{}
+			for _, e := range enum {
+				found = found || e == v
+				quoted = append(quoted, fmt.Sprintf("%#v", e))
+			}
+			if !found {
+				problems = append(problems, fmt.Sprintf("%s must be one of %s", name, strings.Join(quoted, ", ")))
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(v) {
+			problems = append(problems, fmt.Sprintf("%s %#v is not valid", name, v))
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				problems = append(problems, validateSchema(items, item, fmt.Sprintf("%s[%d]", name, i))...)
+			}
+		}
+	case map[string]interface{}:
+		if max, ok := schema["maxProperties"].(int); ok && len(v) > max {
+			problems = append(problems, fmt.Sprintf("%s must have at most %d entries", name, max))
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := v[r.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s is required", joinPath(path, r.(string))))
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		patternProperties, _ := schema["patternProperties"].(map[string]interface{})
+		keys := []string{}
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				problems = append(problems, validateSchema(property, v[key], joinPath(path, key))...)
+				continue
+			}
+			matched := false
+			for pattern, property := range patternProperties {
+				if regexp.MustCompile(pattern).MatchString(key) {
+					matched = true
+					problems = append(problems, validateSchema(property.(map[string]interface{}), v[key], joinPath(path, key))...)
+				}
+			}
+			if !matched && schema["additionalProperties"] == false {
+				problems = append(problems, fmt.Sprintf("%s is not a known parameter", joinPath(path, key)))
+			}
+		}
+	}
+	return problems
+}
+
+func hasSchemaType(value interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+func describeTypes(types []string) string {
+	described := []string{}
+	for _, t := range types {
+		switch t {
+		case "object", "array", "integer":
+			described = append(described, "an "+t)
+		case "null":
+			described = append(described, t)
+		default:
+			described = append(described, "a "+t)
+		}
+	}
+	return strings.Join(described, " or ")
+}
+
+func schemaLimit(schema map[string]interface{}) string {
+	limit := ParamLimit{}
+	if min, ok := schema["minimum"].(int); ok {
+		limit.Min = &min
+	}
+	if max, ok := schema["maximum"].(int); ok {
+		limit.Max = &max
+	}
+	return limit.String()
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func intRef(i int) *int {
+	return &i
+}
@@ -0,0 +1,183 @@
+package sqs_test
+
+import (
+	"encoding/json"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain"
+)
+
+var _ = Describe("Schemas", func() {
+	var plan domain.ServicePlan
+
+	BeforeEach(func() {
+		plan = domain.ServicePlan{
+			ID:   "uuid-2",
+			Name: "standard",
+		}
+	})
+
+	property := func(schema map[string]interface{}, path ...string) map[string]interface{} {
+		for _, name := range path {
+			schema = schema["properties"].(map[string]interface{})[name].(map[string]interface{})
+		}
+		return schema
+	}
+
+	It("should publish schemas for instances and bindings", func() {
+		schemas, err := sqs.PlanSchemas(plan, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(schemas.Instance.Create.Parameters).To(HaveKeyWithValue("$schema", sqs.SchemaVersion))
+		Expect(schemas.Instance.Update.Parameters).To(HaveKeyWithValue("$schema", sqs.SchemaVersion))
+		Expect(property(schemas.Binding.Create.Parameters, "access_policy")).To(HaveKeyWithValue("enum", ConsistOf(
+			sqs.AccessPolicyFull, sqs.AccessPolicyProducer, sqs.AccessPolicyConsumer, sqs.AccessPolicyMonitor, sqs.AccessPolicyNone,
+		)))
+		Expect(property(schemas.Binding.Create.Parameters, "dead_letter_access_policy")).To(HaveKey("enum"))
+	})
+
+	It("should only offer the access policies available on the plan", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"access_policies": []interface{}{"producer", "redrive"},
+			},
+		}
+		schemas, err := sqs.PlanSchemas(plan, map[string][]string{
+			"redrive": {"sqs:StartMessageMoveTask"},
+			"peek":    {"sqs:ReceiveMessage"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(property(schemas.Binding.Create.Parameters, "access_policy")).To(HaveKeyWithValue("enum", Equal([]interface{}{
+			sqs.AccessPolicyProducer, "redrive",
+		})))
+	})
+
+	It("should take limits and descriptions from the queue template", func() {
+		config, err := sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		schema, err := sqs.InstanceSchema(config, false)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(property(schema, "maximum_message_size")).To(And(
+			HaveKeyWithValue("type", "integer"),
+			HaveKeyWithValue("minimum", 1024),
+			HaveKeyWithValue("maximum", 262144),
+			HaveKeyWithValue("description", ContainSubstring("how many bytes")),
+		))
+		Expect(property(schema, "dead_letter_visibility_timeout")).To(And(
+			HaveKeyWithValue("minimum", 0),
+			HaveKeyWithValue("maximum", 43200),
+		))
+		Expect(property(schema, "dead_letter_queue")).To(HaveKeyWithValue("type", "boolean"))
+	})
+
+	It("should only include FIFO parameters for FIFO plans", func() {
+		config, err := sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		schema, err := sqs.InstanceSchema(config, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(schema["properties"]).ToNot(HaveKey("deduplication_scope"))
+
+		plan.Name = "fifo"
+		config, err = sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		schema, err = sqs.InstanceSchema(config, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(property(schema, "deduplication_scope")).To(HaveKeyWithValue("enum", ConsistOf("queue", "messageGroup")))
+	})
+
+	It("should narrow limits to those of the plan", func() {
+		plan.Metadata = &domain.ServicePlanMetadata{
+			AdditionalMetadata: map[string]interface{}{
+				"queue_defaults": map[string]interface{}{
+					"message_retention_period": 3600,
+				},
+				"queue_limits": map[string]interface{}{
+					"message_retention_period": map[string]interface{}{"max": 86400},
+				},
+			},
+		}
+		config, err := sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		schema, err := sqs.InstanceSchema(config, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(property(schema, "message_retention_period")).To(And(
+			HaveKeyWithValue("minimum", 60),
+			HaveKeyWithValue("maximum", 86400),
+		))
+	})
+
+	It("should leave instance-wide parameters out of named queues", func() {
+		config, err := sqs.NewPlanConfig(plan)
+		Expect(err).ToNot(HaveOccurred())
+		schema, err := sqs.InstanceSchema(config, true)
+		Expect(err).ToNot(HaveOccurred())
+		queues := property(schema, "queues")
+		Expect(queues).To(HaveKeyWithValue("maxProperties", sqs.MaxNamedQueues))
+		namedQueue := queues["patternProperties"].(map[string]interface{})
+		Expect(namedQueue).To(HaveLen(1))
+		for _, queue := range namedQueue {
+			Expect(queue).To(HaveKeyWithValue("type", ConsistOf("object", "null")))
+			Expect(queue.(map[string]interface{})["properties"]).To(HaveKey("delay_seconds"))
+			Expect(queue.(map[string]interface{})["properties"]).ToNot(HaveKey("kms_master_key_id"))
+		}
+	})
+
+	Describe("ValidateParameters", func() {
+		var schema map[string]interface{}
+
+		BeforeEach(func() {
+			config, err := sqs.NewPlanConfig(plan)
+			Expect(err).ToNot(HaveOccurred())
+			schema, err = sqs.InstanceSchema(config, false)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should accept valid parameters", func() {
+			Expect(sqs.ValidateParameters(schema, json.RawMessage(`{
+				"delay_seconds": 10,
+				"dead_letter_queue": true,
+				"sns_subscriptions": [{"topic_arn": "arn:aws:sns:eu-west-2:123456789012:topic"}],
+				"queues": {"orders": {"visibility_timeout": 60}}
+			}`))).To(Succeed())
+		})
+
+		It("should accept empty parameters", func() {
+			Expect(sqs.ValidateParameters(schema, nil)).To(Succeed())
+		})
+
+		It("should reject invalid fields with a 400 naming each of them", func() {
+			err := sqs.ValidateParameters(schema, json.RawMessage(`{
+				"delay_seconds": 901,
+				"dead_letter_queue": "yes",
+				"sns_subscriptions": [{"topic_arn": "topic"}],
+				"queues": {"orders": {"visibility_timeout": -1, "mango": 1}}
+			}`))
+			Expect(err).To(MatchError(
+				`dead_letter_queue must be a boolean; ` +
+					`delay_seconds must be between 0 and 900; ` +
+					`queues.orders.mango is not a known parameter; ` +
+					`queues.orders.visibility_timeout must be between 0 and 43200; ` +
+					`sns_subscriptions[0].topic_arn "topic" is not valid`,
+			))
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+		})
+
+		It("should reject too many named queues", func() {
+			err := sqs.ValidateParameters(schema, json.RawMessage(`{"queues": {
+				"a": {}, "b": {}, "c": {}, "d": {}, "e": {}, "f": {},
+				"g": {}, "h": {}, "i": {}, "j": {}, "k": {}
+			}}`))
+			Expect(err).To(MatchError("queues must have at most 10 entries"))
+		})
+
+		It("should reject unknown binding parameters", func() {
+			err := sqs.ValidateParameters(sqs.BindingSchema(sqs.BuiltinAccessPolicies), json.RawMessage(`{"access_policy": "everything"}`))
+			Expect(err).To(MatchError(`access_policy must be one of "full", "producer", "consumer", "monitor", "none"`))
+		})
+	})
+})
@@ -1,8 +1,12 @@
 package sqs_test
 
 import (
+	"encoding/json"
 	"testing"
 
+	goformation "github.com/awslabs/goformation/v4"
+	"github.com/awslabs/goformation/v4/cloudformation"
+	"github.com/awslabs/goformation/v4/intrinsics"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -11,3 +15,44 @@ func TestSQS(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "SQS Suite")
 }
+
+// unsupportedProperties lists resource properties that are valid in
+// CloudFormation but unknown to the version of goformation we use.
+// goformation refuses to parse resources with unknown properties, so
+// they are removed before parsing and must be asserted on separately.
+var unsupportedProperties = map[string][]string{
+	"AWS::SQS::Queue": {
+		"DeduplicationScope",
+		"FifoThroughputLimit",
+		"SqsManagedSseEnabled",
+	},
+}
+
+// parseTemplate is a replacement for goformation.ParseYAML that copes
+// with properties listed in unsupportedProperties.
+func parseTemplate(text string) (*cloudformation.Template, error) {
+	processed, err := intrinsics.ProcessYAML([]byte(text), &intrinsics.ProcessorOptions{
+		NoProcess: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(processed, &raw); err != nil {
+		return nil, err
+	}
+	resources, _ := raw["Resources"].(map[string]interface{})
+	for _, r := range resources {
+		resource, _ := r.(map[string]interface{})
+		resourceType, _ := resource["Type"].(string)
+		properties, _ := resource["Properties"].(map[string]interface{})
+		for _, name := range unsupportedProperties[resourceType] {
+			delete(properties, name)
+		}
+	}
+	stripped, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return goformation.ParseJSON(stripped)
+}
@@ -0,0 +1,176 @@
+package sqs
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alphagov/paas-sqs-broker/metrics"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const (
+	StackCacheHit    = "hit"
+	StackCacheShared = "shared"
+	StackCacheMiss   = "miss"
+)
+
+// StackCacheLookups counts the lookups of single stacks made through a
+// StackCache, by whether they were answered from the cache, by sharing
+// a lookup already in flight, or by CloudFormation.
+var StackCacheLookups = metrics.NewCounter(
+	"sqs_broker_stack_cache_lookups_total",
+	"Stack lookups answered from the cache (hit), by a lookup already in flight (shared) or by CloudFormation (miss).",
+	"result",
+)
+
+// StackCache is a Client that caches the state of stacks looked up by
+// name, so that polling for the progress of operations makes fewer
+// DescribeStacks calls.  Concurrent lookups of the same stack share a
+// single call.  Stacks with an operation in progress are cached for
+// InProgressTTL.
+//
+// Stacks in a terminal state are not cached.  The cache is kept by each
+// broker process, and a cached terminal state could be from before
+// another broker started an operation, which would then be reported as
+// already finished.  Creating, updating or deleting a stack through the
+// cache forgets its state.  Listing stacks is not cached.
+type StackCache struct {
+	Client
+	InProgressTTL time.Duration
+	// Now returns the current time.  Defaults to time.Now.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*stackCacheEntry
+}
+
+type stackCacheEntry struct {
+	output  *cloudformation.DescribeStacksOutput
+	expires time.Time
+	call    *stackLookup
+}
+
+// stackLookup is a DescribeStacks call that other lookups of the same
+// stack can wait for.
+type stackLookup struct {
+	done   chan struct{}
+	output *cloudformation.DescribeStacksOutput
+	err    error
+}
+
+func NewStackCache(client Client, inProgressTTL time.Duration) *StackCache {
+	return &StackCache{
+		Client:        client,
+		InProgressTTL: inProgressTTL,
+	}
+}
+
+func (c *StackCache) DescribeStacksWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+	if input.StackName == nil || input.NextToken != nil {
+		return c.Client.DescribeStacksWithContext(ctx, input, opts...)
+	}
+	name := aws.StringValue(input.StackName)
+
+	c.mu.Lock()
+	entry := c.entry(name)
+	if entry.output != nil && c.now().Before(entry.expires) {
+		output := entry.output
+		c.mu.Unlock()
+		StackCacheLookups.Inc(StackCacheHit)
+		return output, nil
+	}
+	if call := entry.call; call != nil {
+		c.mu.Unlock()
+		StackCacheLookups.Inc(StackCacheShared)
+		select {
+		case <-call.done:
+			return call.output, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &stackLookup{done: make(chan struct{})}
+	entry.call = call
+	c.mu.Unlock()
+
+	StackCacheLookups.Inc(StackCacheMiss)
+	call.output, call.err = c.Client.DescribeStacksWithContext(ctx, input, opts...)
+
+	c.mu.Lock()
+	// the entry is gone if the stack was changed during the lookup,
+	// in which case what was found may already be out of date
+	if c.entries[name] == entry {
+		entry.call = nil
+		if ttl := c.ttl(call.output); call.err == nil && ttl > 0 {
+			entry.output = call.output
+			entry.expires = c.now().Add(ttl)
+		} else if entry.output == nil {
+			delete(c.entries, name)
+		}
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.output, call.err
+}
+
+func (c *StackCache) CreateStackWithContext(ctx aws.Context, input *cloudformation.CreateStackInput, opts ...request.Option) (*cloudformation.CreateStackOutput, error) {
+	defer c.forget(aws.StringValue(input.StackName))
+	return c.Client.CreateStackWithContext(ctx, input, opts...)
+}
+
+func (c *StackCache) UpdateStackWithContext(ctx aws.Context, input *cloudformation.UpdateStackInput, opts ...request.Option) (*cloudformation.UpdateStackOutput, error) {
+	defer c.forget(aws.StringValue(input.StackName))
+	return c.Client.UpdateStackWithContext(ctx, input, opts...)
+}
+
+func (c *StackCache) DeleteStackWithContext(ctx aws.Context, input *cloudformation.DeleteStackInput, opts ...request.Option) (*cloudformation.DeleteStackOutput, error) {
+	defer c.forget(aws.StringValue(input.StackName))
+	return c.Client.DeleteStackWithContext(ctx, input, opts...)
+}
+
+// forget drops the cached state of a stack, and stops lookups already
+// in flight from caching what they find.
+func (c *StackCache) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// entry returns the cache entry for a stack, creating it if needed.
+// c.mu must be held.
+func (c *StackCache) entry(name string) *stackCacheEntry {
+	if c.entries == nil {
+		c.entries = map[string]*stackCacheEntry{}
+	}
+	entry, ok := c.entries[name]
+	if !ok {
+		entry = &stackCacheEntry{}
+		c.entries[name] = entry
+	}
+	return entry
+}
+
+// ttl returns how long the stacks in output can be cached for, which
+// is InProgressTTL if any of them has an operation in progress, and
+// nothing if they are all in a terminal state.
+func (c *StackCache) ttl(output *cloudformation.DescribeStacksOutput) time.Duration {
+	if output == nil {
+		return 0
+	}
+	for _, stack := range output.Stacks {
+		if strings.HasSuffix(aws.StringValue(stack.StackStatus), "_IN_PROGRESS") {
+			return c.InProgressTTL
+		}
+	}
+	return 0
+}
+
+func (c *StackCache) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
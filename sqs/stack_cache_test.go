@@ -0,0 +1,155 @@
+package sqs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	fakeClient "github.com/alphagov/paas-sqs-broker/sqs/fakes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+var _ = Describe("StackCache", func() {
+	var (
+		fakeCfnClient *fakeClient.FakeClient
+		cache         *sqs.StackCache
+		now           time.Time
+		status        string
+	)
+
+	describe := func(name string) (*cloudformation.DescribeStacksOutput, error) {
+		return cache.DescribeStacksWithContext(context.Background(), &cloudformation.DescribeStacksInput{
+			StackName: aws.String(name),
+		})
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		status = cloudformation.StackStatusUpdateInProgress
+		fakeCfnClient = &fakeClient.FakeClient{}
+		fakeCfnClient.DescribeStacksWithContextStub = func(ctx context.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+			return &cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{
+					{StackName: input.StackName, StackStatus: aws.String(status)},
+				},
+			}, nil
+		}
+		cache = sqs.NewStackCache(fakeCfnClient, 2*time.Second)
+		cache.Now = func() time.Time { return now }
+	})
+
+	It("answers repeated lookups of a stack from the cache", func() {
+		hits := sqs.StackCacheLookups.Value(sqs.StackCacheHit)
+		misses := sqs.StackCacheLookups.Value(sqs.StackCacheMiss)
+
+		first, err := describe("stack-1")
+		Expect(err).ToNot(HaveOccurred())
+		second, err := describe("stack-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(second).To(Equal(first))
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(1))
+		Expect(sqs.StackCacheLookups.Value(sqs.StackCacheHit)).To(Equal(hits + 1))
+		Expect(sqs.StackCacheLookups.Value(sqs.StackCacheMiss)).To(Equal(misses + 1))
+	})
+
+	It("caches each stack separately", func() {
+		describe("stack-1")
+		output, err := describe("stack-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output.Stacks[0].StackName).To(Equal(aws.String("stack-2")))
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+	})
+
+	It("does not cache stacks in a terminal state", func() {
+		status = cloudformation.StackStatusUpdateComplete
+		describe("stack-1")
+		status = cloudformation.StackStatusUpdateInProgress
+		output, err := describe("stack-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output.Stacks[0].StackStatus).To(Equal(aws.String(cloudformation.StackStatusUpdateInProgress)))
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+	})
+
+	It("caches stacks with an operation in progress for the in-progress TTL", func() {
+		describe("stack-1")
+		now = now.Add(time.Second)
+		describe("stack-1")
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(1))
+		now = now.Add(time.Second)
+		describe("stack-1")
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+	})
+
+	It("does not cache errors", func() {
+		fakeCfnClient.DescribeStacksWithContextStub = nil
+		fakeCfnClient.DescribeStacksWithContextReturns(nil, errors.New("throttled"))
+		_, err := describe("stack-1")
+		Expect(err).To(MatchError("throttled"))
+		_, err = describe("stack-1")
+		Expect(err).To(MatchError("throttled"))
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+	})
+
+	It("does not cache listing stacks", func() {
+		input := &cloudformation.DescribeStacksInput{}
+		cache.DescribeStacksWithContext(context.Background(), input)
+		cache.DescribeStacksWithContext(context.Background(), input)
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+	})
+
+	It("forgets a stack when it is created, updated or deleted", func() {
+		ctx := context.Background()
+		describe("stack-1")
+		cache.CreateStackWithContext(ctx, &cloudformation.CreateStackInput{StackName: aws.String("stack-1")})
+		describe("stack-1")
+		cache.UpdateStackWithContext(ctx, &cloudformation.UpdateStackInput{StackName: aws.String("stack-1")})
+		describe("stack-1")
+		cache.DeleteStackWithContext(ctx, &cloudformation.DeleteStackInput{StackName: aws.String("stack-1")})
+		describe("stack-1")
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(4))
+		Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(1))
+		Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+		Expect(fakeCfnClient.DeleteStackWithContextCallCount()).To(Equal(1))
+	})
+
+	It("shares a lookup between concurrent callers", func() {
+		release := make(chan struct{})
+		stub := fakeCfnClient.DescribeStacksWithContextStub
+		fakeCfnClient.DescribeStacksWithContextStub = func(ctx context.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+			<-release
+			return stub(ctx, input, opts...)
+		}
+		shared := sqs.StackCacheLookups.Value(sqs.StackCacheShared)
+
+		var wg sync.WaitGroup
+		outputs := make([]*cloudformation.DescribeStacksOutput, 5)
+		for i := range outputs {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				output, err := describe("stack-1")
+				Expect(err).ToNot(HaveOccurred())
+				outputs[i] = output
+			}(i)
+		}
+		Eventually(func() float64 {
+			return sqs.StackCacheLookups.Value(sqs.StackCacheShared)
+		}).Should(Equal(shared + 4))
+		close(release)
+		wg.Wait()
+
+		Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(1))
+		for _, output := range outputs {
+			Expect(output).To(Equal(outputs[0]))
+		}
+	})
+})
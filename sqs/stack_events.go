@@ -0,0 +1,143 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/pivotal-cf/brokerapi/domain"
+)
+
+const (
+	// maxStackEventPages is the most pages of stack events read when
+	// looking for why an operation failed
+	maxStackEventPages = 5
+	// maxFailureReasonLength is the longest failure reason given to
+	// users
+	maxFailureReasonLength = 300
+)
+
+var (
+	accountIDInTextPattern = regexp.MustCompile(`\b\d{12}\b`)
+	accessKeyIDPattern     = regexp.MustCompile(`\b(AKIA|ASIA)[A-Z0-9]{16}\b`)
+	secretAccessKeyPattern = regexp.MustCompile(`\b[A-Za-z0-9/+]{40}\b`)
+)
+
+// failedOperation returns the last operation of a stack that has
+// failed, describing why its first resource to fail did so.  Users
+// get a sanitised reason, and the full details are logged.
+func (s *Provider) failedOperation(ctx context.Context, stack *cloudformation.Stack) *domain.LastOperation {
+	status := aws.StringValue(stack.StackStatus)
+	description := fmt.Sprintf("failed: %s", status)
+
+	event, err := s.firstFailedStackEvent(ctx, stack)
+	if err != nil {
+		s.Logger.Error("describe-stack-events", err, lager.Data{
+			"stack": aws.StringValue(stack.StackName),
+		})
+	}
+	data := lager.Data{
+		"stack":         aws.StringValue(stack.StackName),
+		"status":        status,
+		"status-reason": aws.StringValue(stack.StackStatusReason),
+	}
+	reason := aws.StringValue(stack.StackStatusReason)
+	if event != nil {
+		data["resource"] = aws.StringValue(event.LogicalResourceId)
+		data["resource-type"] = aws.StringValue(event.ResourceType)
+		data["resource-status"] = aws.StringValue(event.ResourceStatus)
+		data["resource-status-reason"] = aws.StringValue(event.ResourceStatusReason)
+		reason = fmt.Sprintf("%s: %s", aws.StringValue(event.LogicalResourceId), aws.StringValue(event.ResourceStatusReason))
+	}
+	s.Logger.Error("stack-failed", fmt.Errorf("stack is %s", status), data)
+
+	if reason != "" {
+		description = fmt.Sprintf("%s: %s", description, sanitiseFailureReason(reason))
+	}
+	return &domain.LastOperation{
+		State:       domain.Failed,
+		Description: description,
+	}
+}
+
+// firstFailedStackEvent returns the earliest event of the stack's last
+// operation in which a resource failed, or nil if there is none.
+// Events are listed newest first, so it reads back to the event that
+// started the operation.
+func (s *Provider) firstFailedStackEvent(ctx context.Context, stack *cloudformation.Stack) (*cloudformation.StackEvent, error) {
+	var first *cloudformation.StackEvent
+	var nextToken *string
+	for page := 0; page < maxStackEventPages; page++ {
+		output, err := s.Client.DescribeStackEventsWithContext(ctx, &cloudformation.DescribeStackEventsInput{
+			StackName: stack.StackName,
+			NextToken: nextToken,
+		})
+		if err != nil || output == nil {
+			return first, err
+		}
+		for _, event := range output.StackEvents {
+			if isStackOperationStart(stack, event) {
+				return first, nil
+			}
+			if isStackEvent(stack, event) || aws.StringValue(event.ResourceStatusReason) == "" {
+				continue
+			}
+			if strings.HasSuffix(aws.StringValue(event.ResourceStatus), "_FAILED") && !isCancellation(event) {
+				first = event
+			}
+		}
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+	return first, nil
+}
+
+// isStackEvent reports whether event is about the stack itself rather
+// than one of its resources.
+func isStackEvent(stack *cloudformation.Stack, event *cloudformation.StackEvent) bool {
+	return aws.StringValue(event.ResourceType) == "AWS::CloudFormation::Stack" &&
+		aws.StringValue(event.LogicalResourceId) == aws.StringValue(stack.StackName)
+}
+
+// isStackOperationStart reports whether event is the start of an
+// operation on the stack.  Rollbacks are part of the operation that
+// failed.
+func isStackOperationStart(stack *cloudformation.Stack, event *cloudformation.StackEvent) bool {
+	if !isStackEvent(stack, event) {
+		return false
+	}
+	switch aws.StringValue(event.ResourceStatus) {
+	case cloudformation.ResourceStatusCreateInProgress,
+		cloudformation.ResourceStatusUpdateInProgress,
+		cloudformation.ResourceStatusDeleteInProgress:
+		return true
+	}
+	return false
+}
+
+// isCancellation reports whether event is a resource whose change was
+// cancelled because another resource failed.
+func isCancellation(event *cloudformation.StackEvent) bool {
+	reason := aws.StringValue(event.ResourceStatusReason)
+	return strings.HasPrefix(reason, "Resource creation cancelled") ||
+		strings.HasPrefix(reason, "Resource update cancelled")
+}
+
+// sanitiseFailureReason removes account IDs and anything that looks
+// like an AWS credential from a failure reason, and shortens it.
+func sanitiseFailureReason(reason string) string {
+	reason = accessKeyIDPattern.ReplaceAllString(reason, "[access-key-id]")
+	reason = secretAccessKeyPattern.ReplaceAllString(reason, "[redacted]")
+	reason = accountIDInTextPattern.ReplaceAllString(reason, "[account-id]")
+	reason = strings.Join(strings.Fields(reason), " ")
+	if runes := []rune(reason); len(runes) > maxFailureReasonLength {
+		reason = string(runes[:maxFailureReasonLength-3]) + "..."
+	}
+	return reason
+}
@@ -1,20 +1,169 @@
 package sqs
 
+import (
+	"encoding/json"
+	"text/template"
+)
+
+// templateFuncs are the functions available to the templates.  Values
+// that come from users or operators must be written with quote rather
+// than between hand-written quotes, so that they cannot break out of
+// the scalar they are in.
+var templateFuncs = template.FuncMap{
+	"quote": quote,
+}
+
+// quote returns a string as a JSON string, which is also a valid
+// double-quoted YAML scalar.
+func quote(s string) (string, error) {
+	data, err := json.Marshal(s)
+	return string(data), err
+}
+
 // queueTemplateFormat is a raw text/template for generating a
 // CloudFormation template for an SQS queue.  It expects to be given a
-// QueueTemplateBuilder struct.
+// QueueTemplateBuilder struct.  Each QueueSet gets its own copy of the
+// parameters, conditions, resources and outputs defined in
+// queueSetTemplateFormat, named with the set's prefix.
 const queueTemplateFormat = `
 AWSTemplateFormatVersion: 2010-09-09
+Metadata:
+  QueueTemplateParams: {{ .MetadataJSON }}
 Parameters:
-  DelaySeconds:
+  KmsDataKeyReusePeriodSeconds:
+    Default: 300
+    Description: |
+      The length of time in seconds for which Amazon SQS can reuse a
+      data key to encrypt or decrypt messages before calling AWS KMS
+      again. You can specify an integer value from 60 seconds (1
+      minute) to 86,400 seconds (24 hours). Only used when
+      KmsMasterKeyId is set.
+    MaxValue: 86400
+    MinValue: 60
+    Type: Number
+  KmsMasterKeyId:
+    Default: ""
+    Description: |
+      The ARN of a customer managed AWS KMS key to use for server-side
+      encryption of all the queues. An empty value means a customer
+      managed key is not used.
+    Type: String
+  SqsManagedSseEnabled:
+    AllowedValues:
+    - ""
+    - "true"
+    - "false"
+    Default: ""
+    Description: |
+      Enables server-side encryption using SQS owned encryption keys.
+      An empty value leaves the AWS default in place. Ignored when
+      KmsMasterKeyId is set.
+    Type: String
+{{ range .QueueSets }}{{ template "queue-parameters" . }}{{ end }}
+Conditions:
+  ShouldUseKMS:
+    Fn::Not:
+    - Fn::Equals:
+      - !Ref KmsMasterKeyId
+      - ""
+  ShouldSetSqsManagedSse:
+    Fn::Not:
+    - Fn::Equals:
+      - !Ref SqsManagedSseEnabled
+      - ""
+{{ range .QueueSets }}{{ template "queue-conditions" . }}{{ end }}
+Resources:
+{{ range .QueueSets }}{{ template "queue-resources" . }}{{ end }}
+{{ range $sub := .SNSSubscriptions }}
+  {{ $sub.LogicalID }}:
+    Properties:
+      Endpoint:
+        Fn::GetAtt:
+        - PrimaryQueue
+        - Arn
+{{ if $sub.FilterPolicy }}
+      FilterPolicy: {{ $sub.FilterPolicyJSON }}
+{{ end }}
+      Protocol: sqs
+      RawMessageDelivery: {{ $sub.RawMessageDelivery }}
+      TopicArn: {{ quote $sub.TopicARN }}
+    Type: AWS::SNS::Subscription
+{{ end }}
+Outputs:
+  KmsKeyARN:
+    Condition: ShouldUseKMS
+    Description: KMS key used to encrypt the queues
+    Value: !Ref KmsMasterKeyId
+{{ range .QueueSets }}{{ template "queue-outputs" . }}{{ end }}
+`
+
+// queueSetTemplateFormat defines the parts of queueTemplateFormat that
+// are repeated for each QueueSet.  Everything is named with the set's
+// Prefix so that the default queue keeps the names it always had.
+const queueSetTemplateFormat = `
+{{ define "queue-parameters" }}
+  {{.Prefix}}ContentBasedDeduplication:
+    AllowedValues:
+    - "true"
+    - "false"
+    Default: "false"
+    Description: |
+      Enables content-based deduplication for FIFO queues. SQS uses a
+      SHA-256 hash of the message body to generate the deduplication
+      ID when the producer does not provide one.
+    Type: String
+  {{.Prefix}}DeadLetterMessageRetentionPeriod:
+    Default: ""
+    Description: |
+      The number of seconds that Amazon SQS retains a message in the
+      dead-letter queue, from 60 seconds (1 minute) to 1,209,600
+      seconds (14 days). An empty value uses MessageRetentionPeriod.
+    Type: String
+  {{.Prefix}}DeadLetterQueue:
+    AllowedValues:
+    - "true"
+    - "false"
+    Default: "true"
+    Description: |
+      Whether to create a dead-letter queue alongside the primary
+      queue.
+    Type: String
+  {{.Prefix}}DeadLetterVisibilityTimeout:
+    Default: ""
+    Description: |
+      The visibility timeout of the dead-letter queue, from 0 to
+      43,200 seconds (12 hours). An empty value uses
+      VisibilityTimeout.
+    Type: String
+  {{.Prefix}}DeduplicationScope:
+    AllowedValues:
+    - queue
+    - messageGroup
+    Default: queue
+    Description: |
+      Specifies whether message deduplication occurs at the message
+      group or queue level for FIFO queues.
+    Type: String
+  {{.Prefix}}DelaySeconds:
     Default: 0
     Description: |
       The time in seconds for which the delivery of all messages in
       the queue is delayed. You can specify an integer value of 0 to
       900 (15 minutes).
     MaxValue: 900
+    MinValue: 0
     Type: Number
-  MaximumMessageSize:
+  {{.Prefix}}FifoThroughputLimit:
+    AllowedValues:
+    - perQueue
+    - perMessageGroupId
+    Default: perQueue
+    Description: |
+      Specifies whether the FIFO queue throughput quota applies to the
+      entire queue or per message group. High throughput mode requires
+      perMessageGroupId and a DeduplicationScope of messageGroup.
+    Type: String
+  {{.Prefix}}MaximumMessageSize:
     Default: 262144
     Description: |
       The limit of how many bytes that a message can contain before
@@ -24,7 +173,7 @@ Parameters:
     MaxValue: 262144
     MinValue: 1024
     Type: Number
-  MessageRetentionPeriod:
+  {{.Prefix}}MessageRetentionPeriod:
     Default: 345600
     Description: |
       The number of seconds that Amazon SQS retains a message. You can
@@ -34,7 +183,7 @@ Parameters:
     MaxValue: 1209600
     MinValue: 60
     Type: Number
-  ReceiveMessageWaitTimeSeconds:
+  {{.Prefix}}ReceiveMessageWaitTimeSeconds:
     Default: 0
     Description: |
       Specifies the duration, in seconds, that the ReceiveMessage
@@ -44,15 +193,18 @@ Parameters:
       integer from 1 to 20. Short polling is used as the default or
       when you specify 0 for this property.
     MaxValue: 20
+    MinValue: 0
     Type: Number
-  RedriveMaxReceiveCount:
+  {{.Prefix}}RedriveMaxReceiveCount:
     Default: 0
     Description: |
       The number of times a message is delivered to the source queue
-      before being moved to the dead-letter queue.  A value of 0
-      disables the dead-letter queue.
+      before being moved to the dead-letter queue.  A value of 0, or
+      a DeadLetterQueue of false, disables the redrive policy.
+    MaxValue: 1000
+    MinValue: 0
     Type: Number
-  VisibilityTimeout:
+  {{.Prefix}}VisibilityTimeout:
     Default: 30
     Description: |
       The length of time during which a message will be unavailable
@@ -63,41 +215,87 @@ Parameters:
       don't specify a value, AWS CloudFormation uses the default value
       of 30 seconds.
     MaxValue: 43200
+    MinValue: 0
     Type: Number
-Conditions:
-  ShouldNotUseDLQ:
+{{ end }}
+{{ define "queue-conditions" }}
+  {{.Prefix}}ShouldCreateDLQ:
     Fn::Equals:
-    - !Ref RedriveMaxReceiveCount
-    - 0
-Resources:
-  PrimaryQueue:
+    - !Ref {{.Prefix}}DeadLetterQueue
+    - "true"
+  {{.Prefix}}ShouldNotUseDLQ:
+    Fn::Or:
+    - Fn::Not:
+      - Condition: {{.Prefix}}ShouldCreateDLQ
+    - Fn::Equals:
+      - !Ref {{.Prefix}}RedriveMaxReceiveCount
+      - 0
+  {{.Prefix}}ShouldSetDeadLetterMessageRetentionPeriod:
+    Fn::Not:
+    - Fn::Equals:
+      - !Ref {{.Prefix}}DeadLetterMessageRetentionPeriod
+      - ""
+  {{.Prefix}}ShouldSetDeadLetterVisibilityTimeout:
+    Fn::Not:
+    - Fn::Equals:
+      - !Ref {{.Prefix}}DeadLetterVisibilityTimeout
+      - ""
+  {{.Prefix}}ShouldUseContentBasedDeduplication:
+    Fn::Equals:
+    - !Ref {{.Prefix}}ContentBasedDeduplication
+    - "true"
+{{ end }}
+{{ define "queue-resources" }}
+  {{.Prefix}}PrimaryQueue:
     Properties:
       QueueName: {{.PrimaryQueueName}}
 {{ if .FIFOQueue }}
       FifoQueue: {{.FIFOQueue}}
+      ContentBasedDeduplication: !If
+        - {{.Prefix}}ShouldUseContentBasedDeduplication
+        - true
+        - false
+      DeduplicationScope: !Ref {{.Prefix}}DeduplicationScope
+      FifoThroughputLimit: !Ref {{.Prefix}}FifoThroughputLimit
 {{ end }}
       Tags:
       - Key: QueueType
         Value: Primary
 {{ range $key, $value := .Tags }}
-      - Key: {{ $key }}
-        Value: {{ $value }}
+      - Key: {{ quote $key }}
+        Value: {{ quote $value }}
 {{ end }}
-      DelaySeconds: !Ref DelaySeconds
-      MaximumMessageSize: !Ref MaximumMessageSize
-      MessageRetentionPeriod: !Ref MessageRetentionPeriod
-      ReceiveMessageWaitTimeSeconds: !Ref ReceiveMessageWaitTimeSeconds
+      DelaySeconds: !Ref {{.Prefix}}DelaySeconds
+      KmsMasterKeyId: !If
+        - ShouldUseKMS
+        - !Ref KmsMasterKeyId
+        - !Ref "AWS::NoValue"
+      KmsDataKeyReusePeriodSeconds: !If
+        - ShouldUseKMS
+        - !Ref KmsDataKeyReusePeriodSeconds
+        - !Ref "AWS::NoValue"
+      SqsManagedSseEnabled: !If
+        - ShouldUseKMS
+        - !Ref "AWS::NoValue"
+        - !If
+          - ShouldSetSqsManagedSse
+          - !Ref SqsManagedSseEnabled
+          - !Ref "AWS::NoValue"
+      MaximumMessageSize: !Ref {{.Prefix}}MaximumMessageSize
+      MessageRetentionPeriod: !Ref {{.Prefix}}MessageRetentionPeriod
+      ReceiveMessageWaitTimeSeconds: !Ref {{.Prefix}}ReceiveMessageWaitTimeSeconds
       RedrivePolicy: !If
-        - ShouldNotUseDLQ
+        - {{.Prefix}}ShouldNotUseDLQ
         - !Ref "AWS::NoValue"
         - deadLetterTargetArn:
             Fn::GetAtt:
-            - SecondaryQueue
+            - {{.Prefix}}SecondaryQueue
             - Arn
-          maxReceiveCount: !Ref RedriveMaxReceiveCount
-      VisibilityTimeout: !Ref VisibilityTimeout
+          maxReceiveCount: !Ref {{.Prefix}}RedriveMaxReceiveCount
+      VisibilityTimeout: !Ref {{.Prefix}}VisibilityTimeout
     Type: AWS::SQS::Queue
-  SecondaryQueue:
+  {{.Prefix}}SecondaryQueue:
+    Condition: {{.Prefix}}ShouldCreateDLQ
     Properties:
       QueueName: {{.SecondaryQueueName}}
 {{ if .FIFOQueue }}
@@ -107,39 +305,176 @@ Resources:
       - Key: QueueType
         Value: Secondary
 {{ range $key, $value := .Tags }}
-      - Key: {{ $key }}
-        Value: {{ $value }}
+      - Key: {{ quote $key }}
+        Value: {{ quote $value }}
 {{ end }}
-      MessageRetentionPeriod: !Ref MessageRetentionPeriod
-      VisibilityTimeout: !Ref VisibilityTimeout
+      KmsMasterKeyId: !If
+        - ShouldUseKMS
+        - !Ref KmsMasterKeyId
+        - !Ref "AWS::NoValue"
+      KmsDataKeyReusePeriodSeconds: !If
+        - ShouldUseKMS
+        - !Ref KmsDataKeyReusePeriodSeconds
+        - !Ref "AWS::NoValue"
+      SqsManagedSseEnabled: !If
+        - ShouldUseKMS
+        - !Ref "AWS::NoValue"
+        - !If
+          - ShouldSetSqsManagedSse
+          - !Ref SqsManagedSseEnabled
+          - !Ref "AWS::NoValue"
+      MessageRetentionPeriod: !If
+        - {{.Prefix}}ShouldSetDeadLetterMessageRetentionPeriod
+        - !Ref {{.Prefix}}DeadLetterMessageRetentionPeriod
+        - !Ref {{.Prefix}}MessageRetentionPeriod
+      VisibilityTimeout: !If
+        - {{.Prefix}}ShouldSetDeadLetterVisibilityTimeout
+        - !Ref {{.Prefix}}DeadLetterVisibilityTimeout
+        - !Ref {{.Prefix}}VisibilityTimeout
     Type: AWS::SQS::Queue
-Outputs:
-  PrimaryQueueARN:
+{{ if .HasPrimaryQueuePolicy }}
+  {{.Prefix}}PrimaryQueuePolicy:
+    Properties:
+      PolicyDocument:
+        Statement:
+{{ if .SNSSubscriptions }}
+        - Action: sqs:SendMessage
+          Condition:
+            ArnEquals:
+              aws:SourceArn:
+{{ range $sub := .SNSSubscriptions }}
+              - {{ quote $sub.TopicARN }}
+{{ end }}
+          Effect: Allow
+          Principal:
+            Service: sns.amazonaws.com
+          Resource:
+            Fn::GetAtt:
+            - {{.Prefix}}PrimaryQueue
+            - Arn
+{{ end }}
+{{ with .QueuePolicy }}
+{{ if .ServicePrincipals }}
+        - Action: sqs:SendMessage
+          Condition:
+{{ if .SourceARNs }}
+            ArnLike:
+              aws:SourceArn:
+{{ range $arn := .SourceARNs }}
+              - {{ quote $arn }}
+{{ end }}
+{{ end }}
+{{ if .SourceAccounts }}
+            StringEquals:
+              aws:SourceAccount:
+{{ range $account := .SourceAccounts }}
+              - {{ quote $account }}
+{{ end }}
+{{ end }}
+          Effect: Allow
+          Principal:
+            Service:
+{{ range $service := .ServicePrincipals }}
+            - {{ quote $service }}
+{{ end }}
+          Resource:
+            Fn::GetAtt:
+            - {{$.Prefix}}PrimaryQueue
+            - Arn
+{{ end }}
+{{ if .AccountIDs }}
+        - Action:
+          - sqs:GetQueueAttributes
+          - sqs:GetQueueUrl
+          - sqs:SendMessage
+          Effect: Allow
+          Principal:
+            AWS:
+{{ range $account := .AccountIDs }}
+            - {{ quote $account }}
+{{ end }}
+          Resource:
+            Fn::GetAtt:
+            - {{$.Prefix}}PrimaryQueue
+            - Arn
+{{ end }}
+{{ end }}
+{{ if .RequireSecureTransport }}
+        - Action: sqs:*
+          Condition:
+            Bool:
+              aws:SecureTransport: "false"
+          Effect: Deny
+          Principal: "*"
+          Resource:
+            Fn::GetAtt:
+            - {{.Prefix}}PrimaryQueue
+            - Arn
+{{ end }}
+        Version: 2012-10-17
+      Queues:
+      - !Ref {{.Prefix}}PrimaryQueue
+    Type: AWS::SQS::QueuePolicy
+{{ end }}
+{{ if .RequireSecureTransport }}
+  {{.Prefix}}SecondaryQueuePolicy:
+    Condition: {{.Prefix}}ShouldCreateDLQ
+    Properties:
+      PolicyDocument:
+        Statement:
+        - Action: sqs:*
+          Condition:
+            Bool:
+              aws:SecureTransport: "false"
+          Effect: Deny
+          Principal: "*"
+          Resource:
+            Fn::GetAtt:
+            - {{.Prefix}}SecondaryQueue
+            - Arn
+        Version: 2012-10-17
+      Queues:
+      - !Ref {{.Prefix}}SecondaryQueue
+    Type: AWS::SQS::QueuePolicy
+{{ end }}
+{{ end }}
+{{ define "queue-outputs" }}
+  {{.Prefix}}PrimaryQueueARN:
     Description: Primary queue ARN
     Value:
       Fn::GetAtt:
-      - PrimaryQueue
+      - {{.Prefix}}PrimaryQueue
       - Arn
-  PrimaryQueueURL:
+  {{.Prefix}}PrimaryQueueURL:
     Description: Primary queue URL
-    Value: !Ref PrimaryQueue
-  SecondaryQueueARN:
+    Value: !Ref {{.Prefix}}PrimaryQueue
+  {{.Prefix}}SecondaryQueueARN:
+    Condition: {{.Prefix}}ShouldCreateDLQ
     Description: Secondary queue ARN
     Value:
       Fn::GetAtt:
-      - SecondaryQueue
+      - {{.Prefix}}SecondaryQueue
       - Arn
-  SecondaryQueueURL:
+  {{.Prefix}}SecondaryQueueURL:
+    Condition: {{.Prefix}}ShouldCreateDLQ
     Description: Secondary queue URL
-    Value: !Ref SecondaryQueue
+    Value: !Ref {{.Prefix}}SecondaryQueue
+{{ end }}
 `
 
 // userTemplateFormat is a raw text/template for generating a
-// CloudFormation template for an IAM User with permission to access a
-// particular SQS queue.  It expects to be given a UserTemplateBuilder
-// struct.
+// CloudFormation template for an IAM User, or an IAM Role for
+// role-based bindings, with permission to access a particular SQS
+// queue.  It expects to be given a UserTemplateBuilder struct.
 const userTemplateFormat = `
 AWSTemplateFormatVersion: 2010-09-09
+Metadata:
+{{ if .Role }}
+  BindingRole: {{ .RoleJSON }}
+{{ else }}
+  AccessKeys: {{ .AccessKeysJSON }}
+{{ end }}
+  UserTemplateParams: {{ .MetadataJSON }}
 Outputs:
   CredentialsARN:
     Description: Path to the binding credentials
@@ -147,53 +482,139 @@ Outputs:
       Ref: BindingCredentials
 Resources:
   BindingCredentials:
+    DeletionPolicy: Retain
     Properties:
       Description: Binding credentials
-      Name: '{{ .ResourcePrefix }}-{{ .BindingID }}'
+{{ if .SecretKMSKey }}
+      KmsKeyId: {{ quote .SecretKMSKey }}
+{{ end }}
+      Name: {{ quote (printf "%s-%s" .ResourcePrefix .BindingID) }}
       SecretString:
-        Fn::Sub: '{{ .CredentialsJSON }}'
+        Fn::Sub: {{ quote .CredentialsJSON }}
+{{ if .Tags }}
+      Tags:
+{{ range $key, $value := .Tags }}
+      - Key: {{ quote $key }}
+        Value: {{ quote $value }}
+{{ end }}
+{{ end }}
     Type: AWS::SecretsManager::Secret
-  IAMAccessKey:
+{{ if not .Role }}
+{{ range $key := .AccessKeys.All }}
+  {{ $key.LogicalID }}:
     Properties:
-      Serial: 1
+      Serial: {{ $key.Serial }}
       Status: Active
       UserName:
         Ref: IAMUser
     Type: AWS::IAM::AccessKey
+{{ end }}
+{{ end }}
   IAMPolicy:
     Properties:
       PolicyDocument:
         Statement:
+{{ range $statement := .PolicyStatements }}
         - Action:
-{{ range $action := .AccessPolicyActions }}
+{{ range $action := $statement.Actions }}
           - {{ $action }}
 {{ end }}
           Effect: Allow
           Resource:
-          - "{{ .PrimaryQueueARN }}"
-          - "{{ .SecondaryQueueARN }}"
+{{ range $arn := $statement.Resources }}
+          - {{ quote $arn }}
+{{ end }}
+{{ end }}
+{{ if .KmsKeyARN }}
+        - Action:
+          - kms:Decrypt
+          - kms:GenerateDataKey
+          Effect: Allow
+          Resource:
+          - {{ quote .KmsKeyARN }}
+{{ end }}
+{{ if not .NetworkRestrictions.IsEmpty }}
+        - Action:
+          - sqs:*
+          Condition:
+{{ if .SourceIPs }}
+            NotIpAddress:
+              aws:SourceIp:
+{{ range $cidr := .SourceIPs }}
+              - {{ quote $cidr }}
+{{ end }}
+{{ end }}
+{{ if or .SourceVPCs .SourceVPCEndpoints }}
+            StringNotEquals:
+{{ if .SourceVPCs }}
+              aws:SourceVpc:
+{{ range $vpc := .SourceVPCs }}
+              - {{ quote $vpc }}
+{{ end }}
+{{ end }}
+{{ if .SourceVPCEndpoints }}
+              aws:SourceVpce:
+{{ range $vpce := .SourceVPCEndpoints }}
+              - {{ quote $vpce }}
+{{ end }}
+{{ end }}
+{{ end }}
+          Effect: Deny
+          Resource:
+{{ range $arn := .QueueARNs }}
+          - {{ quote $arn }}
+{{ end }}
+{{ end }}
         Version: 2012-10-17
-      PolicyName: '{{ .ResourcePrefix }}-{{ .BindingID }}'
+      PolicyName: {{ quote (printf "%s-%s" .ResourcePrefix .BindingID) }}
+{{ if .Role }}
+      Roles:
+      - Ref: IAMRole
+{{ else }}
       Users:
       - Ref: IAMUser
+{{ end }}
     Type: AWS::IAM::Policy
+{{ if .Role }}
+  IAMRole:
+    Properties:
+      AssumeRolePolicyDocument:
+        Statement:
+        - Action: sts:AssumeRole
+          Condition:
+            StringEquals:
+              sts:ExternalId: {{ quote .Role.ExternalID }}
+          Effect: Allow
+          Principal:
+            AWS: {{ quote .TrustedPrincipal }}
+        Version: 2012-10-17
+{{ else }}
   IAMUser:
     Properties:
+{{ end }}
       Path: /{{ .ResourcePrefix }}/
 {{ if .PermissionsBoundary }}
-      PermissionsBoundary: {{ .PermissionsBoundary }}
+      PermissionsBoundary: {{ quote .PermissionsBoundary }}
 {{ end }}
 {{ if .AdditionalUserPolicy }}
       ManagedPolicyArns:
-      - "{{ .AdditionalUserPolicy }}"
+      - {{ quote .AdditionalUserPolicy }}
 {{ end }}
+{{ if .Role }}
+      RoleName: binding-{{ .BindingID }}
+{{ else }}
       UserName: binding-{{ .BindingID }}
+{{ end }}
 {{ if .Tags }}
       Tags:
 {{ range $key, $value := .Tags }}
-      - Key: {{ $key }}
-        Value: {{ $value }}
+      - Key: {{ quote $key }}
+        Value: {{ quote $value }}
 {{ end }}
 {{ end }}
+{{ if .Role }}
+    Type: AWS::IAM::Role
+{{ else }}
     Type: AWS::IAM::User
+{{ end }}
 `
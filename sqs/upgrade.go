@@ -0,0 +1,362 @@
+package sqs
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// QueueTemplateVersion and UserTemplateVersion identify the
+	// templates stacks are built from.  They change whenever the
+	// templates do, and are recorded in the TagTemplateVersion tag of
+	// each stack so that outdated stacks can be found and upgraded.
+	QueueTemplateVersion = templateVersion(queueTemplateFormat, queueSetTemplateFormat)
+	UserTemplateVersion  = templateVersion(userTemplateFormat, strconv.Itoa(CredentialsSchemaVersion))
+)
+
+const (
+	StackKindQueue   = "queue"
+	StackKindBinding = "binding"
+)
+
+const (
+	UpgradeResultCurrent  = "current"
+	UpgradeResultOutdated = "outdated"
+	UpgradeResultSkipped  = "skipped"
+	UpgradeResultUpgraded = "upgraded"
+	UpgradeResultFailed   = "failed"
+)
+
+func templateVersion(formats ...string) string {
+	hash := sha256.New()
+	for _, format := range formats {
+		hash.Write([]byte(format))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// withTemplateVersion returns tags with TagTemplateVersion set to
+// version.
+func withTemplateVersion(tags []*cloudformation.Tag, version string) []*cloudformation.Tag {
+	return withStackTag(tags, TagTemplateVersion, version)
+}
+
+// withStackTag returns tags with key set to value.  CloudFormation
+// replaces all of a stack's tags on update, so the other tags are
+// kept.
+func withStackTag(tags []*cloudformation.Tag, key, value string) []*cloudformation.Tag {
+	updated := []*cloudformation.Tag{}
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) != key {
+			updated = append(updated, tag)
+		}
+	}
+	return append(updated, &cloudformation.Tag{
+		Key:   aws.String(key),
+		Value: aws.String(value),
+	})
+}
+
+func getStackTag(stack *cloudformation.Stack, key string) string {
+	for _, tag := range stack.Tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+// UpgradeQueueStack rebuilds an instance's stack from the current
+// queue template, keeping all of its parameters.
+func (s *Provider) UpgradeQueueStack(ctx context.Context, stack *cloudformation.Stack) error {
+	stackName := aws.StringValue(stack.StackName)
+	body, err := s.getTemplateBody(ctx, stackName)
+	if err != nil {
+		return err
+	}
+	tags, err := templateResourceTags(body, ResourcePrimaryQueue)
+	if err != nil {
+		return err
+	}
+	instanceID := strings.TrimPrefix(stackName, s.ResourcePrefix+"-")
+	// the plan only matters for the queue type, which is taken from
+	// the existing queue, and the defaults of new named queues
+	planConfig := &PlanConfig{QueueType: QueueTypeStandard}
+	return s.updateQueueStack(ctx, stack, instanceID, tags[TagServiceId], planConfig, InstanceParams{}, nil)
+}
+
+// UpgradeBindingStack rebuilds a binding's stack from the current user
+// template, keeping its access policy.  The access keys are not
+// rotated.
+func (s *Provider) UpgradeBindingStack(ctx context.Context, stack *cloudformation.Stack) error {
+	body, err := s.getTemplateBody(ctx, aws.StringValue(stack.StackName))
+	if err != nil {
+		return err
+	}
+	keys, err := ParseAccessKeys(body)
+	if err != nil {
+		return err
+	}
+	return s.updateBindingStack(ctx, stack, body, keys)
+}
+
+// updateBindingStack rebuilds a binding's stack, whose current
+// template is body, from the current user template with the given
+// access keys.
+func (s *Provider) updateBindingStack(ctx context.Context, stack *cloudformation.Stack, body string, keys AccessKeys) error {
+	stackName := aws.StringValue(stack.StackName)
+	params, err := ParseUserTemplateParams(body)
+	if err != nil {
+		return err
+	}
+	role, err := ParseBindingRole(body)
+	if err != nil {
+		return err
+	}
+	resource := ResourceUser
+	if role != nil {
+		resource = ResourceRole
+	}
+	tags, err := templateResourceTags(body, resource)
+	if err != nil {
+		return err
+	}
+	instanceID := tags[TagCostAllocation]
+	if instanceID == "" {
+		return fmt.Errorf("binding has no %s tag", TagCostAllocation)
+	}
+	queueStack, err := s.getStack(ctx, s.getStackName(instanceID))
+	if err != nil {
+		return err
+	}
+	bindingID := strings.TrimPrefix(stackName, s.ResourcePrefix+"-")
+	userTemplate, err := s.userTemplateBuilder(ctx, queueStack, instanceID, tags[TagServiceId], bindingID)
+	if err != nil {
+		return err
+	}
+	userTemplate.Tags = withTemplateTags(userTemplate.Tags, s.ContextTagKeys.stackTags(stack))
+	userTemplate.AccessPolicy = params.AccessPolicy
+	userTemplate.DeadLetterAccessPolicy = params.DeadLetterAccessPolicy
+	userTemplate.NetworkRestrictions = params.NetworkRestrictions
+	userTemplate.AccessKeys = keys
+	userTemplate.Role = role
+	tmpl, err := userTemplate.Build()
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.UpdateStackWithContext(ctx, &cloudformation.UpdateStackInput{
+		Capabilities: capabilities,
+		StackName:    aws.String(stackName),
+		TemplateBody: aws.String(tmpl),
+		Tags:         bindingStackTags(stack.Tags, instanceID),
+	})
+	return err
+}
+
+// templateResourceTags reads the tags of a resource in a template.
+func templateResourceTags(templateBody, resource string) (map[string]string, error) {
+	var t struct {
+		Resources map[string]struct {
+			Properties struct {
+				Tags []struct {
+					Key   string `yaml:"Key"`
+					Value string `yaml:"Value"`
+				} `yaml:"Tags"`
+			} `yaml:"Properties"`
+		} `yaml:"Resources"`
+	}
+	if err := yaml.Unmarshal([]byte(templateBody), &t); err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for _, tag := range t.Resources[resource].Properties.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
+
+// An UpgradeReport is the outcome of upgrading a stack.
+type UpgradeReport struct {
+	StackName   string `json:"stack_name"`
+	Kind        string `json:"kind"`
+	FromVersion string `json:"from_version"`
+	ToVersion   string `json:"to_version"`
+	Result      string `json:"result"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Upgrader brings the broker's queue and binding stacks up to date
+// with the current templates.
+type Upgrader struct {
+	Provider *Provider
+	// Concurrency is the most stacks to upgrade at once.
+	Concurrency int
+	// DryRun reports which stacks are outdated without upgrading them.
+	DryRun bool
+	// Skip holds the names of stacks to leave alone, such as those
+	// that a previous run already upgraded.
+	Skip map[string]bool
+	// Report is called with the outcome for each stack.  Calls are
+	// not concurrent.
+	Report func(UpgradeReport)
+}
+
+// Run upgrades every outdated stack, waiting for each upgrade to
+// finish.  It returns an error if the stacks could not be listed or
+// any of them failed to upgrade.
+func (u *Upgrader) Run(ctx context.Context) error {
+	stacks, err := u.Provider.listStacks(ctx)
+	if err != nil {
+		return err
+	}
+	concurrency := u.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan *cloudformation.Stack)
+	reports := make(chan UpgradeReport)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for stack := range jobs {
+				reports <- u.upgrade(ctx, stack)
+			}
+		}()
+	}
+	go func() {
+		for _, stack := range stacks {
+			jobs <- stack
+		}
+		close(jobs)
+		wg.Wait()
+		close(reports)
+	}()
+
+	failed := 0
+	for report := range reports {
+		if report.Result == UpgradeResultFailed {
+			failed++
+		}
+		if u.Report != nil {
+			u.Report(report)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d stacks failed to upgrade", failed)
+	}
+	return nil
+}
+
+func (u *Upgrader) upgrade(ctx context.Context, stack *cloudformation.Stack) UpgradeReport {
+	report := UpgradeReport{
+		StackName:   aws.StringValue(stack.StackName),
+		FromVersion: getStackTag(stack, TagTemplateVersion),
+	}
+	failed := func(err error) UpgradeReport {
+		report.Result = UpgradeResultFailed
+		report.Error = err.Error()
+		return report
+	}
+
+	var upgrade func(context.Context, *cloudformation.Stack) error
+	switch {
+	case getStackOutput(stack, OutputPrimaryQueueARN) != "":
+		report.Kind = StackKindQueue
+		report.ToVersion = QueueTemplateVersion
+		upgrade = u.Provider.UpgradeQueueStack
+	case getStackOutput(stack, OutputCredentialsARN) != "":
+		report.Kind = StackKindBinding
+		report.ToVersion = UserTemplateVersion
+		upgrade = u.Provider.UpgradeBindingStack
+	default:
+		return failed(fmt.Errorf("not a queue or binding stack"))
+	}
+
+	switch {
+	case u.Skip[report.StackName]:
+		report.Result = UpgradeResultSkipped
+		return report
+	case report.FromVersion == report.ToVersion:
+		report.Result = UpgradeResultCurrent
+		return report
+	case u.DryRun:
+		report.Result = UpgradeResultOutdated
+		return report
+	}
+
+	switch aws.StringValue(stack.StackStatus) {
+	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete, cloudformation.StackStatusUpdateRollbackComplete:
+	default:
+		return failed(fmt.Errorf("cannot upgrade a stack in state %s", aws.StringValue(stack.StackStatus)))
+	}
+	if err := upgrade(ctx, stack); err != nil {
+		return failed(err)
+	}
+	status, err := u.waitForUpdate(ctx, report.StackName)
+	if err != nil {
+		return failed(err)
+	}
+	if status != cloudformation.StackStatusUpdateComplete {
+		return failed(fmt.Errorf("update finished in state %s", status))
+	}
+	report.Result = UpgradeResultUpgraded
+	return report
+}
+
+// waitForUpdate blocks until the stack is no longer being updated and
+// returns its status.
+func (u *Upgrader) waitForUpdate(ctx context.Context, stackName string) (string, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(PollingInterval):
+			stack, err := u.Provider.getStack(ctx, stackName)
+			if err != nil {
+				return "", err
+			}
+			if !strings.HasSuffix(*stack.StackStatus, "_IN_PROGRESS") {
+				return *stack.StackStatus, nil
+			}
+		}
+	}
+}
+
+// ReadUpgradeReports reads the reports written by a previous run, one
+// JSON object per line, and returns the names of the stacks that need
+// no further work.
+func ReadUpgradeReports(r io.Reader) (map[string]bool, error) {
+	done := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var report UpgradeReport
+		if err := json.Unmarshal([]byte(line), &report); err != nil {
+			return nil, err
+		}
+		switch report.Result {
+		case UpgradeResultUpgraded, UpgradeResultCurrent:
+			done[report.StackName] = true
+		}
+	}
+	return done, scanner.Err()
+}
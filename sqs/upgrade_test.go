@@ -0,0 +1,318 @@
+package sqs_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	fakeClient "github.com/alphagov/paas-sqs-broker/sqs/fakes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const legacyBindingTemplate = `
+AWSTemplateFormatVersion: 2010-09-09
+Resources:
+  IAMPolicy:
+    Properties:
+      PolicyDocument:
+        Statement:
+        - Action:
+          - sqs:GetQueueAttributes
+          - sqs:GetQueueUrl
+          - sqs:ListDeadLetterSourceQueues
+          - sqs:ListQueueTags
+          - sqs:SendMessage
+          Effect: Allow
+          Resource:
+          - "arn-1"
+    Type: AWS::IAM::Policy
+  IAMUser:
+    Properties:
+      Tags:
+      - Key: ServiceID
+        Value: service-guid
+      - Key: chargeable_entity
+        Value: instance-guid
+    Type: AWS::IAM::User
+`
+
+var _ = Describe("Upgrader", func() {
+	var (
+		fakeCfnClient     *fakeClient.FakeClient
+		upgrader          *sqs.Upgrader
+		stacks            map[string]*cloudformation.Stack
+		templates         map[string]string
+		reports           []sqs.UpgradeReport
+		err               error
+		oldPollingInteval time.Duration
+		lock              sync.Mutex
+	)
+
+	queueStack := func(name, version string) *cloudformation.Stack {
+		return &cloudformation.Stack{
+			StackName:   aws.String(name),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			Tags: []*cloudformation.Tag{
+				{Key: aws.String("Owner"), Value: aws.String("paas")},
+				{Key: aws.String(sqs.TagTemplateVersion), Value: aws.String(version)},
+			},
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String(sqs.OutputPrimaryQueueARN), OutputValue: aws.String("arn-1")},
+				{OutputKey: aws.String(sqs.OutputPrimaryQueueURL), OutputValue: aws.String("https://queue-1")},
+			},
+		}
+	}
+
+	bindingStack := func(name string) *cloudformation.Stack {
+		return &cloudformation.Stack{
+			StackName:   aws.String(name),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String(sqs.OutputCredentialsARN), OutputValue: aws.String("arn-secret")},
+			},
+		}
+	}
+
+	updatesFor := func(name string) []*cloudformation.UpdateStackInput {
+		inputs := []*cloudformation.UpdateStackInput{}
+		for i := 0; i < fakeCfnClient.UpdateStackWithContextCallCount(); i++ {
+			_, input, _ := fakeCfnClient.UpdateStackWithContextArgsForCall(i)
+			if aws.StringValue(input.StackName) == name {
+				inputs = append(inputs, input)
+			}
+		}
+		return inputs
+	}
+
+	BeforeEach(func() {
+		oldPollingInteval = sqs.PollingInterval
+		sqs.PollingInterval = time.Millisecond
+		fakeCfnClient = &fakeClient.FakeClient{}
+		upgrader = &sqs.Upgrader{
+			Provider: &sqs.Provider{
+				Client:         fakeCfnClient,
+				Environment:    "test",
+				ResourcePrefix: "testprefix",
+			},
+			Concurrency: 2,
+			Report: func(report sqs.UpgradeReport) {
+				reports = append(reports, report)
+			},
+		}
+		reports = nil
+		stacks = map[string]*cloudformation.Stack{
+			"testprefix-current-guid":  queueStack("testprefix-current-guid", sqs.QueueTemplateVersion),
+			"testprefix-instance-guid": queueStack("testprefix-instance-guid", "old"),
+			"testprefix-binding-guid":  bindingStack("testprefix-binding-guid"),
+		}
+		queueTemplate, err := (&sqs.QueueTemplateBuilder{
+			QueueName: "testprefix-instance-guid",
+			Tags:      map[string]string{sqs.TagServiceId: "service-guid"},
+		}).Build()
+		Expect(err).ToNot(HaveOccurred())
+		templates = map[string]string{
+			"testprefix-instance-guid": queueTemplate,
+			"testprefix-binding-guid":  legacyBindingTemplate,
+		}
+
+		fakeCfnClient.DescribeStacksWithContextStub = func(ctx context.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if input.StackName != nil {
+				return &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{stacks[*input.StackName]},
+				}, nil
+			}
+			// two pages, with a stack belonging to something else
+			if input.NextToken == nil {
+				return &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						stacks["testprefix-current-guid"],
+						{StackName: aws.String("someone-elses-stack")},
+					},
+					NextToken: aws.String("page-2"),
+				}, nil
+			}
+			return &cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{
+					stacks["testprefix-instance-guid"],
+					stacks["testprefix-binding-guid"],
+				},
+			}, nil
+		}
+		fakeCfnClient.GetTemplateWithContextStub = func(ctx context.Context, input *cloudformation.GetTemplateInput, opts ...request.Option) (*cloudformation.GetTemplateOutput, error) {
+			return &cloudformation.GetTemplateOutput{
+				TemplateBody: aws.String(templates[*input.StackName]),
+			}, nil
+		}
+		fakeCfnClient.UpdateStackWithContextStub = func(ctx context.Context, input *cloudformation.UpdateStackInput, opts ...request.Option) (*cloudformation.UpdateStackOutput, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			updated := *stacks[*input.StackName]
+			updated.StackStatus = aws.String(cloudformation.StackStatusUpdateComplete)
+			stacks[*input.StackName] = &updated
+			return &cloudformation.UpdateStackOutput{}, nil
+		}
+	})
+
+	AfterEach(func() {
+		sqs.PollingInterval = oldPollingInteval
+	})
+
+	JustBeforeEach(func() {
+		err = upgrader.Run(context.Background())
+	})
+
+	It("reports on each of the broker's stacks", func() {
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reports).To(ConsistOf(
+			sqs.UpgradeReport{
+				StackName:   "testprefix-current-guid",
+				Kind:        sqs.StackKindQueue,
+				FromVersion: sqs.QueueTemplateVersion,
+				ToVersion:   sqs.QueueTemplateVersion,
+				Result:      sqs.UpgradeResultCurrent,
+			},
+			sqs.UpgradeReport{
+				StackName:   "testprefix-instance-guid",
+				Kind:        sqs.StackKindQueue,
+				FromVersion: "old",
+				ToVersion:   sqs.QueueTemplateVersion,
+				Result:      sqs.UpgradeResultUpgraded,
+			},
+			sqs.UpgradeReport{
+				StackName:   "testprefix-binding-guid",
+				Kind:        sqs.StackKindBinding,
+				FromVersion: "",
+				ToVersion:   sqs.UserTemplateVersion,
+				Result:      sqs.UpgradeResultUpgraded,
+			},
+		))
+	})
+
+	It("does not update current stacks", func() {
+		Expect(updatesFor("testprefix-current-guid")).To(BeEmpty())
+	})
+
+	It("rebuilds queue stacks and stamps them with the template version", func() {
+		updates := updatesFor("testprefix-instance-guid")
+		Expect(updates).To(HaveLen(1))
+		Expect(updates[0].UsePreviousTemplate).To(BeNil())
+		t, err := parseTemplate(*updates[0].TemplateBody)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.Resources).To(HaveKey(sqs.ResourcePrimaryQueue))
+		Expect(updates[0].Tags).To(ConsistOf(
+			&cloudformation.Tag{Key: aws.String("Owner"), Value: aws.String("paas")},
+			&cloudformation.Tag{Key: aws.String(sqs.TagTemplateVersion), Value: aws.String(sqs.QueueTemplateVersion)},
+		))
+	})
+
+	It("rebuilds binding stacks keeping their access policy", func() {
+		updates := updatesFor("testprefix-binding-guid")
+		Expect(updates).To(HaveLen(1))
+		params, err := sqs.ParseUserTemplateParams(*updates[0].TemplateBody)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(params.AccessPolicy).To(Equal(sqs.AccessPolicyProducer))
+		Expect(*updates[0].TemplateBody).To(ContainSubstring("chargeable_entity"))
+		Expect(*updates[0].TemplateBody).To(ContainSubstring("instance-guid"))
+		Expect(updates[0].Tags).To(ConsistOf(
+			&cloudformation.Tag{Key: aws.String(sqs.TagTemplateVersion), Value: aws.String(sqs.UserTemplateVersion)},
+			&cloudformation.Tag{Key: aws.String(sqs.TagInstanceId), Value: aws.String("instance-guid")},
+		))
+	})
+
+	Context("when a binding has a role", func() {
+		BeforeEach(func() {
+			upgrader.Provider.RoleTrustedPrincipal = "arn:aws:iam::123456789012:role/apps"
+			roleTemplate, err := (&sqs.UserTemplateBuilder{
+				BindingID:        "binding-guid",
+				Role:             &sqs.BindingRole{ExternalID: "external-id"},
+				TrustedPrincipal: "arn:aws:iam::123456789012:role/apps",
+				Tags:             map[string]string{sqs.TagCostAllocation: "instance-guid"},
+			}).Build()
+			Expect(err).ToNot(HaveOccurred())
+			templates["testprefix-binding-guid"] = roleTemplate
+		})
+
+		It("rebuilds it keeping the role and its external ID", func() {
+			updates := updatesFor("testprefix-binding-guid")
+			Expect(updates).To(HaveLen(1))
+			role, err := sqs.ParseBindingRole(*updates[0].TemplateBody)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(&sqs.BindingRole{ExternalID: "external-id"}))
+			t, err := parseTemplate(*updates[0].TemplateBody)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(t.Resources).To(HaveKey(sqs.ResourceRole))
+			Expect(t.Resources).ToNot(HaveKey(sqs.ResourceUser))
+		})
+	})
+
+	Context("when doing a dry run", func() {
+		BeforeEach(func() {
+			upgrader.DryRun = true
+		})
+
+		It("only reports outdated stacks", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(BeZero())
+			results := map[string]string{}
+			for _, report := range reports {
+				results[report.StackName] = report.Result
+			}
+			Expect(results).To(Equal(map[string]string{
+				"testprefix-current-guid":  sqs.UpgradeResultCurrent,
+				"testprefix-instance-guid": sqs.UpgradeResultOutdated,
+				"testprefix-binding-guid":  sqs.UpgradeResultOutdated,
+			}))
+		})
+	})
+
+	Context("when resuming", func() {
+		BeforeEach(func() {
+			upgrader.Skip = map[string]bool{"testprefix-binding-guid": true}
+		})
+
+		It("skips stacks that were already upgraded", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updatesFor("testprefix-binding-guid")).To(BeEmpty())
+			Expect(updatesFor("testprefix-instance-guid")).To(HaveLen(1))
+		})
+	})
+
+	Context("when a stack cannot be updated", func() {
+		BeforeEach(func() {
+			stacks["testprefix-instance-guid"].StackStatus = aws.String(cloudformation.StackStatusUpdateInProgress)
+		})
+
+		It("reports the failure and carries on", func() {
+			Expect(err).To(MatchError("1 stacks failed to upgrade"))
+			Expect(reports).To(ContainElement(sqs.UpgradeReport{
+				StackName:   "testprefix-instance-guid",
+				Kind:        sqs.StackKindQueue,
+				FromVersion: "old",
+				ToVersion:   sqs.QueueTemplateVersion,
+				Result:      sqs.UpgradeResultFailed,
+				Error:       "cannot upgrade a stack in state UPDATE_IN_PROGRESS",
+			}))
+			Expect(updatesFor("testprefix-binding-guid")).To(HaveLen(1))
+		})
+	})
+
+	It("reads previous reports for resuming", func() {
+		done, err := sqs.ReadUpgradeReports(strings.NewReader(`
+{"stack_name": "a", "result": "upgraded"}
+{"stack_name": "b", "result": "failed"}
+{"stack_name": "c", "result": "current"}
+{"stack_name": "d", "result": "outdated"}
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(Equal(map[string]bool{"a": true, "c": true}))
+	})
+})
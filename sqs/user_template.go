@@ -2,16 +2,24 @@ package sqs
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"gopkg.in/yaml.v2"
 )
 
 const (
 	ResourceUser        = "IAMUser"
+	ResourceRole        = "IAMRole"
 	ResourceAccessKey   = "IAMAccessKey"
 	ResourcePolicy      = "IAMPolicy"
 	ResourceCredentials = "BindingCredentials"
@@ -23,33 +31,261 @@ const (
 
 type AccessPolicy = string
 
+const (
+	// BindingModeUser bindings get an IAM user with an access key.
+	BindingModeUser = "user"
+	// BindingModeRole bindings get an IAM role that the broker's
+	// trusted principal can assume with the binding's external ID.
+	BindingModeRole = "role"
+)
+
 const (
 	AccessPolicyFull     AccessPolicy = "full"
 	AccessPolicyProducer AccessPolicy = "producer"
 	AccessPolicyConsumer AccessPolicy = "consumer"
+	// AccessPolicyMonitor allows reading the queue's attributes but
+	// not its messages.
+	AccessPolicyMonitor AccessPolicy = "monitor"
+	// AccessPolicyNone gives no access to the queue.
+	AccessPolicyNone AccessPolicy = "none"
 )
 
+// BuiltinAccessPolicies are the access policies that are always
+// defined.  Operators can define more in the config.
+var BuiltinAccessPolicies = []AccessPolicy{
+	AccessPolicyFull,
+	AccessPolicyProducer,
+	AccessPolicyConsumer,
+	AccessPolicyMonitor,
+	AccessPolicyNone,
+}
+
+// RedefinableAccessPolicies are the built-in access policies that
+// operators may redefine in the config.  The others are relied on by
+// existing bindings and defaults, so cannot be.
+var RedefinableAccessPolicies = []AccessPolicy{
+	AccessPolicyMonitor,
+}
+
+// AllowedAccessPolicyActions are the actions operator-defined access
+// policies may allow.  Actions that change the queue itself are left
+// out as the queue is managed by the broker.
+var AllowedAccessPolicyActions = []string{
+	"sqs:CancelMessageMoveTask",
+	"sqs:ChangeMessageVisibility",
+	"sqs:DeleteMessage",
+	"sqs:GetQueueAttributes",
+	"sqs:GetQueueUrl",
+	"sqs:ListDeadLetterSourceQueues",
+	"sqs:ListMessageMoveTasks",
+	"sqs:ListQueueTags",
+	"sqs:PurgeQueue",
+	"sqs:ReceiveMessage",
+	"sqs:SendMessage",
+	"sqs:StartMessageMoveTask",
+}
+
+func isBuiltinAccessPolicy(name string) bool {
+	for _, policy := range BuiltinAccessPolicies {
+		if policy == name {
+			return true
+		}
+	}
+	return false
+}
+
 type UserTemplateBuilder struct {
-	BindingID            string            `json:"-"`
-	ResourcePrefix       string            `json:"-"`
-	UserPath             string            `json:"-"`
-	PrimaryQueueURL      string            `json:"-"`
-	PrimaryQueueARN      string            `json:"-"`
-	SecondaryQueueURL    string            `json:"-"`
-	SecondaryQueueARN    string            `json:"-"`
-	Tags                 map[string]string `json:"-"`
-	AdditionalUserPolicy string            `json:"-"`
-	PermissionsBoundary  string            `json:"-"`
-	AccessPolicy         AccessPolicy      `json:"access_policy"`
-	AccessPolicyActions  []string
+	BindingID            string                `json:"-"`
+	ResourcePrefix       string                `json:"-"`
+	UserPath             string                `json:"-"`
+	PrimaryQueueURL      string                `json:"-"`
+	PrimaryQueueARN      string                `json:"-"`
+	SecondaryQueueURL    string                `json:"-"`
+	SecondaryQueueARN    string                `json:"-"`
+	KmsKeyARN            string                `json:"-"`
+	NamedQueues          map[string]NamedQueue `json:"-"`
+	Tags                 map[string]string     `json:"-"`
+	AdditionalUserPolicy string                `json:"-"`
+	PermissionsBoundary  string                `json:"-"`
+	AccessPolicy         AccessPolicy          `json:"access_policy"`
+	// DeadLetterAccessPolicy is the access policy for the instance's
+	// dead-letter queues.  Defaults to AccessPolicy.
+	DeadLetterAccessPolicy AccessPolicy `json:"dead_letter_access_policy"`
+	AccessKeys             AccessKeys   `json:"-"`
+	// AccessPolicies are the operator-defined access policies, keyed
+	// by name.
+	AccessPolicies map[string][]string `json:"-"`
+	// NetworkRestrictions limit where the binding can be used from.
+	NetworkRestrictions
+	// SQSEndpoint overrides DefaultSQSEndpoint in the credentials.
+	SQSEndpoint string `json:"-"`
+	// SecretKMSKey is the ARN of the KMS key to encrypt the
+	// credentials secret with, if not the default.
+	SecretKMSKey string `json:"-"`
+	// Role is set for bindings that get an IAM role instead of an IAM
+	// user, which TrustedPrincipal is allowed to assume.
+	Role             *BindingRole      `json:"-"`
+	TrustedPrincipal string            `json:"-"`
+	PolicyStatements []PolicyStatement `json:"-"`
+}
+
+// PolicyStatement allows a set of actions on a set of queues.
+type PolicyStatement struct {
+	Actions   []string
+	Resources []string
+}
+
+// BindingRole records the details of a role-based binding that must
+// be kept when its template is rebuilt.
+type BindingRole struct {
+	ExternalID string `json:"external_id"`
+}
+
+// NewBindingRole returns a BindingRole with a random external ID.
+func NewBindingRole() (*BindingRole, error) {
+	externalID := make([]byte, 16)
+	if _, err := rand.Read(externalID); err != nil {
+		return nil, err
+	}
+	return &BindingRole{ExternalID: hex.EncodeToString(externalID)}, nil
+}
+
+// AccessKeys records a binding's access keys so that they can be
+// rotated.  Each key is a separate resource in the template, so that a
+// new key can be created while the one it replaces is still in use.
+type AccessKeys struct {
+	// Current is the key in the binding's credentials.
+	Current AccessKey `json:"current"`
+	// Retiring is the key that Current replaced.  It is kept active
+	// until its RetireAt time so that apps have a chance to pick up
+	// the new credentials.
+	Retiring *AccessKey `json:"retiring,omitempty"`
+}
+
+// AccessKey is one of a binding's access keys.
+type AccessKey struct {
+	Serial int `json:"serial"`
+	// CreatedAt is unset for a binding's first key, which is as old
+	// as the binding's stack.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	RetireAt  *time.Time `json:"retire_at,omitempty"`
+}
+
+// LogicalID returns the ID of the key's resource in the template.  A
+// binding's first key keeps the ID it had before keys were rotated.
+func (key AccessKey) LogicalID() string {
+	if key.Serial <= 1 {
+		return ResourceAccessKey
+	}
+	return fmt.Sprintf("%s%d", ResourceAccessKey, key.Serial)
+}
+
+// All returns every active key.
+func (keys AccessKeys) All() []AccessKey {
+	all := []AccessKey{keys.Current}
+	if keys.Retiring != nil {
+		all = append(all, *keys.Retiring)
+	}
+	return all
+}
+
+// Rotate returns keys with a new current key, created at now, and the
+// current key retiring after the grace period.
+func (keys AccessKeys) Rotate(now time.Time, gracePeriod time.Duration) AccessKeys {
+	retiring := keys.Current
+	retireAt := now.Add(gracePeriod)
+	retiring.RetireAt = &retireAt
+	return AccessKeys{
+		Current: AccessKey{
+			Serial:    keys.Current.Serial + 1,
+			CreatedAt: &now,
+		},
+		Retiring: &retiring,
+	}
 }
 
+// Retire returns keys without the retiring key.
+func (keys AccessKeys) Retire() AccessKeys {
+	keys.Retiring = nil
+	return keys
+}
+
+// UserTemplateParams are the parameters a binding was created with,
+// which are recorded in the template metadata so that the template
+// can be rebuilt later.
+type UserTemplateParams struct {
+	AccessPolicy           AccessPolicy `json:"access_policy,omitempty"`
+	DeadLetterAccessPolicy AccessPolicy `json:"dead_letter_access_policy,omitempty"`
+	NetworkRestrictions
+}
+
+// CredentialsSchemaVersion is the version of the Credentials given to
+// bindings.  It must be increased whenever fields are added to or
+// changed in Credentials, so that apps can tell which fields to expect
+// and outdated bindings are found by the upgrade command.
+const CredentialsSchemaVersion = 3
+
+// DefaultSQSEndpoint is the SQS endpoint given to bindings unless the
+// operator configures another, such as a VPC endpoint.
+const DefaultSQSEndpoint = "https://sqs.${AWS::Region}.${AWS::URLSuffix}"
+
 type Credentials struct {
-	AWSAccessKeyID     string `json:"aws_access_key_id"`
-	AWSSecretAccessKey string `json:"aws_secret_access_key"`
+	// SchemaVersion is CredentialsSchemaVersion.  Bindings created
+	// before it was introduced have no schema version.
+	SchemaVersion      int    `json:"schema_version"`
+	AWSAccessKeyID     string `json:"aws_access_key_id,omitempty"`
+	AWSSecretAccessKey string `json:"aws_secret_access_key,omitempty"`
+	// AWSRoleARN and AWSExternalID are given instead of an access key
+	// to role-based bindings.
+	AWSRoleARN         string `json:"aws_role_arn,omitempty"`
+	AWSExternalID      string `json:"aws_external_id,omitempty"`
 	AWSRegion          string `json:"aws_region"`
+	SQSEndpoint        string `json:"sqs_endpoint"`
+	PrimaryQueueURL    string `json:"primary_queue_url"`
+	PrimaryQueueARN    string `json:"primary_queue_arn"`
+	PrimaryQueueName   string `json:"primary_queue_name"`
+	SecondaryQueueURL  string `json:"secondary_queue_url,omitempty"`
+	SecondaryQueueARN  string `json:"secondary_queue_arn,omitempty"`
+	SecondaryQueueName string `json:"secondary_queue_name,omitempty"`
+	// URI is the primary queue as an sqs:// URI.  It does not include
+	// the access key, as secret keys are not safe to put in a URI.
+	URI          string       `json:"uri"`
+	FIFO         bool         `json:"fifo"`
+	AccessPolicy AccessPolicy `json:"access_policy"`
+	// DeadLetterAccessPolicy is only given if the instance has a
+	// dead-letter queue.
+	DeadLetterAccessPolicy AccessPolicy `json:"dead_letter_access_policy,omitempty"`
+	// Queues holds the instance's named queues, keyed by name.
+	Queues map[string]NamedQueue `json:"queues,omitempty"`
+}
+
+// NamedQueue is one of an instance's named queues.  It has no
+// secondary queue if its dead-letter queue is turned off.
+type NamedQueue struct {
 	PrimaryQueueURL    string `json:"primary_queue_url"`
-	SecondaryQueueURL  string `json:"secondary_queue_url"`
+	PrimaryQueueARN    string `json:"primary_queue_arn"`
+	PrimaryQueueName   string `json:"primary_queue_name,omitempty"`
+	SecondaryQueueURL  string `json:"secondary_queue_url,omitempty"`
+	SecondaryQueueARN  string `json:"secondary_queue_arn,omitempty"`
+	SecondaryQueueName string `json:"secondary_queue_name,omitempty"`
+}
+
+// queueNameFromARN returns the name of the queue with the given ARN,
+// which is its last component.
+func queueNameFromARN(arn string) string {
+	if arn == "" {
+		return ""
+	}
+	parts := strings.Split(arn, ":")
+	return parts[len(parts)-1]
+}
+
+// queueURI returns a queue URL as an sqs:// URI.
+func queueURI(queueURL string) string {
+	if i := strings.Index(queueURL, "://"); i >= 0 {
+		return "sqs" + queueURL[i:]
+	}
+	return queueURL
 }
 
 func (builder UserTemplateBuilder) CredentialsJSON() (string, error) {
@@ -60,12 +296,35 @@ func (builder UserTemplateBuilder) CredentialsJSON() (string, error) {
 	// ${res} is equivilent to cloudformation.Ref("res")
 	// ${res.arn} is equivilent to cloudformation.GetAtt("res", "arn")
 	//
+	endpoint := builder.SQSEndpoint
+	if endpoint == "" {
+		endpoint = DefaultSQSEndpoint
+	}
+	primaryQueueName := queueNameFromARN(builder.PrimaryQueueARN)
 	credentialsPlaceholders := Credentials{
-		AWSAccessKeyID:     fmt.Sprintf("${%s}", ResourceAccessKey),
-		AWSSecretAccessKey: fmt.Sprintf("${%s.SecretAccessKey}", ResourceAccessKey),
+		SchemaVersion:      CredentialsSchemaVersion,
 		AWSRegion:          "${AWS::Region}",
+		SQSEndpoint:        endpoint,
 		PrimaryQueueURL:    builder.PrimaryQueueURL,
+		PrimaryQueueARN:    builder.PrimaryQueueARN,
+		PrimaryQueueName:   primaryQueueName,
 		SecondaryQueueURL:  builder.SecondaryQueueURL,
+		SecondaryQueueARN:  builder.SecondaryQueueARN,
+		SecondaryQueueName: queueNameFromARN(builder.SecondaryQueueARN),
+		URI:                queueURI(builder.PrimaryQueueURL),
+		FIFO:               strings.HasSuffix(primaryQueueName, ".fifo"),
+		AccessPolicy:       builder.AccessPolicy,
+		Queues:             builder.NamedQueues,
+	}
+	if len(builder.secondaryQueueARNs()) > 0 {
+		credentialsPlaceholders.DeadLetterAccessPolicy = builder.deadLetterAccessPolicy()
+	}
+	if builder.Role != nil {
+		credentialsPlaceholders.AWSRoleARN = fmt.Sprintf("${%s.Arn}", ResourceRole)
+		credentialsPlaceholders.AWSExternalID = builder.Role.ExternalID
+	} else {
+		credentialsPlaceholders.AWSAccessKeyID = fmt.Sprintf("${%s}", builder.AccessKeys.Current.LogicalID())
+		credentialsPlaceholders.AWSSecretAccessKey = fmt.Sprintf("${%s.SecretAccessKey}", builder.AccessKeys.Current.LogicalID())
 	}
 	credentialsTemplate, err := json.Marshal(credentialsPlaceholders)
 	if err != nil {
@@ -74,16 +333,230 @@ func (builder UserTemplateBuilder) CredentialsJSON() (string, error) {
 	return string(credentialsTemplate), nil
 }
 
+// QueueARNs returns the ARNs of every queue in the instance.
+func (builder UserTemplateBuilder) QueueARNs() []string {
+	return append(builder.primaryQueueARNs(), builder.secondaryQueueARNs()...)
+}
+
+// primaryQueueARNs returns the ARNs of the instance's default and
+// named queues.
+func (builder UserTemplateBuilder) primaryQueueARNs() []string {
+	arns := []string{builder.PrimaryQueueARN}
+	for _, name := range builder.namedQueueNames() {
+		arns = append(arns, builder.NamedQueues[name].PrimaryQueueARN)
+	}
+	return arns
+}
+
+// secondaryQueueARNs returns the ARNs of the instance's dead-letter
+// queues.
+func (builder UserTemplateBuilder) secondaryQueueARNs() []string {
+	arns := []string{}
+	if builder.SecondaryQueueARN != "" {
+		arns = append(arns, builder.SecondaryQueueARN)
+	}
+	for _, name := range builder.namedQueueNames() {
+		if queue := builder.NamedQueues[name]; queue.SecondaryQueueARN != "" {
+			arns = append(arns, queue.SecondaryQueueARN)
+		}
+	}
+	return arns
+}
+
+func (builder UserTemplateBuilder) namedQueueNames() []string {
+	names := []string{}
+	for name := range builder.NamedQueues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (builder UserTemplateBuilder) deadLetterAccessPolicy() AccessPolicy {
+	if builder.DeadLetterAccessPolicy == "" {
+		return builder.AccessPolicy
+	}
+	return builder.DeadLetterAccessPolicy
+}
+
+// GetPolicyStatements returns the statements granting the binding its
+// access policies on the primary and dead-letter queues.  Queues with
+// the same access share a statement.
+func (builder UserTemplateBuilder) GetPolicyStatements() ([]PolicyStatement, error) {
+	primaryActions, err := builder.accessPolicyActions(builder.AccessPolicy)
+	if err != nil {
+		return nil, err
+	}
+	deadLetterActions, err := builder.accessPolicyActions(builder.deadLetterAccessPolicy())
+	if err != nil {
+		return nil, err
+	}
+	statements := []PolicyStatement{}
+	add := func(actions, resources []string) {
+		if len(actions) == 0 || len(resources) == 0 {
+			return
+		}
+		for i := range statements {
+			if equalStrings(statements[i].Actions, actions) {
+				statements[i].Resources = append(statements[i].Resources, resources...)
+				return
+			}
+		}
+		statements = append(statements, PolicyStatement{Actions: actions, Resources: resources})
+	}
+	add(primaryActions, builder.primaryQueueARNs())
+	add(deadLetterActions, builder.secondaryQueueARNs())
+	if len(statements) == 0 {
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("the access policies give the binding access to none of the queues"),
+			http.StatusBadRequest,
+			"no-queue-access",
+		)
+	}
+	return statements, nil
+}
+
+// MetadataJSON returns the UserTemplateParams as a quoted JSON string
+// for recording in the template metadata.
+func (builder UserTemplateBuilder) MetadataJSON() (string, error) {
+	data, err := json.Marshal(UserTemplateParams{
+		AccessPolicy:           builder.AccessPolicy,
+		DeadLetterAccessPolicy: builder.DeadLetterAccessPolicy,
+		NetworkRestrictions:    builder.NetworkRestrictions,
+	})
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(string(data)), nil
+}
+
+// AccessKeysJSON returns the AccessKeys as a quoted JSON string for
+// recording in the template metadata.
+func (builder UserTemplateBuilder) AccessKeysJSON() (string, error) {
+	data, err := json.Marshal(builder.AccessKeys)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(string(data)), nil
+}
+
+// RoleJSON returns the Role as a quoted JSON string for recording in
+// the template metadata.
+func (builder UserTemplateBuilder) RoleJSON() (string, error) {
+	data, err := json.Marshal(builder.Role)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(string(data)), nil
+}
+
+// ParseBindingRole reads the BindingRole recorded in the metadata of a
+// user template.  It returns nil for bindings with an IAM user.
+func ParseBindingRole(templateBody string) (*BindingRole, error) {
+	var t struct {
+		Metadata struct {
+			BindingRole string `yaml:"BindingRole"`
+		} `yaml:"Metadata"`
+	}
+	if err := yaml.Unmarshal([]byte(templateBody), &t); err != nil {
+		return nil, err
+	}
+	if t.Metadata.BindingRole == "" {
+		return nil, nil
+	}
+	role := &BindingRole{}
+	err := json.Unmarshal([]byte(t.Metadata.BindingRole), role)
+	return role, err
+}
+
+// ParseAccessKeys reads the AccessKeys recorded in the metadata of a
+// user template.  Templates that predate the metadata have a single
+// key with the first serial.
+func ParseAccessKeys(templateBody string) (AccessKeys, error) {
+	keys := AccessKeys{Current: AccessKey{Serial: 1}}
+	var t struct {
+		Metadata struct {
+			AccessKeys string `yaml:"AccessKeys"`
+		} `yaml:"Metadata"`
+	}
+	if err := yaml.Unmarshal([]byte(templateBody), &t); err != nil {
+		return keys, err
+	}
+	if t.Metadata.AccessKeys == "" {
+		return keys, nil
+	}
+	err := json.Unmarshal([]byte(t.Metadata.AccessKeys), &keys)
+	return keys, err
+}
+
+// ParseUserTemplateParams reads the UserTemplateParams recorded in the
+// metadata of a user template.  For templates that predate the
+// metadata the access policy is worked out from the policy's actions.
+func ParseUserTemplateParams(templateBody string) (UserTemplateParams, error) {
+	params := UserTemplateParams{}
+	var t struct {
+		Metadata struct {
+			UserTemplateParams string `yaml:"UserTemplateParams"`
+		} `yaml:"Metadata"`
+		Resources struct {
+			IAMPolicy struct {
+				Properties struct {
+					PolicyDocument struct {
+						Statement []struct {
+							Action []string `yaml:"Action"`
+						} `yaml:"Statement"`
+					} `yaml:"PolicyDocument"`
+				} `yaml:"Properties"`
+			} `yaml:"IAMPolicy"`
+		} `yaml:"Resources"`
+	}
+	if err := yaml.Unmarshal([]byte(templateBody), &t); err != nil {
+		return params, err
+	}
+	if t.Metadata.UserTemplateParams != "" {
+		err := json.Unmarshal([]byte(t.Metadata.UserTemplateParams), &params)
+		return params, err
+	}
+	if statements := t.Resources.IAMPolicy.Properties.PolicyDocument.Statement; len(statements) > 0 {
+		for _, policy := range []AccessPolicy{AccessPolicyFull, AccessPolicyProducer, AccessPolicyConsumer} {
+			actions, _ := UserTemplateBuilder{AccessPolicy: policy}.GetAccessPolicy()
+			if equalStrings(actions, statements[0].Action) {
+				params.AccessPolicy = policy
+				return params, nil
+			}
+		}
+	}
+	return params, fmt.Errorf("unable to determine the access policy of the binding")
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (builder UserTemplateBuilder) Build() (string, error) {
 	if builder.AccessPolicy == "" {
 		builder.AccessPolicy = "full"
 	}
+	if builder.AccessKeys.Current.Serial == 0 {
+		builder.AccessKeys.Current.Serial = 1
+	}
+	if builder.Role != nil && builder.TrustedPrincipal == "" {
+		return "", fmt.Errorf("role-based bindings need a trusted principal")
+	}
 	var err error
-	builder.AccessPolicyActions, err = builder.GetAccessPolicy()
+	builder.PolicyStatements, err = builder.GetPolicyStatements()
 	if err != nil {
 		return "", err
 	}
-	t, err := template.New("user-template").Parse(userTemplateFormat)
+	t, err := template.New("user-template").Funcs(templateFuncs).Parse(userTemplateFormat)
 	if err != nil {
 		return "", err
 	}
@@ -96,8 +569,21 @@ func (builder UserTemplateBuilder) Build() (string, error) {
 	return buf.String(), nil
 }
 
+// GetAccessPolicy returns the actions allowed by the AccessPolicy.
 func (builder UserTemplateBuilder) GetAccessPolicy() ([]string, error) {
-	switch builder.AccessPolicy {
+	return builder.accessPolicyActions(builder.AccessPolicy)
+}
+
+// accessPolicyActions returns the actions allowed by a built-in or
+// operator-defined access policy.  Operator-defined policies take the
+// place of the RedefinableAccessPolicies.
+func (builder UserTemplateBuilder) accessPolicyActions(policy AccessPolicy) ([]string, error) {
+	if actions, ok := builder.AccessPolicies[policy]; ok {
+		sorted := append([]string{}, actions...)
+		sort.Strings(sorted)
+		return sorted, nil
+	}
+	switch policy {
 	case AccessPolicyFull:
 		return []string{
 			"sqs:ChangeMessageVisibility",
@@ -129,11 +615,19 @@ func (builder UserTemplateBuilder) GetAccessPolicy() ([]string, error) {
 			"sqs:ReceiveMessage",
 		}, nil
 
-	default:
-		return nil, apiresponses.NewFailureResponse(
-			fmt.Errorf("unknown access policy %#v", builder.AccessPolicy),
-			http.StatusBadRequest,
-			"unknown-access-policy",
-		)
+	case AccessPolicyMonitor:
+		return []string{
+			"sqs:GetQueueAttributes",
+			"sqs:GetQueueUrl",
+			"sqs:ListDeadLetterSourceQueues",
+			"sqs:ListQueueTags",
+		}, nil
+	case AccessPolicyNone:
+		return []string{}, nil
 	}
+	return nil, apiresponses.NewFailureResponse(
+		fmt.Errorf("unknown access policy %#v", policy),
+		http.StatusBadRequest,
+		"unknown-access-policy",
+	)
 }
@@ -2,9 +2,9 @@ package sqs_test
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/alphagov/paas-sqs-broker/sqs"
-	goformation "github.com/awslabs/goformation/v4"
 	goformationiam "github.com/awslabs/goformation/v4/cloudformation/iam"
 	goformationsecretsmanager "github.com/awslabs/goformation/v4/cloudformation/secretsmanager"
 	goformationtags "github.com/awslabs/goformation/v4/cloudformation/tags"
@@ -17,6 +17,7 @@ import (
 var _ = Describe("UserTemplate", func() {
 	var user *goformationiam.User
 	var policy *goformationiam.Policy
+	var secret *goformationsecretsmanager.Secret
 	var builder sqs.UserTemplateBuilder
 	var rawText string
 
@@ -28,7 +29,7 @@ var _ = Describe("UserTemplate", func() {
 		var err error
 		rawText, err = builder.Build()
 		Expect(err).ToNot(HaveOccurred())
-		template, err := goformation.ParseYAML([]byte(rawText))
+		template, err := parseTemplate(rawText)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(template.Resources).To(ContainElement(BeAssignableToTypeOf(&goformationiam.User{})))
 		Expect(template.Resources).To(ContainElement(BeAssignableToTypeOf(&goformationiam.AccessKey{})))
@@ -39,36 +40,152 @@ var _ = Describe("UserTemplate", func() {
 		Expect(ok).To(BeTrue())
 		policy, ok = template.Resources[sqs.ResourcePolicy].(*goformationiam.Policy)
 		Expect(ok).To(BeTrue())
-		_, ok = template.Resources[sqs.ResourceCredentials].(*goformationsecretsmanager.Secret)
+		secret, ok = template.Resources[sqs.ResourceCredentials].(*goformationsecretsmanager.Secret)
 		Expect(ok).To(BeTrue())
 	})
 
 	It("should not have any input parameters", func() {
 		text, err := builder.Build()
 		Expect(err).ToNot(HaveOccurred())
-		t, err := goformation.ParseYAML([]byte(text))
+		t, err := parseTemplate(text)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(t.Parameters).To(BeEmpty())
 	})
 
-	It("should create a template for a json blob containing provisioned credentials", func() {
-		processed, err := intrinsics.ProcessYAML([]byte(rawText), nil)
-		Expect(err).ToNot(HaveOccurred())
-		var result map[string]interface{}
-		err = json.Unmarshal(processed, &result)
-		Expect(err).ToNot(HaveOccurred())
-		resources := result["Resources"].(map[string]interface{})
-		resource := resources[sqs.ResourceCredentials].(map[string]interface{})
-		properties := resource["Properties"].(map[string]interface{})
-		value := properties["SecretString"].(string)
-		var credentials map[string]string
-		err = json.Unmarshal([]byte(value), &credentials)
-		Expect(err).ToNot(HaveOccurred())
-		Expect(credentials).To(HaveKey("aws_access_key_id"))
-		Expect(credentials).To(HaveKey("aws_secret_access_key"))
-		Expect(credentials).To(HaveKey("aws_region"))
-		Expect(credentials).To(HaveKey("primary_queue_url"))
-		Expect(credentials).To(HaveKey("secondary_queue_url"))
+	Context("when the queue has a dead-letter queue", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueURL = "https://sqs.eu-west-2.amazonaws.com/123456789012/q-pri"
+			builder.PrimaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:q-pri"
+			builder.SecondaryQueueURL = "https://sqs.eu-west-2.amazonaws.com/123456789012/q-sec"
+			builder.SecondaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:q-sec"
+		})
+
+		It("should create a template for a json blob containing provisioned credentials", func() {
+			credentials := credentialsFromTemplate(rawText)
+			Expect(credentials).To(HaveKey("aws_access_key_id"))
+			Expect(credentials).To(HaveKey("aws_secret_access_key"))
+			Expect(credentials).To(HaveKey("aws_region"))
+			Expect(credentials).To(HaveKeyWithValue("primary_queue_url", builder.PrimaryQueueURL))
+			Expect(credentials).To(HaveKeyWithValue("secondary_queue_url", builder.SecondaryQueueURL))
+		})
+
+		It("should describe both queues in the credentials", func() {
+			credentials := credentialsFromTemplate(rawText)
+			Expect(credentials).To(HaveKeyWithValue("schema_version", BeEquivalentTo(sqs.CredentialsSchemaVersion)))
+			Expect(credentials).To(HaveKeyWithValue("primary_queue_arn", builder.PrimaryQueueARN))
+			Expect(credentials).To(HaveKeyWithValue("primary_queue_name", "q-pri"))
+			Expect(credentials).To(HaveKeyWithValue("secondary_queue_arn", builder.SecondaryQueueARN))
+			Expect(credentials).To(HaveKeyWithValue("secondary_queue_name", "q-sec"))
+			Expect(credentials).To(HaveKeyWithValue("uri", "sqs://sqs.eu-west-2.amazonaws.com/123456789012/q-pri"))
+			Expect(credentials).To(HaveKeyWithValue("fifo", false))
+			Expect(credentials).To(HaveKeyWithValue("access_policy", sqs.AccessPolicyFull))
+			Expect(credentials).To(HaveKey("sqs_endpoint"))
+		})
+
+		It("should grant access to both queues", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Resource", ConsistOf(
+						builder.PrimaryQueueARN,
+						builder.SecondaryQueueARN,
+					)),
+				)))
+		})
+	})
+
+	Context("when the queue has no dead-letter queue", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueURL = "https://sqs.eu-west-2.amazonaws.com/123456789012/q-pri"
+			builder.PrimaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:q-pri"
+		})
+
+		It("should leave the secondary queue out of the credentials", func() {
+			credentials := credentialsFromTemplate(rawText)
+			Expect(credentials).To(HaveKeyWithValue("primary_queue_url", builder.PrimaryQueueURL))
+			Expect(credentials).ToNot(HaveKey("secondary_queue_url"))
+		})
+
+		It("should only grant access to the primary queue", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Resource", ConsistOf(
+						builder.PrimaryQueueARN,
+					)),
+				)))
+		})
+	})
+
+	Context("when the queue is a FIFO queue", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueURL = "https://sqs.eu-west-2.amazonaws.com/123456789012/q-pri.fifo"
+			builder.PrimaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:q-pri.fifo"
+		})
+
+		It("should say so in the credentials", func() {
+			credentials := credentialsFromTemplate(rawText)
+			Expect(credentials).To(HaveKeyWithValue("fifo", true))
+			Expect(credentials).To(HaveKeyWithValue("primary_queue_name", "q-pri.fifo"))
+		})
+	})
+
+	Context("when the SQS endpoint is configured", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:q-pri"
+			builder.SQSEndpoint = "https://vpce-1234.sqs.eu-west-2.vpce.amazonaws.com"
+		})
+
+		It("should give out that endpoint", func() {
+			credentials := credentialsFromTemplate(rawText)
+			Expect(credentials).To(HaveKeyWithValue("sqs_endpoint", builder.SQSEndpoint))
+		})
+	})
+
+	Context("when the instance has named queues", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:queue-pri"
+			builder.PrimaryQueueURL = "https://sqs.eu-west-2.amazonaws.com/123456789012/queue-pri"
+			builder.NamedQueues = map[string]sqs.NamedQueue{
+				"orders": {
+					PrimaryQueueARN:   "arn:aws:sqs:eu-west-2:123456789012:queue-orders-pri",
+					PrimaryQueueURL:   "https://sqs.eu-west-2.amazonaws.com/123456789012/queue-orders-pri",
+					SecondaryQueueARN: "arn:aws:sqs:eu-west-2:123456789012:queue-orders-sec",
+					SecondaryQueueURL: "https://sqs.eu-west-2.amazonaws.com/123456789012/queue-orders-sec",
+				},
+				"audit": {
+					PrimaryQueueARN: "arn:aws:sqs:eu-west-2:123456789012:queue-audit-pri",
+					PrimaryQueueURL: "https://sqs.eu-west-2.amazonaws.com/123456789012/queue-audit-pri",
+				},
+			}
+		})
+
+		It("should map each queue name to its queues in the credentials", func() {
+			credentials := credentialsFromTemplate(rawText)
+			Expect(credentials).To(HaveKeyWithValue("primary_queue_url", builder.PrimaryQueueURL))
+			Expect(credentials).To(HaveKeyWithValue("queues", And(
+				HaveKeyWithValue("orders", Equal(map[string]interface{}{
+					"primary_queue_url":   "https://sqs.eu-west-2.amazonaws.com/123456789012/queue-orders-pri",
+					"primary_queue_arn":   "arn:aws:sqs:eu-west-2:123456789012:queue-orders-pri",
+					"secondary_queue_url": "https://sqs.eu-west-2.amazonaws.com/123456789012/queue-orders-sec",
+					"secondary_queue_arn": "arn:aws:sqs:eu-west-2:123456789012:queue-orders-sec",
+				})),
+				HaveKeyWithValue("audit", Equal(map[string]interface{}{
+					"primary_queue_url": "https://sqs.eu-west-2.amazonaws.com/123456789012/queue-audit-pri",
+					"primary_queue_arn": "arn:aws:sqs:eu-west-2:123456789012:queue-audit-pri",
+				})),
+			)))
+		})
+
+		It("should grant access to every queue", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Resource", ConsistOf(
+						builder.PrimaryQueueARN,
+						"arn:aws:sqs:eu-west-2:123456789012:queue-audit-pri",
+						"arn:aws:sqs:eu-west-2:123456789012:queue-orders-pri",
+						"arn:aws:sqs:eu-west-2:123456789012:queue-orders-sec",
+					)),
+				)))
+		})
 	})
 
 	Context("when binding id and prefix are set", func() {
@@ -164,6 +281,178 @@ var _ = Describe("UserTemplate", func() {
 		})
 	})
 
+	Context("when the access policy is 'monitor'", func() {
+		BeforeEach(func() {
+			builder.AccessPolicy = "monitor"
+		})
+		It("should only allow reading the queue attributes", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Action", ConsistOf(
+						"sqs:GetQueueAttributes",
+						"sqs:GetQueueUrl",
+						"sqs:ListDeadLetterSourceQueues",
+						"sqs:ListQueueTags",
+					)),
+				)))
+		})
+	})
+
+	Context("when the dead-letter queue has its own access policy", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:q-pri"
+			builder.SecondaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:q-sec"
+			builder.NamedQueues = map[string]sqs.NamedQueue{
+				"orders": {
+					PrimaryQueueARN:   "arn:aws:sqs:eu-west-2:123456789012:orders-pri",
+					SecondaryQueueARN: "arn:aws:sqs:eu-west-2:123456789012:orders-sec",
+				},
+			}
+			builder.AccessPolicy = "producer"
+			builder.DeadLetterAccessPolicy = "consumer"
+		})
+
+		It("should have a statement for each set of queues", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					And(
+						HaveKeyWithValue("Action", ContainElement("sqs:SendMessage")),
+						HaveKeyWithValue("Resource", ConsistOf(
+							"arn:aws:sqs:eu-west-2:123456789012:q-pri",
+							"arn:aws:sqs:eu-west-2:123456789012:orders-pri",
+						)),
+					),
+					And(
+						HaveKeyWithValue("Action", ContainElement("sqs:ReceiveMessage")),
+						HaveKeyWithValue("Resource", ConsistOf(
+							"arn:aws:sqs:eu-west-2:123456789012:q-sec",
+							"arn:aws:sqs:eu-west-2:123456789012:orders-sec",
+						)),
+					),
+				)))
+		})
+
+		It("should give both access policies in the credentials", func() {
+			credentials := credentialsFromTemplate(rawText)
+			Expect(credentials).To(HaveKeyWithValue("access_policy", "producer"))
+			Expect(credentials).To(HaveKeyWithValue("dead_letter_access_policy", "consumer"))
+		})
+
+		It("should record both access policies in the metadata", func() {
+			params, err := sqs.ParseUserTemplateParams(rawText)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(params.AccessPolicy).To(Equal(sqs.AccessPolicyProducer))
+			Expect(params.DeadLetterAccessPolicy).To(Equal(sqs.AccessPolicyConsumer))
+		})
+
+		Context("and it is 'none'", func() {
+			BeforeEach(func() {
+				builder.DeadLetterAccessPolicy = "none"
+			})
+			It("should only grant access to the primary queues", func() {
+				Expect(policy.PolicyDocument).To(
+					HaveKeyWithValue("Statement", ConsistOf(
+						HaveKeyWithValue("Resource", ConsistOf(
+							"arn:aws:sqs:eu-west-2:123456789012:q-pri",
+							"arn:aws:sqs:eu-west-2:123456789012:orders-pri",
+						)),
+					)))
+			})
+		})
+
+		Context("and the primary queues' is 'none'", func() {
+			BeforeEach(func() {
+				builder.AccessPolicy = "none"
+			})
+			It("should only grant access to the dead-letter queues", func() {
+				Expect(policy.PolicyDocument).To(
+					HaveKeyWithValue("Statement", ConsistOf(
+						HaveKeyWithValue("Resource", ConsistOf(
+							"arn:aws:sqs:eu-west-2:123456789012:q-sec",
+							"arn:aws:sqs:eu-west-2:123456789012:orders-sec",
+						)),
+					)))
+			})
+		})
+	})
+
+	Context("when the binding has network restrictions", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:q-pri"
+			builder.SecondaryQueueARN = "arn:aws:sqs:eu-west-2:123456789012:q-sec"
+			builder.NetworkRestrictions = sqs.NetworkRestrictions{
+				SourceIPs:          []string{"203.0.113.0/24"},
+				SourceVPCEndpoints: []string{"vpce-1234"},
+			}
+		})
+
+		It("should deny access from anywhere else", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ContainElement(And(
+					HaveKeyWithValue("Effect", "Deny"),
+					HaveKeyWithValue("Action", ConsistOf("sqs:*")),
+					HaveKeyWithValue("Resource", ConsistOf(builder.PrimaryQueueARN, builder.SecondaryQueueARN)),
+					HaveKeyWithValue("Condition", Equal(map[string]interface{}{
+						"NotIpAddress": map[string]interface{}{
+							"aws:SourceIp": []interface{}{"203.0.113.0/24"},
+						},
+						"StringNotEquals": map[string]interface{}{
+							"aws:SourceVpce": []interface{}{"vpce-1234"},
+						},
+					})),
+				))))
+		})
+
+		It("should record them in the metadata", func() {
+			params, err := sqs.ParseUserTemplateParams(rawText)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(params.NetworkRestrictions).To(Equal(builder.NetworkRestrictions))
+		})
+	})
+
+	It("should not deny any access without network restrictions", func() {
+		Expect(policy.PolicyDocument).ToNot(
+			HaveKeyWithValue("Statement", ContainElement(HaveKeyWithValue("Effect", "Deny"))))
+	})
+
+	It("should retain the credentials secret for the broker to delete", func() {
+		Expect(string(secret.AWSCloudFormationDeletionPolicy)).To(Equal("Retain"))
+	})
+
+	It("should encrypt the credentials secret with the default key", func() {
+		Expect(secret.KmsKeyId).To(BeEmpty())
+	})
+
+	Context("when tags are given", func() {
+		BeforeEach(func() {
+			builder.Tags = map[string]string{"OrganizationName": "finance: payments"}
+		})
+
+		It("should tag the user and the credentials secret", func() {
+			Expect(user.Tags).To(ContainElement(goformationtags.Tag{Key: "OrganizationName", Value: "finance: payments"}))
+			Expect(secret.Tags).To(ContainElement(goformationtags.Tag{Key: "OrganizationName", Value: "finance: payments"}))
+		})
+	})
+
+	Context("when a KMS key is given for secrets", func() {
+		BeforeEach(func() {
+			builder.SecretKMSKey = "arn:aws:kms:eu-west-2:123456789012:key/secrets"
+		})
+
+		It("should encrypt the credentials secret with it", func() {
+			Expect(secret.KmsKeyId).To(Equal("arn:aws:kms:eu-west-2:123456789012:key/secrets"))
+		})
+	})
+
+	It("should return an error when the binding would have access to no queues", func() {
+		_, err := sqs.UserTemplateBuilder{
+			PrimaryQueueARN:        "arn:aws:sqs:eu-west-2:123456789012:q-pri",
+			AccessPolicy:           "none",
+			DeadLetterAccessPolicy: "full",
+		}.Build()
+		Expect(err).To(MatchError("the access policies give the binding access to none of the queues"))
+	})
+
 	Context("when additional user policy is not set", func() {
 		It("should not set an additional policy", func() {
 			Expect(user.ManagedPolicyArns).To(BeEmpty())
@@ -188,6 +477,40 @@ var _ = Describe("UserTemplate", func() {
 		Expect(err).To(MatchError("unknown access policy \"bananas\""))
 	})
 
+	It("should use operator-defined access policies", func() {
+		t, err := sqs.UserTemplateBuilder{
+			AccessPolicy: "peek",
+			AccessPolicies: map[string][]string{
+				"peek": {"sqs:ReceiveMessage", "sqs:GetQueueUrl"},
+			},
+		}.Build()
+		Expect(err).ToNot(HaveOccurred())
+		template, err := parseTemplate(t)
+		Expect(err).ToNot(HaveOccurred())
+		policy := template.Resources[sqs.ResourcePolicy].(*goformationiam.Policy)
+		Expect(policy.PolicyDocument).To(
+			HaveKeyWithValue("Statement", ConsistOf(
+				HaveKeyWithValue("Action", Equal([]interface{}{"sqs:GetQueueUrl", "sqs:ReceiveMessage"})),
+			)))
+	})
+
+	It("should use an operator-defined monitor access policy in place of the built-in one", func() {
+		t, err := sqs.UserTemplateBuilder{
+			AccessPolicy: sqs.AccessPolicyMonitor,
+			AccessPolicies: map[string][]string{
+				"monitor": {"sqs:ListQueueTags", "sqs:GetQueueAttributes"},
+			},
+		}.Build()
+		Expect(err).ToNot(HaveOccurred())
+		template, err := parseTemplate(t)
+		Expect(err).ToNot(HaveOccurred())
+		policy := template.Resources[sqs.ResourcePolicy].(*goformationiam.Policy)
+		Expect(policy.PolicyDocument).To(
+			HaveKeyWithValue("Statement", ConsistOf(
+				HaveKeyWithValue("Action", Equal([]interface{}{"sqs:GetQueueAttributes", "sqs:ListQueueTags"})),
+			)))
+	})
+
 	Context("when queue ARNs are set", func() {
 		BeforeEach(func() {
 			builder.PrimaryQueueARN = "abc"
@@ -214,6 +537,27 @@ var _ = Describe("UserTemplate", func() {
 		})
 	})
 
+	Context("when a kms key is set", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "abc"
+			builder.SecondaryQueueARN = "qwe"
+			builder.KmsKeyARN = "arn:kms:key"
+		})
+		It("should grant use of the key alongside the queue permissions", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Resource", ConsistOf("abc", "qwe")),
+					And(
+						HaveKeyWithValue("Effect", "Allow"),
+						HaveKeyWithValue("Resource", ConsistOf("arn:kms:key")),
+						HaveKeyWithValue("Action", ConsistOf(
+							"kms:Decrypt",
+							"kms:GenerateDataKey",
+						))),
+				)))
+		})
+	})
+
 	It("should create an active access key", func() {
 		var result map[string]interface{}
 		Expect(yaml.Unmarshal([]byte(rawText), &result)).To(Succeed())
@@ -225,13 +569,112 @@ var _ = Describe("UserTemplate", func() {
 		Expect(properties).To(HaveKeyWithValue("UserName", HaveKeyWithValue("Ref", sqs.ResourceUser)))
 	})
 
+	Context("while an access key is being rotated", func() {
+		BeforeEach(func() {
+			createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			builder.AccessKeys = sqs.AccessKeys{
+				Current: sqs.AccessKey{Serial: 1, CreatedAt: &createdAt},
+			}.Rotate(createdAt.Add(time.Hour), 24*time.Hour)
+		})
+
+		It("should keep both access keys active", func() {
+			var result map[string]interface{}
+			Expect(yaml.Unmarshal([]byte(rawText), &result)).To(Succeed())
+			resources := result["Resources"].(map[interface{}]interface{})
+			Expect(resources).To(HaveKey(sqs.ResourceAccessKey))
+			Expect(resources).To(HaveKey(sqs.ResourceAccessKey + "2"))
+		})
+
+		It("should give out the new access key", func() {
+			Expect(rawText).To(ContainSubstring("${IAMAccessKey2}"))
+			Expect(rawText).To(ContainSubstring("${IAMAccessKey2.SecretAccessKey}"))
+		})
+
+		It("should record the access keys in the metadata", func() {
+			keys, err := sqs.ParseAccessKeys(rawText)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys.Current.Serial).To(Equal(2))
+			Expect(keys.Retiring).ToNot(BeNil())
+			Expect(keys.Retiring.Serial).To(Equal(1))
+			Expect(*keys.Retiring.RetireAt).To(BeTemporally("==", time.Date(2020, 1, 2, 1, 0, 0, 0, time.UTC)))
+		})
+	})
+
+	It("should default to a single access key for templates without metadata", func() {
+		keys, err := sqs.ParseAccessKeys(legacyBindingTemplate)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(Equal(sqs.AccessKeys{Current: sqs.AccessKey{Serial: 1}}))
+		Expect(keys.Current.LogicalID()).To(Equal(sqs.ResourceAccessKey))
+	})
+
 	It("should have an output for the secretsmanager path to credentials", func() {
 		text, err := builder.Build()
 		Expect(err).ToNot(HaveOccurred())
-		t, err := goformation.ParseYAML([]byte(text))
+		t, err := parseTemplate(text)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(t.Outputs).To(And(
 			HaveKey(sqs.OutputCredentialsARN),
 		))
 	})
 })
+
+var _ = Describe("UserTemplate for role-based bindings", func() {
+	var builder sqs.UserTemplateBuilder
+
+	BeforeEach(func() {
+		builder = sqs.UserTemplateBuilder{
+			BindingID:        "binding-guid",
+			PrimaryQueueURL:  "https://sqs.eu-west-2.amazonaws.com/123456789012/q-pri",
+			PrimaryQueueARN:  "arn:aws:sqs:eu-west-2:123456789012:q-pri",
+			Role:             &sqs.BindingRole{ExternalID: "external-id"},
+			TrustedPrincipal: "arn:aws:iam::123456789012:role/apps",
+		}
+	})
+
+	It("should give out the role and external ID instead of an access key", func() {
+		rawText, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		credentials := credentialsFromTemplate(rawText)
+		Expect(credentials).To(HaveKeyWithValue("aws_external_id", "external-id"))
+		Expect(credentials).To(HaveKey("aws_role_arn"))
+		Expect(credentials).ToNot(HaveKey("aws_access_key_id"))
+		Expect(credentials).ToNot(HaveKey("aws_secret_access_key"))
+	})
+
+	It("should record the role in the metadata", func() {
+		rawText, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		role, err := sqs.ParseBindingRole(rawText)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(role).To(Equal(&sqs.BindingRole{ExternalID: "external-id"}))
+	})
+
+	It("should require a trusted principal", func() {
+		builder.TrustedPrincipal = ""
+		_, err := builder.Build()
+		Expect(err).To(MatchError("role-based bindings need a trusted principal"))
+	})
+
+	It("should not find a role in user-based templates", func() {
+		builder.Role = nil
+		rawText, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sqs.ParseBindingRole(rawText)).To(BeNil())
+	})
+})
+
+func credentialsFromTemplate(rawText string) map[string]interface{} {
+	processed, err := intrinsics.ProcessYAML([]byte(rawText), nil)
+	Expect(err).ToNot(HaveOccurred())
+	var result map[string]interface{}
+	err = json.Unmarshal(processed, &result)
+	Expect(err).ToNot(HaveOccurred())
+	resources := result["Resources"].(map[string]interface{})
+	resource := resources[sqs.ResourceCredentials].(map[string]interface{})
+	properties := resource["Properties"].(map[string]interface{})
+	value := properties["SecretString"].(string)
+	var credentials map[string]interface{}
+	err = json.Unmarshal([]byte(value), &credentials)
+	Expect(err).ToNot(HaveOccurred())
+	return credentials
+}
@@ -3,6 +3,7 @@ package broker_test
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"time"
 
 	. "github.com/alphagov/paas-sqs-broker/testing/matchers"
@@ -230,6 +231,39 @@ var _ = DescribeIntegrationTest("broker integration tests", func() {
 				Eventually(updateState).Should(BeSuccessState())
 			})
 
+			By("retrieving the instance", func() {
+				res := broker.Get("/v2/service_instances/"+instanceID, url.Values{})
+				Expect(res.Code).To(Equal(http.StatusOK))
+
+				var instance struct {
+					PlanID     string `json:"plan_id"`
+					Parameters struct {
+						DelaySeconds           int    `json:"delay_seconds"`
+						RedriveMaxReceiveCount int    `json:"redrive_max_receive_count"`
+						PrimaryQueueURL        string `json:"primary_queue_url"`
+					} `json:"parameters"`
+				}
+				Expect(json.NewDecoder(res.Body).Decode(&instance)).To(Succeed())
+				Expect(instance.PlanID).To(Equal(provisionValues.PlanID))
+				Expect(instance.Parameters.DelaySeconds).To(Equal(30))
+				Expect(instance.Parameters.RedriveMaxReceiveCount).To(Equal(3))
+				Expect(instance.Parameters.PrimaryQueueURL).To(Equal(binding.Credentials.PrimaryQueueURL))
+			})
+
+			By("updating again with the same parameters", func() {
+				res := broker.Update(instanceID, brokertesting.RequestBody{
+					ServiceID: provisionValues.ServiceID,
+					PlanID:    provisionValues.PlanID,
+					Parameters: &brokertesting.ConfigurationValues{
+						"delay_seconds":             30,
+						"redrive_max_receive_count": 3,
+					},
+					PreviousValues: &provisionValues,
+				}, ASYNC)
+
+				Expect(res.Code).To(Equal(http.StatusOK))
+			})
+
 			By("using binding credentials to access the service", func() {
 				sess := session.Must(session.NewSession(&aws.Config{
 					Region: aws.String(binding.Credentials.AWSRegion),
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+)
+
+// upgrade brings existing queue and binding stacks up to date with the
+// broker's current templates.  It is run as:
+//
+//	paas-sqs-broker upgrade -config config.json [-dry-run] [-concurrency n] [-report file [-resume]]
+func upgrade(args []string) {
+	flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	configFilePath := flags.String("config", "", "Location of the config file")
+	dryRun := flags.Bool("dry-run", false, "Report outdated stacks without upgrading them")
+	concurrency := flags.Int("concurrency", 5, "Number of stacks to upgrade at once")
+	reportPath := flags.String("report", "", "File to append a JSON report line per stack to, instead of stdout")
+	resume := flags.Bool("resume", false, "Skip stacks that the report file shows were already upgraded")
+	flags.Parse(args)
+
+	if *resume && *reportPath == "" {
+		log.Fatalf("-resume requires -report")
+	}
+
+	_, _, sqsProvider := newProvider(*configFilePath)
+	upgrader := &sqs.Upgrader{
+		Provider:    sqsProvider,
+		Concurrency: *concurrency,
+		DryRun:      *dryRun,
+	}
+
+	var out io.Writer = os.Stdout
+	if *reportPath != "" {
+		if *resume {
+			upgrader.Skip = readUpgradeReports(*reportPath)
+		}
+		file, err := os.OpenFile(*reportPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Error opening report file %s: %s\n", *reportPath, err)
+		}
+		defer file.Close()
+		out = file
+	}
+	encoder := json.NewEncoder(out)
+	upgrader.Report = func(report sqs.UpgradeReport) {
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalf("Error writing report: %s\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", report.Kind, report.StackName, report.Result)
+	}
+
+	if err := upgrader.Run(context.Background()); err != nil {
+		log.Fatalf("Error upgrading stacks: %s\n", err)
+	}
+}
+
+func readUpgradeReports(path string) map[string]bool {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Fatalf("Error opening report file %s: %s\n", path, err)
+	}
+	defer file.Close()
+	done, err := sqs.ReadUpgradeReports(file)
+	if err != nil {
+		log.Fatalf("Error reading report file %s: %s\n", path, err)
+	}
+	return done
+}